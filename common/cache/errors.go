@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/go-redsync/redsync/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+// classify tags a redis error with its errorx classes: a missing key is
+// ClassNotFound, a lock held by someone else is a retryable ClassConflict and
+// connection failures are retryable. The original error stays in the chain,
+// so errors.Is(err, redis.Nil) keeps working.
+func classify(err error) error {
+	var taken *redsync.ErrTaken
+	var opErr *net.OpError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, redis.Nil):
+		return errorx.Tag(err, errorx.ClassNotFound)
+	case errors.Is(err, redsync.ErrFailed), errors.As(err, &taken):
+		return errorx.Tag(err, errorx.ClassConflict|errorx.ClassRetryable)
+	case errors.Is(err, redsync.ErrLockAlreadyExpired):
+		return errorx.Tag(err, errorx.ClassConflict)
+	case errors.As(err, &opErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errorx.Tag(err, errorx.ClassRetryable)
+	}
+	return err
+}
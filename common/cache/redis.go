@@ -34,7 +34,7 @@ func NewRedisCache(config RedisConfig) (*cacheRedis, error) {
 
 	_, err := client.Ping(context.Background()).Result()
 	if err != nil {
-		return nil, err
+		return nil, classify(err)
 	}
 
 	pool := goredis.NewPool(client)
@@ -59,39 +59,82 @@ func (r *cacheRedis) Set(key string, value interface{}, expireTime *time.Duratio
 	rKey := fmt.Sprintf("%s:%s", r.service, key)
 	if expireTime == nil {
 		err := r.redisClient.Set(context.Background(), rKey, value, 0).Err()
-		return err
+		return classify(err)
 	}
 	err := r.redisClient.Set(context.Background(), rKey, value, *expireTime).Err()
-	return err
+	return classify(err)
+}
+
+// SetNX sets key only if it does not exist yet and reports whether it did.
+// A zero expireTime keeps the key forever.
+func (r *cacheRedis) SetNX(key string, value interface{}, expireTime time.Duration) (bool, error) {
+	rKey := fmt.Sprintf("%s:%s", r.service, key)
+	ok, err := r.redisClient.SetNX(context.Background(), rKey, value, expireTime).Result()
+	return ok, classify(err)
+}
+
+var compareAndSetScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// CompareAndSet sets key to value only if it holds old, atomically, and
+// reports whether it did. A zero expireTime keeps the key forever.
+func (r *cacheRedis) CompareAndSet(key string, old, value interface{}, expireTime time.Duration) (bool, error) {
+	rKey := fmt.Sprintf("%s:%s", r.service, key)
+	n, err := compareAndSetScript.Run(context.Background(), r.redisClient, []string{rKey}, old, value, expireTime.Milliseconds()).Int()
+	return n == 1, classify(err)
+}
+
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call("DEL", KEYS[1])
+`)
+
+// CompareAndDelete deletes key only if it holds value, atomically, and
+// reports whether it did.
+func (r *cacheRedis) CompareAndDelete(key string, value interface{}) (bool, error) {
+	rKey := fmt.Sprintf("%s:%s", r.service, key)
+	n, err := compareAndDeleteScript.Run(context.Background(), r.redisClient, []string{rKey}, value).Int()
+	return n == 1, classify(err)
 }
 
 func (r *cacheRedis) Get(key string) (interface{}, error) {
 	rKey := fmt.Sprintf("%s:%s", r.service, key)
 	val, err := r.redisClient.Get(context.Background(), rKey).Result()
-	return val, err
+	return val, classify(err)
 }
 
 func (r *cacheRedis) GetAll() ([]string, error) {
 	keys, err := r.redisClient.Keys(context.Background(), fmt.Sprintf("%s:*", r.service)).Result()
-	return keys, err
+	return keys, classify(err)
 }
 
 func (r *cacheRedis) GetWithPattern(pattern string) ([]string, error) {
 	rKey := fmt.Sprintf("%s:%s", r.service, pattern)
 
 	keys, err := r.redisClient.Keys(context.Background(), rKey).Result()
-	return keys, err
+	return keys, classify(err)
 }
 
 func (r *cacheRedis) Delete(key string) error {
 	rKey := fmt.Sprintf("%s:%s", r.service, key)
 	err := r.redisClient.Del(context.Background(), rKey).Err()
-	return err
+	return classify(err)
 }
 
 func (r *cacheRedis) Clear() error {
 	err := r.redisClient.FlushDB(context.Background()).Err()
-	return err
+	return classify(err)
 }
 
 func (r *cacheRedis) ClearWithPattern(pattern string) error {
@@ -99,12 +142,12 @@ func (r *cacheRedis) ClearWithPattern(pattern string) error {
 
 	keys, err := r.redisClient.Keys(context.Background(), rKey).Result()
 	if err != nil {
-		return err
+		return classify(err)
 	}
 	for _, key := range keys {
 		err = r.redisClient.Del(context.Background(), key).Err()
 		if err != nil {
-			return err
+			return classify(err)
 		}
 	}
 	return nil
@@ -115,12 +158,12 @@ func (r *cacheRedis) Lock(key string, ttl time.Duration) (*redsync.Mutex, error)
 	mutex := r.rsync.NewMutex(fmt.Sprintf("%s:%s", r.service, key), redsync.WithExpiry(ttl))
 	err := mutex.Lock()
 	if err != nil {
-		return nil, err
+		return nil, classify(err)
 	}
 	return mutex, nil
 }
 
 func (r *cacheRedis) Unlock(m *redsync.Mutex) error {
 	_, err := m.Unlock()
-	return err
+	return classify(err)
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/go-redsync/redsync/v4"
 	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
 	"github.com/redis/go-redis/v9"
+	"github.com/solum-sp/aps-be-common/common/errorx"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -59,6 +60,59 @@ func TestSetWithoutExpireTime(t *testing.T) {
 	assert.Equal(t, "test-value", val)
 }
 
+func TestSetNX(t *testing.T) {
+	cache, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	ok, err := cache.SetNX("test-key", "first", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = cache.SetNX("test-key", "second", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	val, err := cache.Get("test-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "first", val)
+}
+
+func TestCompareAndSet(t *testing.T) {
+	cache, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	cache.Set("test-key", "first", nil)
+	ok, err := cache.CompareAndSet("test-key", "other", "second", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = cache.CompareAndSet("test-key", "first", "second", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	val, _ := cache.Get("test-key")
+	assert.Equal(t, "second", val)
+
+	ok, err = cache.CompareAndSet("missing-key", "first", "second", 0)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	cache, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	cache.Set("test-key", "first", nil)
+	ok, err := cache.CompareAndDelete("test-key", "other")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = cache.CompareAndDelete("test-key", "first")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = cache.Get("test-key")
+	assert.True(t, errorx.IsNotFound(err))
+}
+
 func TestDelete(t *testing.T) {
 	cache, cleanup := setupTestRedis(t)
 	defer cleanup()
@@ -71,6 +125,9 @@ func TestDelete(t *testing.T) {
 
 	val, err := cache.Get("test-key")
 	assert.Error(t, err)
+	assert.ErrorIs(t, err, redis.Nil)
+	assert.True(t, errorx.IsNotFound(err))
+	assert.False(t, errorx.IsRetryable(err))
 	assert.Empty(t, val)
 }
 
@@ -176,6 +233,8 @@ func TestDistributedLock(t *testing.T) {
 	// Test concurrent lock
 	mutex2, err := cache.Lock("test-key", 10*time.Second)
 	assert.Error(t, err)
+	assert.True(t, errorx.IsConflict(err))
+	assert.True(t, errorx.IsRetryable(err))
 	assert.Nil(t, mutex2)
 
 	// Test unlock
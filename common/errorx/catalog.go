@@ -0,0 +1,66 @@
+package errorx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// CatalogEntry is one code of a catalog file. In JSON an entry is either the
+// message alone or an object carrying metadata:
+//
+//	{
+//	  "104041": "User does not exist",
+//	  "104042": {
+//	    "message": "Profile {{.id}} does not exist",
+//	    "name": "ProfileNotFound",
+//	    "http_status": 404,
+//	    "severity": "warning",
+//	    "description": "The profile was deleted or never created"
+//	  }
+//	}
+type CatalogEntry struct {
+	Message     string `json:"message"`
+	Name        string `json:"name,omitempty"`        // Go identifier used by the code generator
+	HTTPStatus  int    `json:"http_status,omitempty"` // Overrides the status derived from the code
+	Severity    string `json:"severity,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func (e *CatalogEntry) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*e = CatalogEntry{}
+		return json.Unmarshal(data, &e.Message)
+	}
+	type plain CatalogEntry
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*e = CatalogEntry(p)
+	return nil
+}
+
+// MarshalJSON writes entries without metadata as a plain message, keeping
+// simple catalogs simple.
+func (e CatalogEntry) MarshalJSON() ([]byte, error) {
+	if e == (CatalogEntry{Message: e.Message}) {
+		return json.Marshal(e.Message)
+	}
+	type plain CatalogEntry
+	return json.Marshal(plain(e))
+}
+
+// ParseCatalog decodes a catalog file.
+func ParseCatalog(data []byte) (map[string]CatalogEntry, error) {
+	catalog := make(map[string]CatalogEntry)
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, err
+	}
+	for code, e := range catalog {
+		if e.HTTPStatus != 0 && (e.HTTPStatus < 100 || e.HTTPStatus > 599) {
+			return nil, fmt.Errorf("error code %s has invalid http_status %d", code, e.HTTPStatus)
+		}
+	}
+	return catalog, nil
+}
@@ -0,0 +1,42 @@
+package errorx
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCatalog(t *testing.T) {
+	catalog, err := ParseCatalog([]byte(`{
+		"104041": "User does not exist",
+		"104042": {"message": "Profile does not exist", "name": "ProfileNotFound", "http_status": 410, "severity": "warning"}
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, CatalogEntry{Message: "User does not exist"}, catalog["104041"])
+	assert.Equal(t, CatalogEntry{Message: "Profile does not exist", Name: "ProfileNotFound", HTTPStatus: 410, Severity: "warning"}, catalog["104042"])
+
+	data, err := json.Marshal(catalog)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"104041": "User does not exist",
+		"104042": {"message": "Profile does not exist", "name": "ProfileNotFound", "http_status": 410, "severity": "warning"}
+	}`, string(data))
+
+	_, err = ParseCatalog([]byte(`{"104041": {"message": "x", "http_status": 42}}`))
+	assert.Error(t, err)
+}
+
+func TestCatalogMetadataStatus(t *testing.T) {
+	r := NewRegistry()
+	fsys := fstest.MapFS{"errors.json": {Data: []byte(`{"104042": {"message": "Profile does not exist", "http_status": 410}}`)}}
+	assert.NoError(t, r.LoadFS(fsys, "errors.json"))
+
+	assert.Equal(t, http.StatusGone, r.StatusForCode("104042"))
+	assert.Equal(t, "Profile does not exist", r.GetMessage("104042"))
+	entry, ok := r.Entry("104042")
+	assert.True(t, ok)
+	assert.Equal(t, 410, entry.HTTPStatus)
+}
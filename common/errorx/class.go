@@ -0,0 +1,175 @@
+package errorx
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Class is a set of attributes telling callers how to react to an error:
+// whether to retry it, report it to the client or page someone.
+type Class uint
+
+const (
+	ClassRetryable  Class = 1 << iota // The operation may succeed if retried
+	ClassTimeout                      // The operation did not complete in time
+	ClassConflict                     // The operation conflicts with the current state
+	ClassValidation                   // The input is invalid; retrying will not help
+	ClassNotFound                     // The requested resource does not exist
+	ClassInternal                     // A server side failure not meant for the client
+)
+
+var classNames = []struct {
+	class Class
+	name  string
+}{
+	{ClassRetryable, "retryable"},
+	{ClassTimeout, "timeout"},
+	{ClassConflict, "conflict"},
+	{ClassValidation, "validation"},
+	{ClassNotFound, "not_found"},
+	{ClassInternal, "internal"},
+}
+
+// Has reports whether c contains all the classes of other.
+func (c Class) Has(other Class) bool {
+	return other != 0 && c&other == other
+}
+
+func (c Class) String() string {
+	var names []string
+	for _, n := range classNames {
+		if c.Has(n.class) {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// WithClass returns a copy of the error tagged with class, in addition to the
+// classes derived from its HTTP status.
+func (e *CustomError) WithClass(class Class) *CustomError {
+	c := e.clone()
+	c.class |= class
+	return c
+}
+
+// Class returns the classes of the error: the ones it was tagged with and the
+// ones implied by its HTTP status, e.g. 404 is ClassNotFound and 503 is
+// ClassRetryable.
+func (e *CustomError) Class() Class {
+	return e.class | classForStatus(e.HTTPStatus())
+}
+
+func classForStatus(status int) Class {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ClassValidation
+	case http.StatusNotFound, http.StatusGone:
+		return ClassNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ClassConflict
+	case http.StatusRequestTimeout:
+		return ClassTimeout | ClassRetryable
+	case http.StatusTooManyRequests:
+		return ClassRetryable
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return ClassInternal | ClassRetryable
+	case http.StatusGatewayTimeout:
+		return ClassInternal | ClassTimeout | ClassRetryable
+	}
+	if status >= 500 {
+		return ClassInternal
+	}
+	return 0
+}
+
+// classifiedError tags an error that is not a *CustomError.
+type classifiedError struct {
+	err   error
+	class Class
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+func (e *classifiedError) Unwrap() error { return e.err }
+func (e *classifiedError) Class() Class  { return e.class }
+
+// Tag returns err tagged with class. The returned error keeps the message of
+// err and unwraps to it, so errors.Is and errors.As work as before. Tag
+// returns nil if err is nil.
+func Tag(err error, class Class) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*CustomError); ok {
+		return e.WithClass(class)
+	}
+	return &classifiedError{err: err, class: class}
+}
+
+// ClassOf returns the union of the classes found in err's chain. Besides
+// tagged errors and *CustomError, context.DeadlineExceeded and errors with a
+// Timeout() or Temporary() method returning true, such as net.Error, are
+// classified as timeouts and retryable.
+func ClassOf(err error) Class {
+	var class Class
+	walk(err, func(err error) {
+		if c, ok := err.(interface{ Class() Class }); ok {
+			class |= c.Class()
+		}
+		if t, ok := err.(interface{ Timeout() bool }); ok && t.Timeout() {
+			class |= ClassTimeout | ClassRetryable
+		}
+		if t, ok := err.(interface{ Temporary() bool }); ok && t.Temporary() {
+			class |= ClassRetryable
+		}
+		if err == context.DeadlineExceeded {
+			class |= ClassTimeout | ClassRetryable
+		}
+	})
+	return class
+}
+
+func walk(err error, fn func(error)) {
+	for err != nil {
+		fn(err)
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			err = u.Unwrap()
+		case interface{ Unwrap() []error }:
+			for _, e := range u.Unwrap() {
+				walk(e, fn)
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+// IsRetryable reports whether the operation that failed with err may succeed
+// if retried.
+func IsRetryable(err error) bool { return ClassOf(err).Has(ClassRetryable) }
+
+// IsTimeout reports whether err is a timeout.
+func IsTimeout(err error) bool { return ClassOf(err).Has(ClassTimeout) }
+
+// IsConflict reports whether err is a conflict with the current state.
+func IsConflict(err error) bool { return ClassOf(err).Has(ClassConflict) }
+
+// IsValidation reports whether err is caused by invalid input.
+func IsValidation(err error) bool { return ClassOf(err).Has(ClassValidation) }
+
+// IsNotFound reports whether err is about a missing resource.
+func IsNotFound(err error) bool { return ClassOf(err).Has(ClassNotFound) }
+
+// IsInternal reports whether err is a server side failure whose details
+// should not be shown to the client.
+func IsInternal(err error) bool { return ClassOf(err).Has(ClassInternal) }
+
+// IsUserFacing reports whether err can be reported to the client as is: a
+// *CustomError or *ValidationError in its chain that is not internal.
+func IsUserFacing(err error) bool {
+	e, ok := asCustomError(err)
+	return ok && !e.Class().Has(ClassInternal)
+}
@@ -0,0 +1,62 @@
+package errorx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassFromStatus(t *testing.T) {
+	assert.True(t, IsNotFound(New("104041", "User does not exist")))
+	assert.True(t, IsValidation(New("104001", "Bad input")))
+	assert.True(t, IsConflict(New("104091", "Already exists")))
+	assert.True(t, IsRetryable(New("105031", "Unavailable")))
+	assert.True(t, IsInternal(New("105001", "Internal")))
+	assert.False(t, IsRetryable(New("105001", "Internal")))
+	assert.True(t, IsInternal(New("unknown_code", "Boom")))
+}
+
+func TestTagThroughChain(t *testing.T) {
+	base := errors.New("connection reset")
+	err := fmt.Errorf("loading user: %w", Tag(base, ClassRetryable))
+	assert.True(t, IsRetryable(err))
+	assert.ErrorIs(t, err, base)
+	assert.Equal(t, "loading user: connection reset", err.Error())
+
+	wrapped := Wrap(err, "105001")
+	assert.True(t, IsRetryable(wrapped))
+	assert.True(t, IsInternal(wrapped))
+	assert.Equal(t, ClassRetryable|ClassInternal, ClassOf(wrapped))
+
+	joined := errors.Join(errors.New("other"), Tag(base, ClassConflict))
+	assert.True(t, IsConflict(joined))
+
+	tagged := Tag(New("104041", "User does not exist"), ClassRetryable)
+	assert.IsType(t, &CustomError{}, tagged)
+	assert.True(t, IsRetryable(tagged))
+	assert.True(t, IsNotFound(tagged))
+
+	assert.Nil(t, Tag(nil, ClassRetryable))
+	assert.Equal(t, Class(0), ClassOf(nil))
+	assert.False(t, IsRetryable(errors.New("plain")))
+}
+
+func TestClassOfStandardErrors(t *testing.T) {
+	assert.True(t, IsTimeout(fmt.Errorf("query: %w", context.DeadlineExceeded)))
+	assert.True(t, IsRetryable(context.DeadlineExceeded))
+	assert.False(t, IsRetryable(context.Canceled))
+
+	var netErr net.Error = &net.DNSError{Err: "timeout", IsTimeout: true}
+	assert.True(t, IsTimeout(netErr))
+}
+
+func TestIsUserFacing(t *testing.T) {
+	assert.True(t, IsUserFacing(New("104041", "User does not exist")))
+	assert.False(t, IsUserFacing(New("105001", "Internal")))
+	assert.False(t, IsUserFacing(errors.New("plain")))
+	assert.Equal(t, "retryable|not_found", (ClassRetryable | ClassNotFound).String())
+}
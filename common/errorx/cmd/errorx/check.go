@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+// checkReport lists the differences between the sources and the catalog.
+type checkReport struct {
+	Missing []reference // Codes referenced in the sources but absent from the catalog
+	Unused  []string    // Catalog codes referenced nowhere
+	Stale   bool        // The generated file does not match the catalog
+}
+
+func (r checkReport) failed(strict bool) bool {
+	return len(r.Missing) > 0 || r.Stale || strict && len(r.Unused) > 0
+}
+
+func (r checkReport) print(w io.Writer, genPath string) {
+	for _, ref := range r.Missing {
+		fmt.Fprintf(w, "%s: code %s is not in the catalog\n", ref.Pos, ref.Code)
+	}
+	if r.Stale {
+		fmt.Fprintf(w, "%s is out of date, run errorx gen\n", genPath)
+	}
+	for _, code := range r.Unused {
+		fmt.Fprintf(w, "code %s is not referenced\n", code)
+	}
+}
+
+// check compares the literal codes and generated identifiers used under
+// roots with catalog. genPath is the generated file, "" if there is none.
+func check(roots []string, catalog map[string]errorx.CatalogEntry, genPath, pkg string) (checkReport, error) {
+	var report checkReport
+
+	var (
+		refs      []reference
+		literals  = make(map[string]bool)
+		selectors = make(map[string]bool)
+		absGen, _ = filepath.Abs(genPath)
+	)
+	err := walkGoFiles(roots, func(fset *token.FileSet, file *ast.File) error {
+		if abs, _ := filepath.Abs(fset.File(file.Pos()).Name()); genPath != "" && abs == absGen {
+			return nil
+		}
+		refs = append(refs, fileReferences(fset, file)...)
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				selectors[n.Sel.Name] = true
+			case *ast.BasicLit:
+				if s, err := strconv.Unquote(n.Value); n.Kind == token.STRING && err == nil {
+					literals[s] = true
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, ref := range refs {
+		if _, ok := catalog[ref.Code]; !ok {
+			report.Missing = append(report.Missing, ref)
+		}
+	}
+
+	var names map[string]string
+	if genPath != "" {
+		want, err := generate(pkg, catalog)
+		if err != nil {
+			return report, err
+		}
+		got, err := os.ReadFile(genPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return report, err
+		}
+		report.Stale = !bytes.Equal(want, got)
+		if names, err = assignNames(catalog); err != nil {
+			return report, err
+		}
+	}
+
+	for code := range catalog {
+		name := names[code]
+		if literals[code] || name != "" && (selectors[name] || selectors["Code"+name]) {
+			continue
+		}
+		report.Unused = append(report.Unused, code)
+	}
+	sort.Strings(report.Unused)
+	return report, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+const errorxImportPath = "github.com/solum-sp/aps-be-common/common/errorx"
+
+// reference is a literal error code passed to errorx.Get, New, Newf or Wrap
+// in the sources.
+type reference struct {
+	Code    string
+	Message string // Only set for Get and New, the calls carrying a message
+	Pos     token.Position
+}
+
+// codeArgs gives, for each errorx function taking a code, the position of the
+// code argument and of the message argument (-1 if there is none).
+var codeArgs = map[string][2]int{
+	"Get":  {0, 1},
+	"New":  {0, 1},
+	"Newf": {0, -1},
+	"Wrap": {1, -1},
+}
+
+// scanReferences walks the given roots and returns every errorx call whose
+// code is a string literal.
+func scanReferences(roots []string) ([]reference, error) {
+	var refs []reference
+	err := walkGoFiles(roots, func(fset *token.FileSet, file *ast.File) error {
+		refs = append(refs, fileReferences(fset, file)...)
+		return nil
+	})
+	return refs, err
+}
+
+// walkGoFiles parses the Go files under roots and calls fn for each of them.
+// A root ending in "/..." is walked recursively, otherwise only the
+// directory itself is scanned.
+func walkGoFiles(roots []string, fn func(fset *token.FileSet, file *ast.File) error) error {
+	fset := token.NewFileSet()
+	for _, root := range roots {
+		dir, recursive := strings.CutSuffix(root, "/...")
+		if dir == "" {
+			dir = "."
+		}
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != dir && (!recursive || name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(path, ".go") {
+				return nil
+			}
+			file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution|parser.ParseComments)
+			if err != nil {
+				return err
+			}
+			return fn(fset, file)
+		})
+		if err != nil {
+			return fmt.Errorf("scanning %s failed: %w", root, err)
+		}
+	}
+	return nil
+}
+
+func fileReferences(fset *token.FileSet, file *ast.File) []reference {
+	pkgName := importName(file)
+	if pkgName == "" {
+		return nil
+	}
+
+	var refs []reference
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		args, ok := codeArgs[sel.Sel.Name]
+		if !ok || len(call.Args) <= args[0] {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); !ok || x.Name != pkgName {
+			return true
+		}
+		code, ok := stringLiteral(call.Args[args[0]])
+		if !ok {
+			return true
+		}
+		ref := reference{Code: code, Pos: fset.Position(call.Pos())}
+		if args[1] >= 0 && len(call.Args) > args[1] {
+			ref.Message, _ = stringLiteral(call.Args[args[1]])
+		}
+		refs = append(refs, ref)
+		return true
+	})
+	return refs
+}
+
+// importName returns the name under which file imports errorx, or "" if it
+// does not import it.
+func importName(file *ast.File) string {
+	for _, imp := range file.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if path != errorxImportPath {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+		return "errorx"
+	}
+	return ""
+}
+
+func stringLiteral(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// mergeReferences adds the referenced codes missing from catalog and returns
+// them in code order. References without a message cannot be added and are
+// reported to warn, as are messages disagreeing with the catalog.
+func mergeReferences(catalog map[string]errorx.CatalogEntry, refs []reference, warn io.Writer) []reference {
+	var added []reference
+	for _, ref := range refs {
+		existing, ok := catalog[ref.Code]
+		switch {
+		case ok && ref.Message != "" && existing.Message != ref.Message:
+			fmt.Fprintf(warn, "%s: code %s is %q in the catalog, not %q\n", ref.Pos, ref.Code, existing.Message, ref.Message)
+		case ok:
+		case ref.Message == "":
+			fmt.Fprintf(warn, "%s: code %s is not in the catalog and has no message\n", ref.Pos, ref.Code)
+		default:
+			catalog[ref.Code] = errorx.CatalogEntry{Message: ref.Message}
+			added = append(added, ref)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Code < added[j].Code })
+	return added
+}
+
+func readCatalog(path string) (map[string]errorx.CatalogEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(map[string]errorx.CatalogEntry), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := errorx.ParseCatalog(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s failed: %w", path, err)
+	}
+	return catalog, nil
+}
+
+func writeCatalog(path string, catalog map[string]errorx.CatalogEntry) error {
+	data, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal error messages: %w", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleSource = `package sample
+
+import (
+	apperr "github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+func find() error {
+	if true {
+		return apperr.Get("104041")
+	}
+	if false {
+		return apperr.Get("104042", "Profile does not exist")
+	}
+	if false {
+		return apperr.Wrap(nil, "104003")
+	}
+	return apperr.Get("104001", "Wrong password")
+}
+`
+
+func TestScanAndMerge(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sample.go"), []byte(sampleSource), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "nested"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "nested", "other.go"),
+		[]byte("package nested\n\nimport \"github.com/solum-sp/aps-be-common/common/errorx\"\n\nvar _ = errorx.Get(\"105001\", \"Nested\")\n"), 0644))
+
+	refs, err := scanReferences([]string{dir})
+	assert.NoError(t, err)
+	assert.Len(t, refs, 4)
+
+	refs, err = scanReferences([]string{dir + "/..."})
+	assert.NoError(t, err)
+	assert.Len(t, refs, 5)
+
+	catalog := map[string]errorx.CatalogEntry{
+		"104041": {Message: "User does not exist"},
+		"104001": {Message: "Incorrect password", HTTPStatus: 401},
+	}
+	var warn bytes.Buffer
+	added := mergeReferences(catalog, refs, &warn)
+
+	assert.Len(t, added, 2)
+	assert.Equal(t, "104042", added[0].Code)
+	assert.Equal(t, "105001", added[1].Code)
+	assert.Equal(t, "Profile does not exist", catalog["104042"].Message)
+	assert.Equal(t, errorx.CatalogEntry{Message: "Incorrect password", HTTPStatus: 401}, catalog["104001"])
+	assert.Contains(t, warn.String(), `code 104001 is "Incorrect password" in the catalog`)
+	assert.Contains(t, warn.String(), `code 104003 is not in the catalog and has no message`)
+}
+
+func TestCatalogRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.json")
+
+	catalog, err := readCatalog(path)
+	assert.NoError(t, err)
+	assert.Empty(t, catalog)
+
+	catalog["10500"] = errorx.CatalogEntry{Message: "An unexpected error occurred"}
+	catalog["104041"] = errorx.CatalogEntry{Message: "User does not exist", Name: "UserNotFound", Severity: "warning"}
+	assert.NoError(t, writeCatalog(path, catalog))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"10500": "An unexpected error occurred"`)
+
+	loaded, err := readCatalog(path)
+	assert.NoError(t, err)
+	assert.Equal(t, catalog, loaded)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+// generatedCode describes one catalog code in the generated file.
+type generatedCode struct {
+	Code   string
+	Name   string
+	Params []generatedParam
+	errorx.CatalogEntry
+}
+
+type generatedParam struct {
+	Key   string // Template parameter, e.g. "user_id"
+	Ident string // Go argument name, e.g. "userID"
+}
+
+var (
+	templateParam = regexp.MustCompile(`{{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+	templateBlock = regexp.MustCompile(`{{[^}]*}}`)
+)
+
+var generatedTemplate = template.Must(template.New("errors").Funcs(template.FuncMap{"lines": lines}).Parse(`// Code generated by "errorx gen"; DO NOT EDIT.
+
+package {{.Package}}
+
+import "github.com/solum-sp/aps-be-common/common/errorx"
+
+// Error codes of the errorx catalog.
+const (
+{{- range .Codes}}
+	Code{{.Name}} = {{printf "%q" .Code}}
+{{- end}}
+)
+{{range .Codes}}
+// {{.Name}} returns the {{.Code}} error: {{printf "%q" .Message}}.
+{{- if .Description}}
+//
+{{- range lines .Description}}
+//{{if .}} {{.}}{{end}}
+{{- end}}
+{{- end}}
+{{- if or .HTTPStatus .Severity}}
+//
+// {{if .HTTPStatus}}HTTP status: {{.HTTPStatus}}.{{end}}{{if and .HTTPStatus .Severity}} {{end}}{{if .Severity}}Severity: {{.Severity}}.{{end}}
+{{- end}}
+func {{.Name}}({{range $i, $p := .Params}}{{if $i}}, {{end}}{{$p.Ident}}{{end}}{{if .Params}} interface{}{{end}}) *errorx.CustomError {
+{{- if .Params}}
+	return errorx.Get(Code{{.Name}}).WithParams(map[string]interface{}{
+	{{- range .Params}}
+		{{printf "%q" .Key}}: {{.Ident}},
+	{{- end}}
+	})
+{{- else}}
+	return errorx.Get(Code{{.Name}})
+{{- end}}
+}
+{{end}}`))
+
+// generate renders the Go source declaring a constant and a constructor for
+// every code of catalog.
+func generate(pkg string, catalog map[string]errorx.CatalogEntry) ([]byte, error) {
+	codes := make([]string, 0, len(catalog))
+	for code := range catalog {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	names, err := assignNames(catalog)
+	if err != nil {
+		return nil, err
+	}
+	generated := make([]generatedCode, 0, len(codes))
+	for _, code := range codes {
+		e := catalog[code]
+		generated = append(generated, generatedCode{
+			Code:         code,
+			Name:         names[code],
+			Params:       params(e.Message),
+			CatalogEntry: e,
+		})
+	}
+
+	var buf bytes.Buffer
+	err = generatedTemplate.Execute(&buf, struct {
+		Package string
+		Codes   []generatedCode
+	}{Package: pkg, Codes: generated})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code failed: %w", err)
+	}
+	return src, nil
+}
+
+// assignNames returns the Go name of each code: the name given in the
+// catalog, or one derived from the message. A code declares both Name and
+// CodeName, and derived names colliding with an identifier of another code
+// get the code appended.
+func assignNames(catalog map[string]errorx.CatalogEntry) (map[string]string, error) {
+	codes := make([]string, 0, len(catalog))
+	for code := range catalog {
+		codes = append(codes, code)
+	}
+	// Explicit names are assigned first so derived names yield to them.
+	sort.Slice(codes, func(i, j int) bool {
+		ni, nj := catalog[codes[i]].Name != "", catalog[codes[j]].Name != ""
+		if ni != nj {
+			return ni
+		}
+		return codes[i] < codes[j]
+	})
+
+	names := make(map[string]string, len(codes))
+	used := make(map[string]string) // Declared identifier -> code
+	for _, code := range codes {
+		e := catalog[code]
+		name := e.Name
+		if name != "" {
+			if !token.IsIdentifier(name) || !token.IsExported(name) {
+				return nil, fmt.Errorf("error code %s: name %q is not an exported Go identifier", code, name)
+			}
+		} else {
+			name = identifier(e.Message, code)
+			if _, ok := collision(used, name); ok {
+				name += code
+			}
+		}
+		if other, ok := collision(used, name); ok {
+			return nil, fmt.Errorf("error codes %s and %s both declare %s", used[other], code, other)
+		}
+		used[name] = code
+		used["Code"+name] = code
+		names[code] = name
+	}
+	return names, nil
+}
+
+// collision returns the identifier declared for name, or its constant, that
+// is already used.
+func collision(used map[string]string, name string) (string, bool) {
+	for _, ident := range []string{name, "Code" + name} {
+		if _, ok := used[ident]; ok {
+			return ident, true
+		}
+	}
+	return "", false
+}
+
+// lines splits a catalog text into lines for a comment.
+func lines(s string) []string {
+	out := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range out {
+		out[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	return out
+}
+
+// identifier derives an exported Go name from a message, e.g. "User
+// {{.name}} does not exist" becomes UserDoesNotExist.
+func identifier(message, code string) string {
+	var b strings.Builder
+	words := strings.FieldsFunc(templateBlock.ReplaceAllString(message, " "), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		runes := []rune(strings.ToLower(w))
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	name := b.String()
+	if name == "" || !token.IsIdentifier(name) || !token.IsExported(name) {
+		return "Err" + name + code
+	}
+	return name
+}
+
+// params returns the named template parameters of a message in order of
+// first appearance.
+func params(message string) []generatedParam {
+	var out []generatedParam
+	seen := make(map[string]bool)
+	for _, m := range templateParam.FindAllStringSubmatch(message, -1) {
+		key := m[1]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, generatedParam{Key: key, Ident: argName(key)})
+	}
+	return out
+}
+
+// argName turns a template parameter into a lower camel case argument name,
+// e.g. "user_id" becomes userID.
+func argName(key string) string {
+	parts := strings.Split(key, "_")
+	var b strings.Builder
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		switch {
+		case i == 0:
+			b.WriteString(strings.ToLower(p[:1]) + p[1:])
+		case strings.EqualFold(p, "id"):
+			b.WriteString("ID")
+		default:
+			b.WriteString(strings.ToUpper(p[:1]) + p[1:])
+		}
+	}
+	name := b.String()
+	if name == "" || token.IsKeyword(name) {
+		return "p" + strings.ToUpper(key[:1]) + key[1:]
+	}
+	return name
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentifier(t *testing.T) {
+	assert.Equal(t, "UserDoesNotExist", identifier("User does not exist", "104041"))
+	assert.Equal(t, "ProfileDoesNotExist", identifier("Profile {{.id}} does not exist!", "104042"))
+	assert.Equal(t, "Err500001", identifier("", "500001"))
+	assert.Equal(t, "Err3dSecureFailed402001", identifier("3D secure failed", "402001"))
+}
+
+func TestParams(t *testing.T) {
+	got := params("User {{.user_id}} of {{ .tenant }} is {{.user_id}}, {{.type}}")
+	assert.Equal(t, []generatedParam{
+		{Key: "user_id", Ident: "userID"},
+		{Key: "tenant", Ident: "tenant"},
+		{Key: "type", Ident: "pType"},
+	}, got)
+}
+
+func TestGenerate(t *testing.T) {
+	catalog := map[string]errorx.CatalogEntry{
+		"104041": {Message: "User does not exist"},
+		"104042": {Message: "User {{.user_id}} does not exist", HTTPStatus: 404, Severity: "warning"},
+		"104001": {Message: "Wrong password", Name: "BadCredentials", Description: "The password does not match"},
+	}
+	src, err := generate("errs", catalog)
+	assert.NoError(t, err)
+
+	_, err = parser.ParseFile(token.NewFileSet(), "errors_gen.go", src, 0)
+	assert.NoError(t, err)
+	out := string(src)
+	assert.Contains(t, out, `// Code generated by "errorx gen"; DO NOT EDIT.`)
+	assert.Contains(t, out, `CodeBadCredentials`)
+	assert.Contains(t, out, `CodeUserDoesNotExist       = "104041"`)
+	assert.Contains(t, out, `func UserDoesNotExist() *errorx.CustomError`)
+	assert.Contains(t, out, `func UserDoesNotExist104042(userID interface{}) *errorx.CustomError`)
+	assert.Contains(t, out, `"user_id": userID,`)
+	assert.Contains(t, out, `// HTTP status: 404. Severity: warning.`)
+
+	again, err := generate("errs", catalog)
+	assert.NoError(t, err)
+	assert.Equal(t, src, again)
+
+	_, err = generate("errs", map[string]errorx.CatalogEntry{
+		"1": {Message: "a", Name: "Same"},
+		"2": {Message: "b", Name: "Same"},
+	})
+	assert.Error(t, err)
+	_, err = generate("errs", map[string]errorx.CatalogEntry{"1": {Message: "a", Name: "notExported"}})
+	assert.Error(t, err)
+	_, err = generate("errs", map[string]errorx.CatalogEntry{
+		"1": {Message: "a", Name: "UserNotFound"},
+		"2": {Message: "b", Name: "CodeUserNotFound"},
+	})
+	assert.EqualError(t, err, "error codes 1 and 2 both declare CodeUserNotFound")
+}
+
+func TestGenerateMultilineDescription(t *testing.T) {
+	src, err := generate("errs", map[string]errorx.CatalogEntry{
+		"104041": {Message: "User does not exist", Description: "Returned when the user was deleted.\r\n\nRetrying does not help."},
+	})
+	assert.NoError(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "errors_gen.go", src, 0)
+	assert.NoError(t, err)
+	assert.Contains(t, string(src), "//\n// Returned when the user was deleted.\n//\n// Retrying does not help.\nfunc UserDoesNotExist()")
+}
+
+func TestGenerateDerivedNameCollisions(t *testing.T) {
+	src, err := generate("errs", map[string]errorx.CatalogEntry{
+		"1": {Message: "Not found", Name: "CodeUserNotFound"},
+		"2": {Message: "User not found"},
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, string(src), "func UserNotFound2() *errorx.CustomError")
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	catalog := map[string]errorx.CatalogEntry{
+		"104041": {Message: "User does not exist"},
+		"104001": {Message: "Wrong password"},
+		"104003": {Message: "Forbidden"},
+		"500001": {Message: "Unused"},
+	}
+	genPath := filepath.Join(dir, "errs", "errors_gen.go")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(genPath), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sample.go"), []byte(sampleSource), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "typed.go"),
+		[]byte("package sample\n\nimport \"example.com/errs\"\n\nvar _ = errs.Forbidden()\n"), 0644))
+
+	report, err := check([]string{dir + "/..."}, catalog, genPath, "errs")
+	assert.NoError(t, err)
+	assert.Len(t, report.Missing, 1)
+	assert.Equal(t, "104042", report.Missing[0].Code)
+	assert.True(t, report.Stale)
+	assert.Equal(t, []string{"500001"}, report.Unused)
+	assert.True(t, report.failed(false))
+
+	src, err := generate("errs", catalog)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(genPath, src, 0644))
+	catalog["104042"] = errorx.CatalogEntry{Message: "Profile does not exist"}
+	report, err = check([]string{dir + "/..."}, catalog, genPath, "errs")
+	assert.NoError(t, err)
+	assert.Empty(t, report.Missing)
+	assert.True(t, report.Stale)
+
+	src, err = generate("errs", catalog)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(genPath, src, 0644))
+	report, err = check([]string{dir + "/..."}, catalog, genPath, "errs")
+	assert.NoError(t, err)
+	assert.False(t, report.Stale)
+	assert.Equal(t, []string{"500001"}, report.Unused)
+	assert.False(t, report.failed(false))
+	assert.True(t, report.failed(true))
+}
@@ -0,0 +1,136 @@
+// Command errorx maintains the errorx catalog at build time.
+//
+// Usage:
+//
+//	go run github.com/solum-sp/aps-be-common/common/errorx/cmd/errorx collect -catalog config/errors.json ./...
+//	go run github.com/solum-sp/aps-be-common/common/errorx/cmd/errorx gen -catalog config/errors.json -pkg errs -out errs/errors_gen.go
+//	go run github.com/solum-sp/aps-be-common/common/errorx/cmd/errorx check -catalog config/errors.json -gen errs/errors_gen.go -pkg errs ./...
+//
+// collect scans Go sources for errorx.Get(code, message) calls with literal
+// arguments and adds the codes missing from the catalog file.
+//
+// gen writes a Go file declaring a constant and a typed constructor for every
+// code of the catalog, e.g. CodeUserNotFound and UserNotFound(userID).
+//
+// check exits with status 1 when the sources and the catalog drift apart:
+// literal codes missing from the catalog or a generated file out of date.
+// With -strict, catalog codes referenced nowhere fail the check too.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "collect":
+		err = runCollect(os.Args[2:])
+	case "gen":
+		err = runGenerate(os.Args[2:])
+	case "check":
+		var ok bool
+		ok, err = runCheck(os.Args[2:])
+		if err == nil && !ok {
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "errorx:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+	errorx collect [-catalog file] [-dry-run] [packages]
+	errorx gen [-catalog file] [-pkg name] [-out file]
+	errorx check [-catalog file] [-gen file] [-pkg name] [-strict] [packages]`)
+}
+
+func runCollect(args []string) error {
+	fs := flag.NewFlagSet("collect", flag.ExitOnError)
+	catalogPath := fs.String("catalog", "config/errors.json", "path of the errors JSON catalog")
+	dryRun := fs.Bool("dry-run", false, "print the new codes without writing the catalog")
+	fs.Parse(args)
+
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{"./..."}
+	}
+
+	refs, err := scanReferences(roots)
+	if err != nil {
+		return err
+	}
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+
+	added := mergeReferences(catalog, refs, os.Stderr)
+	for _, ref := range added {
+		fmt.Printf("%s\t%q\t(%s)\n", ref.Code, ref.Message, ref.Pos)
+	}
+	if len(added) == 0 || *dryRun {
+		return nil
+	}
+	return writeCatalog(*catalogPath, catalog)
+}
+
+func runGenerate(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	catalogPath := fs.String("catalog", "config/errors.json", "path of the errors JSON catalog")
+	pkg := fs.String("pkg", "errs", "package name of the generated file")
+	out := fs.String("out", "", "path of the generated file, stdout if empty")
+	fs.Parse(args)
+
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		return err
+	}
+	src, err := generate(*pkg, catalog)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(*out, src, 0644)
+}
+
+func runCheck(args []string) (bool, error) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	catalogPath := fs.String("catalog", "config/errors.json", "path of the errors JSON catalog")
+	genPath := fs.String("gen", "", "path of the file written by errorx gen, if any")
+	pkg := fs.String("pkg", "errs", "package name of the generated file")
+	strict := fs.Bool("strict", false, "also fail on catalog codes referenced nowhere")
+	fs.Parse(args)
+
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{"./..."}
+	}
+
+	catalog, err := readCatalog(*catalogPath)
+	if err != nil {
+		return false, err
+	}
+	report, err := check(roots, catalog, *genPath, *pkg)
+	if err != nil {
+		return false, err
+	}
+	report.print(os.Stderr, *genPath)
+	return !report.failed(*strict), nil
+}
@@ -0,0 +1,257 @@
+package errorx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	CodeUnknown    = "unknown_error"
+	MessageUnknown = "An unknown error occurred"
+)
+
+// CustomError is the error type shared by all services. It carries a catalog
+// code, a human-readable message, optional details, the underlying cause and
+// the stack captured when it was created through New, Newf or Wrap.
+type CustomError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+
+	status int
+	class  Class
+	params map[string]interface{}
+	cause  error
+	stack  []uintptr
+	reg    *Registry // nil for the default registry
+}
+
+var _ error = (*CustomError)(nil)
+
+// New creates a *CustomError with the given code and message and records the
+// caller's stack.
+func New(code, message string) *CustomError {
+	return &CustomError{Code: code, Message: message, stack: callers()}
+}
+
+// Newf is like New but formats the message according to a format specifier.
+func Newf(code, format string, args ...interface{}) *CustomError {
+	return &CustomError{Code: code, Message: fmt.Sprintf(format, args...), stack: callers()}
+}
+
+// Wrap annotates err with the given code. The message is looked up in the
+// loaded error messages; if the code is unknown MessageUnknown is used so
+// that the text of err, kept as the cause, never reaches clients. Wrap
+// returns nil if err is nil.
+func Wrap(err error, code string) *CustomError {
+	if err == nil {
+		return nil
+	}
+	return Default().wrap(err, code, callers())
+}
+
+// Wrap is like the package-level Wrap but looks the message up in r.
+func (r *Registry) Wrap(err error, code string) *CustomError {
+	if err == nil {
+		return nil
+	}
+	return r.wrap(err, code, callers())
+}
+
+func (r *Registry) wrap(err error, code string, stack []uintptr) *CustomError {
+	message, ok := r.lookup(code)
+	if !ok {
+		message = MessageUnknown
+	}
+	return &CustomError{Code: code, Message: message, cause: err, stack: stack, reg: r.ref()}
+}
+
+// FromError returns the first *CustomError found in err's chain. Any other
+// error is wrapped into an unknown error so callers always get a code and a
+// status to respond with. FromError returns nil if err is nil.
+func FromError(err error) *CustomError {
+	if err == nil {
+		return nil
+	}
+	if e, ok := asCustomError(err); ok {
+		return e
+	}
+	return &CustomError{Code: CodeUnknown, Message: MessageUnknown, cause: err}
+}
+
+func (e *CustomError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %s", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the cause of the error, if any.
+func (e *CustomError) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is a *CustomError with the same code, so that
+// errors.Is(err, errorx.Get("104041")) matches anywhere in the chain.
+func (e *CustomError) Is(target error) bool {
+	t, ok := target.(*CustomError)
+	if !ok || t == nil {
+		return false
+	}
+	return e.Code == t.Code
+}
+
+// WithCause returns a copy of the error with cause attached. The stack is
+// recorded if the error does not carry one yet.
+func (e *CustomError) WithCause(cause error) *CustomError {
+	c := e.clone()
+	c.cause = cause
+	if c.stack == nil {
+		c.stack = callers()
+	}
+	return c
+}
+
+// WithDetail returns a copy of the error with key set to value in Details.
+func (e *CustomError) WithDetail(key string, value interface{}) *CustomError {
+	c := e.clone()
+	c.Details[key] = value
+	return c
+}
+
+// WithDetails returns a copy of the error with all details merged in.
+func (e *CustomError) WithDetails(details map[string]interface{}) *CustomError {
+	c := e.clone()
+	for k, v := range details {
+		c.Details[k] = v
+	}
+	return c
+}
+
+// WithStatus returns a copy of the error that reports httpStatus regardless
+// of the status registered for its code.
+func (e *CustomError) WithStatus(httpStatus int) *CustomError {
+	c := e.clone()
+	c.status = httpStatus
+	return c
+}
+
+// HTTPStatus returns the HTTP status code to respond with.
+func (e *CustomError) HTTPStatus() int {
+	if e.status != 0 {
+		return e.status
+	}
+	return e.registry().StatusForCode(e.Code)
+}
+
+// GRPCCode returns the gRPC code matching the HTTP status of the error.
+func (e *CustomError) GRPCCode() codes.Code {
+	return grpcCodeFromHTTP(e.HTTPStatus())
+}
+
+// GRPCStatus lets grpc-go convert the error into a status when it is
+// returned from a gRPC handler.
+func (e *CustomError) GRPCStatus() *status.Status {
+	return status.New(e.GRPCCode(), e.Message)
+}
+
+// StackTrace returns the frames recorded when the error was created.
+func (e *CustomError) StackTrace() []runtime.Frame {
+	if len(e.stack) == 0 {
+		return nil
+	}
+	frames := runtime.CallersFrames(e.stack)
+	var out []runtime.Frame
+	for {
+		frame, more := frames.Next()
+		out = append(out, frame)
+		if !more {
+			break
+		}
+	}
+	return out
+}
+
+// Format implements fmt.Formatter; "%+v" prints the error followed by its
+// stack trace.
+func (e *CustomError) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			io.WriteString(s, e.Error())
+			for _, frame := range e.StackTrace() {
+				fmt.Fprintf(s, "\n%s\n\t%s:%d", frame.Function, frame.File, frame.Line)
+			}
+			return
+		}
+		io.WriteString(s, e.Error())
+	case 's':
+		io.WriteString(s, e.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	}
+}
+
+func (e *CustomError) clone() *CustomError {
+	c := *e
+	c.Details = make(map[string]interface{}, len(e.Details))
+	for k, v := range e.Details {
+		c.Details[k] = v
+	}
+	return &c
+}
+
+// HTTPStatus returns the HTTP status for any error, using the first
+// *CustomError or *ValidationError in its chain. Other errors map to 500.
+func HTTPStatus(err error) int {
+	if e, ok := asCustomError(err); ok {
+		return e.HTTPStatus()
+	}
+	return 500
+}
+
+// GRPCCode returns the gRPC code for any error, using the first *CustomError
+// or *ValidationError in its chain. Other errors map to codes.Unknown.
+func GRPCCode(err error) codes.Code {
+	if e, ok := asCustomError(err); ok {
+		return e.GRPCCode()
+	}
+	if err == nil {
+		return codes.OK
+	}
+	return codes.Unknown
+}
+
+// customErrorer is implemented by the errors that can be reported as a
+// *CustomError.
+type customErrorer interface {
+	customError() *CustomError
+}
+
+func (e *CustomError) customError() *CustomError { return e }
+
+func asCustomError(err error) (*CustomError, bool) {
+	var c customErrorer
+	if errors.As(err, &c) {
+		return c.customError(), true
+	}
+	return nil, false
+}
+
+func callers() []uintptr {
+	pc := make([]uintptr, 32)
+	n := runtime.Callers(3, pc) // Skip runtime.Callers, callers and the constructor
+	return pc[:n]
+}
+
+func (e *CustomError) registry() *Registry {
+	if e.reg != nil {
+		return e.reg
+	}
+	return Default()
+}
@@ -0,0 +1,101 @@
+package errorx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCustomErrorImplementsError(t *testing.T) {
+	err := New("104041", "User does not exist")
+
+	assert.EqualError(t, err, "104041: User does not exist")
+	assert.NotEmpty(t, err.StackTrace())
+	assert.Contains(t, fmt.Sprintf("%+v", err), "TestCustomErrorImplementsError")
+}
+
+func TestWrapAndUnwrap(t *testing.T) {
+	useMessages(t, mockErrorData)
+	cause := errors.New("sql: no rows in result set")
+
+	err := Wrap(cause, "104041")
+	assert.Equal(t, "User does not exist", err.Message)
+	assert.ErrorIs(t, err, cause)
+	assert.EqualError(t, err, "104041: User does not exist: sql: no rows in result set")
+
+	wrapped := fmt.Errorf("find user: %w", err)
+	var target *CustomError
+	assert.True(t, errors.As(wrapped, &target))
+	assert.Equal(t, "104041", target.Code)
+
+	assert.Nil(t, Wrap(nil, "104041"))
+}
+
+func TestIsMatchesByCode(t *testing.T) {
+	useMessages(t, mockErrorData)
+	err := fmt.Errorf("handler: %w", New("104041", "custom message"))
+
+	assert.True(t, errors.Is(err, Get("104041")))
+	assert.False(t, errors.Is(err, Get("104001")))
+}
+
+func TestWithDetailsDoesNotMutate(t *testing.T) {
+	base := New("104001", "Incorrect password")
+	detailed := base.WithDetail("attempts", 3).WithDetails(map[string]interface{}{"user": "john"})
+
+	assert.Empty(t, base.Details)
+	assert.Equal(t, map[string]interface{}{"attempts": 3, "user": "john"}, detailed.Details)
+}
+
+func TestStatusMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		httpStatus int
+		grpcCode   codes.Code
+	}{
+		{"not found from code", New("104041", "User does not exist"), http.StatusNotFound, codes.NotFound},
+		{"bad request from code", New("104001", "Incorrect password"), http.StatusBadRequest, codes.InvalidArgument},
+		{"internal from code", New("10500", "An unexpected error occurred"), http.StatusInternalServerError, codes.Internal},
+		{"non conventional code", New("user_not_found", "User does not exist"), http.StatusInternalServerError, codes.Internal},
+		{"explicit status", New("104041", "Gone").WithStatus(http.StatusUnauthorized), http.StatusUnauthorized, codes.Unauthenticated},
+		{"wrapped", fmt.Errorf("wrap: %w", New("104091", "Conflict")), http.StatusConflict, codes.AlreadyExists},
+		{"plain error", errors.New("boom"), http.StatusInternalServerError, codes.Unknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.httpStatus, HTTPStatus(tt.err))
+			assert.Equal(t, tt.grpcCode, GRPCCode(tt.err))
+		})
+	}
+}
+
+func TestRegisterStatus(t *testing.T) {
+	useMessages(t, nil)
+	RegisterStatus("user_not_found", http.StatusNotFound)
+
+	err := New("user_not_found", "User does not exist")
+	assert.Equal(t, http.StatusNotFound, err.HTTPStatus())
+
+	st, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.NotFound, st.Code())
+	assert.Equal(t, "User does not exist", st.Message())
+}
+
+func TestFromError(t *testing.T) {
+	assert.Nil(t, FromError(nil))
+
+	err := FromError(errors.New("boom"))
+	assert.Equal(t, CodeUnknown, err.Code)
+	assert.EqualError(t, errors.Unwrap(err), "boom")
+
+	original := New("104041", "User does not exist")
+	assert.Same(t, original, FromError(fmt.Errorf("wrap: %w", original)))
+}
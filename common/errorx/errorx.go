@@ -1,123 +1,88 @@
 package errorx
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
-	"sync"
+	"io/fs"
+	"sync/atomic"
 )
 
-var (
-	errMessages   = make(map[string]string)
-	errorFilePath string     // Stores the last used file path
-	mu            sync.Mutex // Protects errMessages map
-	fileMu        sync.Mutex // Protects file writes
-)
+var defaultRegistry atomic.Pointer[Registry]
 
-type CustomError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+func init() {
+	defaultRegistry.Store(NewRegistry())
 }
 
 const defaultPath = "config/errors.json"
 
-// LoadErrors loads a JSON file containing a mapping of error codes to human-readable messages.
-// The file path is optional; if not provided, it defaults to "config/errors.json".
-// The loaded messages are stored in the errMessages map, which can be accessed
-// using the Get and GetMessage functions.
-func LoadErrors(filePath ...string) error {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if len(filePath) > 0 {
-		errorFilePath = filePath[0]
-	} else {
-		errorFilePath = defaultPath
-	}
-
-	file, err := os.Open(errorFilePath)
-	if err != nil {
-		return fmt.Errorf("loading errors.json file failed: %s", err)
-	}
-	defer file.Close()
-
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&errMessages)
-	if err != nil {
-		return fmt.Errorf("decoding errors.json file failed: %s", err)
-	}
-
-	return nil
-
+// Default returns the registry used by the package level functions.
+func Default() *Registry {
+	return defaultRegistry.Load()
 }
 
-
-
-// Get returns a *CustomError for the given code. If the code is not found in
-// the loaded error messages, it returns a *CustomError with the code set to
-// "unknown_error" and the message set to "An unknown error occurred". If a
-// message is provided as the second argument, the code/message pair is added to
-// the loaded error messages and the updated file is written to disk.
-func Get(code string, message ...string) *CustomError {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if msg, exists := errMessages[code]; exists {
-		return &CustomError{Code: code, Message: msg}
-	}
-	if len(message) == 0 {
-		return &CustomError{Code: "unknown_error", Message: "An unknown error occurred"}
-	}
-
-	newMessage := message[0]
-	errMessages[code] = newMessage
-
-	if err := appendErrorToFile(code, newMessage); err != nil {
-		fmt.Println("Error appending error to file:", err) // Return error to caller
-	}
-
-	return &CustomError{Code: code, Message: newMessage}
+// SetDefault replaces the registry used by the package level functions.
+func SetDefault(r *Registry) {
+	defaultRegistry.Store(r)
 }
 
-func appendErrorToFile(code, message string) error {
-	fileMu.Lock()
-	defer fileMu.Unlock()
-
-	if errorFilePath == "" {
-		return fmt.Errorf("no error file path set. Call LoadErrors() or SetErrorFilePath() first")
+// LoadErrors loads JSON files containing a mapping of error codes to human-readable messages
+// into the default registry. The file paths are optional; if not provided, it defaults to
+// "config/errors.json". Several files are merged; see Registry.LoadFS for conflict handling.
+// The loaded messages can be accessed using the Get and GetMessage functions.
+func LoadErrors(filePath ...string) error {
+	if len(filePath) == 0 {
+		filePath = []string{defaultPath}
 	}
+	return Default().Load(filePath...)
+}
 
-	// 🔹 Ensure `code` and `message` are added to `errMessages`
-	errMessages[code] = message
+// LoadErrorsFS loads catalog files from fsys, typically an embed.FS
+// compiled into the service binary, into the default registry:
+//
+//	//go:embed errors.json
+//	var errorsFS embed.FS
+//
+//	errorx.LoadErrorsFS(errorsFS, "errors.json")
+func LoadErrorsFS(fsys fs.FS, paths ...string) error {
+	return Default().LoadFS(fsys, paths...)
+}
 
-	// 🔹 Convert `errMessages` to JSON
-	data, err := json.MarshalIndent(errMessages, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal error messages: %w", err)
-	}
+// Register adds a code/message pair to the default registry. It is meant to
+// be called from init functions of packages that own their error codes and
+// panics if the code is already registered with another message.
+func Register(code, message string) {
+	Default().MustRegister(code, message)
+}
 
-	// 🔹 Write updated JSON back to the file
-	err = os.WriteFile(errorFilePath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write to %s: %w", errorFilePath, err)
-	}
+// SetUnknownCodeHook replaces the hook of the default registry reporting
+// lookups of codes missing from the catalog. Passing nil disables reporting.
+func SetUnknownCodeHook(hook UnknownCodeHook) {
+	Default().SetUnknownCodeHook(hook)
+}
 
-	return nil
+// Get returns a *CustomError for the given code from the default registry.
+// If the code is not found in the loaded error messages, the unknown code
+// hook is called and Get returns a *CustomError with the given fallback
+// message, or with the code set to CodeUnknown and the message set to
+// MessageUnknown if no message is given.
+// Get never modifies the catalog; run `go run ./common/errorx/cmd/errorx collect`
+// to add newly referenced codes to the catalog file.
+func Get(code string, message ...string) *CustomError {
+	return Default().Get(code, message...)
 }
 
 // GetMessage retrieves the error message associated with the given error code.
 // If the code is not found, it returns "undefined error".
 func GetMessage(code string) string {
-	if msg, exists := errMessages[code]; exists {
-		return msg
-	}
-	return "undefined error"
+	return Default().GetMessage(code)
 }
 
 // === helper function ===
 
+// GetErrorFilePath returns the last catalog file loaded into the default
+// registry.
 func GetErrorFilePath() string {
-	mu.Lock()
-	defer mu.Unlock()
-	return errorFilePath
+	paths := Default().FilePaths()
+	if len(paths) == 0 {
+		return ""
+	}
+	return paths[len(paths)-1]
 }
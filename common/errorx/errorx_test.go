@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,7 +29,20 @@ func createTempErrorFile(t *testing.T) string {
 	return tempFile.Name()
 }
 
+// useMessages installs a fresh default registry holding messages for the
+// duration of the test.
+func useMessages(t *testing.T, messages map[string]string) {
+	previous := Default()
+	r := NewRegistry()
+	for code, msg := range messages {
+		r.MustRegister(code, msg)
+	}
+	SetDefault(r)
+	t.Cleanup(func() { SetDefault(previous) })
+}
+
 func TestLoadErrors(t *testing.T) {
+	useMessages(t, nil)
 	tmpFilePath := createTempErrorFile(t)
 	defer os.Remove(tmpFilePath)
 
@@ -42,7 +56,7 @@ func TestLoadErrors(t *testing.T) {
 }
 
 func TestGet(t *testing.T) {
-	errMessages = mockErrorData
+	useMessages(t, mockErrorData)
 	actualerr := Get("104041")
 	// assert.Equal(t, "104041", err.Code)
 	expected := &CustomError{Code: "104041", Message: "User does not exist"}
@@ -54,7 +68,7 @@ func TestGet(t *testing.T) {
 }
 
 func TestGetMessage(t *testing.T) {
-	errMessages = mockErrorData
+	useMessages(t, mockErrorData)
 	message := GetMessage("104041")
 	assert.Equal(t, "User does not exist", message)
 
@@ -64,3 +78,40 @@ func TestGetMessage(t *testing.T) {
 	message = GetMessage("100909")
 	assert.Equal(t, "undefined error", message)
 }
+
+func TestLoadErrorsFS(t *testing.T) {
+	useMessages(t, nil)
+	data, err := json.Marshal(mockErrorData)
+	assert.NoError(t, err)
+
+	fsys := fstest.MapFS{"config/errors.json": {Data: data}}
+	assert.NoError(t, LoadErrorsFS(fsys, "config/errors.json"))
+	assert.Equal(t, "User does not exist", GetMessage("104041"))
+
+	assert.Error(t, LoadErrorsFS(fsys, "missing.json"))
+}
+
+func TestRegister(t *testing.T) {
+	useMessages(t, nil)
+	Register("104042", "Profile does not exist")
+
+	assert.Equal(t, &CustomError{Code: "104042", Message: "Profile does not exist"}, Get("104042"))
+}
+
+func TestGetUnknownCodeDoesNotModifyCatalog(t *testing.T) {
+	useMessages(t, map[string]string{"104041": "User does not exist"})
+	var reported []string
+	SetUnknownCodeHook(func(code string, message string) {
+		reported = append(reported, code+"="+message)
+	})
+
+	actual := Get("105002", "test new error")
+	assert.Equal(t, &CustomError{Code: "105002", Message: "test new error"}, actual)
+	assert.Equal(t, "undefined error", GetMessage("105002"))
+
+	actual = Get("105003")
+	assert.Equal(t, CodeUnknown, actual.Code)
+
+	Get("104041")
+	assert.Equal(t, []string{"105002=test new error", "105003="}, reported)
+}
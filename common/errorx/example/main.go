@@ -46,10 +46,14 @@ func main() {
 		message: e.Message,
 	}
 
-	_ = errorx.Get("105002", "test new error")
 	fmt.Println(c)
 
-	fmt.Println("new error added:", errorx.Get("105002"))
+	// Codes owned by this service are registered at start-up; Get never writes
+	// to errors.json. Run `go run ../cmd/errorx collect -catalog errors.json .`
+	// to add codes referenced with a fallback message to the catalog.
+	errorx.Register("105003", "registered at init")
+	fmt.Println("registered error:", errorx.Get("105003"))
+	fmt.Println("unknown error with fallback:", errorx.Get("105004", "not in catalog yet"))
 
 	fmt.Println("Path to errors.json:", errorx.GetErrorFilePath())
 	
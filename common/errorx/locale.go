@@ -0,0 +1,284 @@
+package errorx
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type localeCtxKey struct{}
+
+// WithLocale returns a copy of ctx carrying the locale used to render error
+// messages.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeCtxKey{}, normalizeLocale(locale))
+}
+
+// LocaleFromContext returns the locale stored by WithLocale, or the default
+// locale of the default registry.
+func LocaleFromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeCtxKey{}).(string); ok && locale != "" {
+		return locale
+	}
+	return DefaultLocale()
+}
+
+// SetDefaultLocale sets the default locale of the default registry.
+func SetDefaultLocale(locale string) {
+	Default().SetDefaultLocale(locale)
+}
+
+// DefaultLocale returns the default locale of the default registry.
+func DefaultLocale() string {
+	return Default().DefaultLocale()
+}
+
+// SetLocaleFallback configures a fallback chain of the default registry.
+func SetLocaleFallback(locale string, fallbacks ...string) {
+	Default().SetLocaleFallback(locale, fallbacks...)
+}
+
+// LoadLocalizedErrors loads every "errors.<locale>.json" file in dir of
+// fsys into the default registry, e.g. errors.en.json and errors.vi.json.
+// Use os.DirFS to read from disk or an embed.FS for files compiled into the
+// binary.
+func LoadLocalizedErrors(fsys fs.FS, dir string) error {
+	return Default().LoadLocalizedFS(fsys, dir)
+}
+
+// LoadLocaleErrors loads a single catalog file from disk for locale into the
+// default registry.
+func LoadLocaleErrors(locale, filePath string) error {
+	return Default().LoadLocaleFS(os.DirFS(filepath.Dir(filePath)), locale, filepath.Base(filePath))
+}
+
+// RegisterLocalized adds the message of code for locale to the default
+// registry and panics on conflict, like Register.
+func RegisterLocalized(locale, code, message string) {
+	if err := Default().RegisterLocalized(locale, code, message); err != nil {
+		panic(err)
+	}
+}
+
+// Locales returns the locales of the default registry.
+func Locales() []string {
+	return Default().Locales()
+}
+
+// Localize returns the error for code with its message rendered with params
+// in the locale carried by ctx, using the default registry.
+func Localize(ctx context.Context, code string, params map[string]interface{}) *CustomError {
+	return Default().Localize(ctx, code, params)
+}
+
+// NegotiateLocale returns the locale of the default registry that best
+// matches an Accept-Language header. See Registry.NegotiateLocale.
+func NegotiateLocale(acceptLanguage string) string {
+	return Default().NegotiateLocale(acceptLanguage)
+}
+
+// SetDefaultLocale sets the locale of the messages loaded without an
+// explicit locale and the last resort of every fallback chain. It defaults
+// to "en" and should be set before loading catalogs.
+func (r *Registry) SetDefaultLocale(locale string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultLocale = normalizeLocale(locale)
+}
+
+func (r *Registry) DefaultLocale() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.defaultLocale
+}
+
+// SetLocaleFallback configures the locales tried, in order, when a message
+// is missing for locale, before falling back to the default locale.
+func (r *Registry) SetLocaleFallback(locale string, fallbacks ...string) {
+	normalized := make([]string, len(fallbacks))
+	for i, f := range fallbacks {
+		normalized[i] = normalizeLocale(f)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallbacks[normalizeLocale(locale)] = normalized
+}
+
+// Locales returns the locales that have a catalog loaded and the default
+// locale, sorted.
+func (r *Registry) Locales() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	locales := make([]string, 0, len(r.messages)+1)
+	for locale := range r.messages {
+		locales = append(locales, locale)
+	}
+	if _, ok := r.messages[r.defaultLocale]; !ok {
+		locales = append(locales, r.defaultLocale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Localize returns the error for code with its message rendered with params
+// in the locale carried by ctx.
+func (r *Registry) Localize(ctx context.Context, code string, params map[string]interface{}) *CustomError {
+	return r.Get(code).WithParams(params).Localize(LocaleFromContext(ctx))
+}
+
+// Message returns the message of code in the best available locale for
+// locale, following its fallback chain: the locale itself, its parents
+// ("vi-VN" -> "vi"), configured fallbacks and the default locale.
+func (r *Registry) Message(locale, code string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, l := range r.fallbackChain(normalizeLocale(locale)) {
+		if e, ok := r.messages[l][code]; ok {
+			return e.Message, true
+		}
+	}
+	return "", false
+}
+
+func (r *Registry) fallbackChain(locale string) []string {
+	var chain []string
+	for l := locale; l != ""; {
+		chain = append(chain, l)
+		chain = append(chain, r.fallbacks[l]...)
+		i := strings.LastIndex(l, "-")
+		if i < 0 {
+			break
+		}
+		l = l[:i]
+	}
+	return append(chain, r.defaultLocale)
+}
+
+// NegotiateLocale returns the loaded locale that best matches an
+// Accept-Language header, e.g. "vi-VN,vi;q=0.9,en;q=0.8". Languages are
+// matched exactly first, then by their primary tag. The default locale is
+// returned if nothing matches.
+func (r *Registry) NegotiateLocale(acceptLanguage string) string {
+	available := r.Locales()
+	for _, tag := range ParseAcceptLanguage(acceptLanguage) {
+		if tag == "*" {
+			break
+		}
+		for _, l := range available {
+			if l == tag {
+				return l
+			}
+		}
+		primary, _, _ := strings.Cut(tag, "-")
+		for _, l := range available {
+			if l == primary || strings.HasPrefix(l, primary+"-") {
+				return l
+			}
+		}
+	}
+	return r.DefaultLocale()
+}
+
+func (r *Registry) render(message string, params map[string]interface{}) string {
+	if !strings.Contains(message, "{{") {
+		return message
+	}
+
+	r.tmplMu.Lock()
+	tmpl, ok := r.templates[message]
+	if !ok {
+		var err error
+		tmpl, err = template.New("message").Option("missingkey=error").Parse(message)
+		if err != nil {
+			r.tmplMu.Unlock()
+			return message
+		}
+		r.templates[message] = tmpl
+	}
+	r.tmplMu.Unlock()
+
+	// A missing parameter leaves the template untouched rather than
+	// rendering "<no value>" to the client.
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return message
+	}
+	return buf.String()
+}
+
+// WithParams returns a copy of the error holding the named parameters used
+// by its message template, e.g. {"name": "john"} for "User {{.name}} not
+// found". The message is rendered in the default locale.
+func (e *CustomError) WithParams(params map[string]interface{}) *CustomError {
+	reg := e.registry()
+	c := e.clone()
+	c.params = params
+	if msg, ok := reg.Message(reg.DefaultLocale(), c.Code); ok {
+		c.Message = reg.render(msg, params)
+	} else {
+		c.Message = reg.render(c.Message, params)
+	}
+	return c
+}
+
+// Localize returns a copy of the error whose message is rendered in the best
+// available locale for locale, following its fallback chain. The error is
+// returned unchanged if no catalog has a message for its code.
+func (e *CustomError) Localize(locale string) *CustomError {
+	reg := e.registry()
+	msg, ok := reg.Message(locale, e.Code)
+	if !ok {
+		return e
+	}
+	c := e.clone()
+	c.Message = reg.render(msg, c.params)
+	return c
+}
+
+// ParseAcceptLanguage returns the language tags of an Accept-Language header
+// ordered by decreasing quality. Tags with q=0 are dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = normalizeLocale(tag)
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag: tag, q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	out := make([]string, len(tags))
+	for i, t := range tags {
+		out[i] = t.tag
+	}
+	return out
+}
+
+// normalizeLocale lower-cases a tag and uses "-" as separator, so "vi_VN"
+// and "vi-VN" refer to the same catalog.
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
@@ -0,0 +1,85 @@
+package errorx
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupLocales(t *testing.T) {
+	useMessages(t, nil)
+
+	fsys := fstest.MapFS{
+		"i18n/errors.en.json": {Data: []byte(`{"104041": "User {{.name}} not found", "10500": "An unexpected error occurred"}`)},
+		"i18n/errors.vi.json": {Data: []byte(`{"104041": "Không tìm thấy người dùng {{.name}}"}`)},
+		"i18n/errors.ja.json": {Data: []byte(`{"104041": "ユーザー {{.name}} が見つかりません"}`)},
+	}
+	assert.NoError(t, LoadLocalizedErrors(fsys, "i18n"))
+}
+
+func TestLoadLocalizedErrors(t *testing.T) {
+	setupLocales(t)
+
+	assert.Equal(t, []string{"en", "ja", "vi"}, Locales())
+	assert.Equal(t, "User {{.name}} not found", GetMessage("104041"))
+	assert.Error(t, LoadLocalizedErrors(fstest.MapFS{}, "i18n"))
+}
+
+func TestLocalize(t *testing.T) {
+	setupLocales(t)
+	params := map[string]interface{}{"name": "john"}
+
+	ctx := WithLocale(context.Background(), "vi")
+	assert.Equal(t, "Không tìm thấy người dùng john", Localize(ctx, "104041", params).Message)
+
+	assert.Equal(t, "User john not found", Localize(context.Background(), "104041", params).Message)
+
+	err := Get("104041").WithParams(params)
+	assert.Equal(t, "User john not found", err.Message)
+	assert.Equal(t, "ユーザー john が見つかりません", err.Localize("ja-JP").Message)
+	assert.Equal(t, "User john not found", err.Message, "Localize must not mutate the receiver")
+
+	// Missing parameters keep the raw template instead of rendering "<no value>"
+	assert.Equal(t, "User {{.name}} not found", Get("104041").Localize("en").Message)
+}
+
+func TestLocaleFallbackChain(t *testing.T) {
+	setupLocales(t)
+	RegisterLocalized("vi", "10500", "Đã xảy ra lỗi")
+	SetLocaleFallback("ko", "ja")
+
+	assert.Equal(t, "Đã xảy ra lỗi", Get("10500").Localize("vi_VN").Message)
+	assert.Equal(t, "ユーザー {{.name}} が見つかりません", Get("104041").Localize("ko").Message)
+	assert.Equal(t, "An unexpected error occurred", Get("10500").Localize("ja").Message)
+
+	unknown := New("999999", "raw message")
+	assert.Same(t, unknown, unknown.Localize("vi"))
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	setupLocales(t)
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"vi-VN,vi;q=0.9,en;q=0.8", "vi"},
+		{"fr-FR,ja;q=0.5,en;q=0.7", "en"},
+		{"fr-FR, ja;q=0.5", "ja"},
+		{"fr-FR", "en"},
+		{"vi;q=0, ja", "ja"},
+		{"", "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, NegotiateLocale(tt.header))
+		})
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.Equal(t, []string{"vi-vn", "en", "ja"}, ParseAcceptLanguage("ja;q=0.5, vi-VN, en;q=0.8, fr;q=0"))
+	assert.Empty(t, ParseAcceptLanguage(""))
+}
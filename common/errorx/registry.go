@@ -0,0 +1,322 @@
+package errorx
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// UnknownCodeHook is called whenever Get is asked for a code that is not in
+// the catalog. message is the fallback message passed to Get, if any.
+type UnknownCodeHook func(code string, message string)
+
+// Registry is a catalog of error codes with their localized messages and
+// HTTP statuses. The zero value is not usable; create one with NewRegistry.
+// A Registry is safe for concurrent use.
+type Registry struct {
+	mu              sync.RWMutex
+	defaultLocale   string
+	messages        map[string]map[string]entry // locale -> code -> message
+	statuses        map[string]int              // code -> HTTP status
+	fallbacks       map[string][]string         // locale -> extra locales tried before the default
+	unknownCodeHook UnknownCodeHook
+	filePaths       []string
+
+	tmplMu    sync.Mutex
+	templates map[string]*template.Template
+}
+
+type entry struct {
+	CatalogEntry
+	source string
+}
+
+// NewRegistry returns an empty registry whose default locale is "en".
+func NewRegistry() *Registry {
+	return &Registry{
+		defaultLocale:   "en",
+		messages:        make(map[string]map[string]entry),
+		statuses:        make(map[string]int),
+		fallbacks:       make(map[string][]string),
+		unknownCodeHook: defaultUnknownCodeHook,
+		templates:       make(map[string]*template.Template),
+	}
+}
+
+func defaultUnknownCodeHook(code string, _ string) {
+	log.Printf("errorx: unknown error code %q, add it to the catalog", code)
+}
+
+// Load reads catalog files from disk into the default locale. See LoadFS.
+func (r *Registry) Load(paths ...string) error {
+	if len(paths) == 0 {
+		return errors.New("no catalog file given")
+	}
+	catalogs := make([]catalogFile, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("loading %s failed: %s", p, err)
+		}
+		catalogs = append(catalogs, catalogFile{source: p, data: data})
+	}
+	return r.merge("", catalogs)
+}
+
+// LoadFS reads catalog files from fsys, typically an embed.FS, into the
+// default locale. Several files can be merged, e.g. the codes shared by all
+// services and the service specific ones. A code defined with different
+// messages in two files, or in a file and the registry, is a conflict: the
+// conflicts are returned and nothing is loaded.
+func (r *Registry) LoadFS(fsys fs.FS, paths ...string) error {
+	return r.LoadLocaleFS(fsys, "", paths...)
+}
+
+// LoadLocaleFS is like LoadFS for the catalog of locale. An empty locale
+// means the default locale.
+func (r *Registry) LoadLocaleFS(fsys fs.FS, locale string, paths ...string) error {
+	if len(paths) == 0 {
+		return errors.New("no catalog file given")
+	}
+	catalogs := make([]catalogFile, 0, len(paths))
+	for _, p := range paths {
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("loading %s failed: %s", p, err)
+		}
+		catalogs = append(catalogs, catalogFile{source: p, data: data})
+	}
+	return r.merge(locale, catalogs)
+}
+
+// LoadLocalizedFS loads every "errors.<locale>.json" file found in the given
+// directories of fsys, e.g. errors.en.json and errors.vi.json. Files of the
+// same locale in different directories are merged like in LoadFS.
+func (r *Registry) LoadLocalizedFS(fsys fs.FS, dirs ...string) error {
+	byLocale := make(map[string][]string)
+	for _, dir := range dirs {
+		files, err := fs.Glob(fsys, path.Join(dir, "errors.*.json"))
+		if err != nil {
+			return fmt.Errorf("listing localized errors failed: %s", err)
+		}
+		for _, file := range files {
+			locale := strings.TrimSuffix(strings.TrimPrefix(path.Base(file), "errors."), ".json")
+			byLocale[locale] = append(byLocale[locale], file)
+		}
+	}
+	if len(byLocale) == 0 {
+		return fmt.Errorf("no errors.<locale>.json file found in %s", strings.Join(dirs, ", "))
+	}
+
+	locales := make([]string, 0, len(byLocale))
+	for locale := range byLocale {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	for _, locale := range locales {
+		if err := r.LoadLocaleFS(fsys, locale, byLocale[locale]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type catalogFile struct {
+	source string
+	data   []byte
+}
+
+func (r *Registry) merge(locale string, catalogs []catalogFile) error {
+	decoded := make([]map[string]CatalogEntry, len(catalogs))
+	for i, c := range catalogs {
+		entries, err := ParseCatalog(c.data)
+		if err != nil {
+			return fmt.Errorf("decoding %s failed: %s", c.source, err)
+		}
+		decoded[i] = entries
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if locale == "" {
+		locale = r.defaultLocale
+	}
+	locale = normalizeLocale(locale)
+
+	// Check everything first so a conflicting file leaves the registry untouched.
+	pending := make(map[string]entry)
+	var conflicts []error
+	for i, entries := range decoded {
+		for code, e := range entries {
+			if err := r.checkConflict(locale, code, e.Message, pending); err != nil {
+				conflicts = append(conflicts, fmt.Errorf("%s: %w", catalogs[i].source, err))
+				continue
+			}
+			if _, ok := r.messages[locale][code]; ok {
+				continue
+			}
+			if _, ok := pending[code]; !ok {
+				pending[code] = entry{CatalogEntry: e, source: catalogs[i].source}
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Error() < conflicts[j].Error() })
+		return errors.Join(conflicts...)
+	}
+
+	for code, e := range pending {
+		r.set(locale, code, e)
+		if _, ok := r.statuses[code]; !ok && e.HTTPStatus != 0 {
+			r.statuses[code] = e.HTTPStatus
+		}
+	}
+	for _, c := range catalogs {
+		r.filePaths = append(r.filePaths, c.source)
+	}
+	return nil
+}
+
+func (r *Registry) checkConflict(locale, code, message string, pending map[string]entry) error {
+	existing, ok := pending[code]
+	if !ok {
+		existing, ok = r.messages[locale][code]
+	}
+	if ok && existing.Message != message {
+		return fmt.Errorf("error code %s is %q, already defined as %q by %s", code, message, existing.Message, existing.source)
+	}
+	return nil
+}
+
+func (r *Registry) set(locale, code string, e entry) {
+	if r.messages[locale] == nil {
+		r.messages[locale] = make(map[string]entry)
+	}
+	r.messages[locale][code] = e
+}
+
+// Register adds a code/message pair to the default locale. Registering a
+// code again with the same message is a no-op; a different message is a
+// conflict.
+func (r *Registry) Register(code, message string) error {
+	return r.RegisterLocalized("", code, message)
+}
+
+// MustRegister is like Register but panics on conflict. It is meant to be
+// called from init functions of packages that own their error codes.
+func (r *Registry) MustRegister(code, message string) {
+	if err := r.Register(code, message); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterLocalized adds the message of code for locale. An empty locale
+// means the default locale.
+func (r *Registry) RegisterLocalized(locale, code, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if locale == "" {
+		locale = r.defaultLocale
+	}
+	locale = normalizeLocale(locale)
+	if err := r.checkConflict(locale, code, message, nil); err != nil {
+		return err
+	}
+	if _, ok := r.messages[locale][code]; !ok {
+		r.set(locale, code, entry{CatalogEntry: CatalogEntry{Message: message}, source: "Register"})
+	}
+	return nil
+}
+
+// SetUnknownCodeHook replaces the hook reporting lookups of codes missing
+// from the catalog. Passing nil disables reporting.
+func (r *Registry) SetUnknownCodeHook(hook UnknownCodeHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unknownCodeHook = hook
+}
+
+// Get returns a *CustomError for the given code. If the code is not in the
+// default locale catalog, the unknown code hook is called and Get returns a
+// *CustomError with the given fallback message, or with the code set to
+// CodeUnknown and the message set to MessageUnknown if no message is given.
+// Get never modifies the catalog.
+func (r *Registry) Get(code string, message ...string) *CustomError {
+	r.mu.RLock()
+	e, exists := r.messages[r.defaultLocale][code]
+	hook := r.unknownCodeHook
+	r.mu.RUnlock()
+
+	if exists {
+		return &CustomError{Code: code, Message: e.Message, reg: r.ref()}
+	}
+
+	var fallback string
+	if len(message) > 0 {
+		fallback = message[0]
+	}
+	if hook != nil {
+		hook(code, fallback)
+	}
+	if fallback == "" {
+		return &CustomError{Code: CodeUnknown, Message: MessageUnknown, reg: r.ref()}
+	}
+	return &CustomError{Code: code, Message: fallback, reg: r.ref()}
+}
+
+// GetMessage returns the default locale message of code, or "undefined
+// error" if the code is unknown.
+func (r *Registry) GetMessage(code string) string {
+	if msg, ok := r.lookup(code); ok {
+		return msg
+	}
+	return "undefined error"
+}
+
+func (r *Registry) lookup(code string) (string, bool) {
+	e, ok := r.Entry(code)
+	return e.Message, ok
+}
+
+// Entry returns the default locale catalog entry of code with its metadata.
+func (r *Registry) Entry(code string) (CatalogEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.messages[r.defaultLocale][code]
+	return e.CatalogEntry, ok
+}
+
+// Codes returns the codes of the default locale catalog, sorted.
+func (r *Registry) Codes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	codes := make([]string, 0, len(r.messages[r.defaultLocale]))
+	for code := range r.messages[r.defaultLocale] {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// FilePaths returns the catalog files loaded so far, in loading order.
+func (r *Registry) FilePaths() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.filePaths...)
+}
+
+// ref is the registry recorded in the errors it creates; errors of the
+// default registry keep a nil reference so they compare equal to literals.
+func (r *Registry) ref() *Registry {
+	if r == Default() {
+		return nil
+	}
+	return r
+}
@@ -0,0 +1,111 @@
+package errorx
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var catalogFS = fstest.MapFS{
+	"common/errors.json":   {Data: []byte(`{"10500": "An unexpected error occurred", "104011": "Unauthorized"}`)},
+	"service/errors.json":  {Data: []byte(`{"204041": "Order does not exist", "10500": "An unexpected error occurred"}`)},
+	"conflict/errors.json": {Data: []byte(`{"10500": "Something else went wrong", "204042": "Item does not exist"}`)},
+}
+
+func TestRegistryLoadFSMergesFiles(t *testing.T) {
+	r := NewRegistry()
+	assert.NoError(t, r.LoadFS(catalogFS, "common/errors.json", "service/errors.json"))
+
+	assert.Equal(t, []string{"104011", "10500", "204041"}, r.Codes())
+	assert.Equal(t, "Order does not exist", r.GetMessage("204041"))
+	assert.Equal(t, []string{"common/errors.json", "service/errors.json"}, r.FilePaths())
+}
+
+func TestRegistryLoadFSDetectsConflicts(t *testing.T) {
+	r := NewRegistry()
+	assert.NoError(t, r.LoadFS(catalogFS, "common/errors.json"))
+
+	err := r.LoadFS(catalogFS, "service/errors.json", "conflict/errors.json")
+	assert.ErrorContains(t, err, `conflict/errors.json: error code 10500 is "Something else went wrong", already defined as "An unexpected error occurred" by common/errors.json`)
+
+	// Nothing from the failed load is applied
+	assert.Equal(t, "undefined error", r.GetMessage("204041"))
+	assert.Equal(t, "undefined error", r.GetMessage("204042"))
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	assert.NoError(t, r.Register("104041", "User does not exist"))
+	assert.NoError(t, r.Register("104041", "User does not exist"))
+	assert.Error(t, r.Register("104041", "User not found"))
+	assert.Panics(t, func() { r.MustRegister("104041", "User not found") })
+}
+
+func TestRegistryIsInstanceScoped(t *testing.T) {
+	useMessages(t, map[string]string{"user_not_found": "Default registry message"})
+
+	r := NewRegistry()
+	r.MustRegister("user_not_found", "User does not exist")
+	r.RegisterStatus("user_not_found", http.StatusNotFound)
+	assert.NoError(t, r.RegisterLocalized("vi", "user_not_found", "Không tìm thấy người dùng"))
+
+	err := r.Get("user_not_found")
+	assert.Equal(t, "User does not exist", err.Message)
+	assert.Equal(t, http.StatusNotFound, err.HTTPStatus())
+	assert.Equal(t, "Không tìm thấy người dùng", err.Localize("vi").Message)
+
+	assert.Equal(t, "Default registry message", Get("user_not_found").Message)
+	assert.Equal(t, http.StatusInternalServerError, Get("user_not_found").HTTPStatus())
+}
+
+func TestRegistryWrapAndValidationAreInstanceScoped(t *testing.T) {
+	useMessages(t, map[string]string{"db_error": "Default registry message", "required": "Default required"})
+
+	r := NewRegistry()
+	r.MustRegister("db_error", "Database unavailable")
+	r.MustRegister("required", "{{.field}} is required")
+	r.MustRegister(CodeValidation, "Invalid input")
+	assert.NoError(t, r.RegisterLocalized("vi", "required", "{{.field}} là bắt buộc"))
+
+	err := r.Wrap(errors.New("connection refused"), "db_error")
+	assert.Equal(t, "Database unavailable", err.Message)
+	assert.Contains(t, err.StackTrace()[0].Function, "TestRegistryWrapAndValidationAreInstanceScoped")
+	assert.Nil(t, r.Wrap(nil, "db_error"))
+	assert.Equal(t, "Default registry message", Wrap(errors.New("connection refused"), "db_error").Message)
+
+	verr := r.NewValidationError().Add("name", "required", "", map[string]interface{}{"field": "name"})
+	assert.Equal(t, "name is required", verr.Fields[0].Message)
+	assert.Equal(t, "name là bắt buộc", verr.Localize("vi").Fields[0].Message)
+	assert.Equal(t, "Invalid input", verr.CustomError().Message)
+
+	assert.Equal(t, "Default required", NewValidationError().Add("name", "required", "", nil).Fields[0].Message)
+}
+
+func TestRegistryConcurrentAccess(t *testing.T) {
+	r := NewRegistry()
+	r.SetUnknownCodeHook(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Register("10"+strconv.Itoa(i)+strconv.Itoa(j), "message")
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.GetMessage("1000")
+				r.Get("1000").Localize("vi")
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, r.Codes(), 800)
+}
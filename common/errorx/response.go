@@ -0,0 +1,122 @@
+package errorx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/solum-sp/aps-be-common/common/utils"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeProblemJSON = "application/problem+json"
+)
+
+// Response is the standard error envelope returned by every service.
+type Response struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	TraceID   string                 `json:"trace_id,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// ProblemResponse is the RFC 7807 representation of Response, written when
+// the client accepts application/problem+json.
+type ProblemResponse struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Detail    string                 `json:"detail"`
+	Instance  string                 `json:"instance,omitempty"`
+	Code      string                 `json:"code"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	TraceID   string                 `json:"trace_id,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// NewResponse builds the envelope for err. The message, and the field
+// messages of a *ValidationError, are localized with the locale carried by
+// ctx. Errors that are neither a *CustomError nor a *ValidationError, and a
+// nil error, are reported as unknown errors so internal details never reach
+// the client.
+func NewResponse(ctx context.Context, err error) Response {
+	_, localized := ctx.Value(localeCtxKey{}).(string)
+	var verr *ValidationError
+	if localized && errors.As(err, &verr) {
+		err = verr.Localize(LocaleFromContext(ctx))
+	}
+	e := FromError(err)
+	if e == nil {
+		e = &CustomError{Code: CodeUnknown, Message: MessageUnknown}
+	}
+	if localized {
+		e = e.Localize(LocaleFromContext(ctx))
+	}
+
+	resp := Response{
+		Code:      e.Code,
+		Message:   e.Message,
+		RequestID: utils.RequestIDFromContext(ctx),
+	}
+	if len(e.Details) > 0 {
+		resp.Details = e.Details
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		resp.TraceID = sc.TraceID().String()
+	}
+	return resp
+}
+
+// WriteError writes err as the standard error envelope with the status code
+// of the error. The message is localized from the request context, or from
+// the Accept-Language header if the context carries no locale. Clients
+// accepting application/problem+json receive an RFC 7807 document instead.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	if strings.Contains(r.Header.Get("Accept"), ContentTypeProblemJSON) {
+		WriteProblem(w, r, err)
+		return
+	}
+	writeJSON(w, ContentTypeJSON, HTTPStatus(err), newRequestResponse(r, err))
+}
+
+// WriteProblem writes err as an RFC 7807 problem document.
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	status := HTTPStatus(err)
+	resp := newRequestResponse(r, err)
+	writeJSON(w, ContentTypeProblemJSON, status, ProblemResponse{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    resp.Message,
+		Instance:  r.URL.Path,
+		Code:      resp.Code,
+		Details:   resp.Details,
+		TraceID:   resp.TraceID,
+		RequestID: resp.RequestID,
+	})
+}
+
+func newRequestResponse(r *http.Request, err error) Response {
+	ctx := r.Context()
+	if _, ok := ctx.Value(localeCtxKey{}).(string); !ok {
+		if header := r.Header.Get("Accept-Language"); header != "" {
+			ctx = WithLocale(ctx, NegotiateLocale(header))
+		}
+	}
+	resp := NewResponse(ctx, err)
+	if resp.RequestID == "" {
+		resp.RequestID = r.Header.Get(utils.RequestIDHeader)
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
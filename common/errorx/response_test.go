@@ -0,0 +1,119 @@
+package errorx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/solum-sp/aps-be-common/common/utils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWriteError(t *testing.T) {
+	useMessages(t, map[string]string{"104041": "User does not exist"})
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set(utils.RequestIDHeader, "req-1")
+	rr := httptest.NewRecorder()
+
+	WriteError(rr, req, Get("104041").WithDetail("user_id", "1"))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, ContentTypeJSON, rr.Header().Get("Content-Type"))
+
+	var resp Response
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, Response{
+		Code:      "104041",
+		Message:   "User does not exist",
+		Details:   map[string]interface{}{"user_id": "1"},
+		RequestID: "req-1",
+	}, resp)
+}
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteError(rr, httptest.NewRequest("GET", "/", nil), errors.New("pq: connection refused"))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.JSONEq(t, `{"code":"unknown_error","message":"An unknown error occurred"}`, rr.Body.String())
+}
+
+func TestWriteErrorHidesCauseOfUnknownCode(t *testing.T) {
+	useMessages(t, nil)
+	err := Wrap(errors.New(`pq: relation "users" does not exist`), "105099")
+	assert.Equal(t, MessageUnknown, err.Message)
+	assert.Contains(t, err.Error(), `relation "users"`)
+
+	rr := httptest.NewRecorder()
+	WriteError(rr, httptest.NewRequest("GET", "/", nil), err)
+	assert.NotContains(t, rr.Body.String(), "pq:")
+	assert.JSONEq(t, `{"code":"105099","message":"An unknown error occurred"}`, rr.Body.String())
+}
+
+func TestWriteErrorNil(t *testing.T) {
+	rr := httptest.NewRecorder()
+	assert.NotPanics(t, func() { WriteError(rr, httptest.NewRequest("GET", "/", nil), nil) })
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.JSONEq(t, `{"code":"unknown_error","message":"An unknown error occurred"}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", ContentTypeProblemJSON)
+	assert.NotPanics(t, func() { WriteError(rr, req, nil) })
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestWriteErrorLocalizesFromAcceptLanguage(t *testing.T) {
+	setupLocales(t)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "vi-VN,vi;q=0.9")
+	rr := httptest.NewRecorder()
+
+	WriteError(rr, req, Get("104041").WithParams(map[string]interface{}{"name": "john"}))
+
+	var resp Response
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "Không tìm thấy người dùng john", resp.Message)
+}
+
+func TestWriteProblem(t *testing.T) {
+	useMessages(t, map[string]string{"104041": "User does not exist"})
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("Accept", ContentTypeProblemJSON)
+	rr := httptest.NewRecorder()
+
+	WriteError(rr, req, Get("104041"))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, ContentTypeProblemJSON, rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Not Found",
+		"status": 404,
+		"detail": "User does not exist",
+		"instance": "/users/1",
+		"code": "104041"
+	}`, rr.Body.String())
+}
+
+func TestNewResponseTraceAndRequestID(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+	ctx = utils.WithRequestID(ctx, "req-2")
+
+	resp := NewResponse(ctx, New("104001", "Incorrect password"))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", resp.TraceID)
+	assert.Equal(t, "req-2", resp.RequestID)
+}
@@ -0,0 +1,82 @@
+package errorx
+
+import (
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+)
+
+// RegisterStatus maps an error code of the default registry to the HTTP
+// status returned for it, overriding the status derived from the code itself.
+func RegisterStatus(code string, httpStatus int) {
+	Default().RegisterStatus(code, httpStatus)
+}
+
+// StatusForCode returns the HTTP status for an error code of the default
+// registry. See Registry.StatusForCode.
+func StatusForCode(code string) int {
+	return Default().StatusForCode(code)
+}
+
+// RegisterStatus maps an error code to the HTTP status returned for it,
+// overriding the status derived from the code itself.
+func (r *Registry) RegisterStatus(code string, httpStatus int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[code] = httpStatus
+}
+
+// StatusForCode returns the HTTP status for an error code. Codes registered
+// with RegisterStatus win; otherwise codes following the catalog convention
+// "<2-digit service><3-digit HTTP status><sequence>" (e.g. "104041" -> 404)
+// resolve to the embedded status. Anything else maps to 500.
+func (r *Registry) StatusForCode(code string) int {
+	r.mu.RLock()
+	httpStatus, ok := r.statuses[code]
+	r.mu.RUnlock()
+	if ok {
+		return httpStatus
+	}
+
+	if len(code) >= 5 {
+		if s, err := strconv.Atoi(code[2:5]); err == nil && s >= 400 && s < 600 {
+			return s
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// grpcCodeFromHTTP follows the HTTP to gRPC mapping used by grpc-gateway.
+func grpcCodeFromHTTP(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusOK:
+		return codes.OK
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499: // Client closed request
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	if httpStatus >= 500 {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,150 @@
+package errorx
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	CodeValidation    = "validation_error"
+	MessageValidation = "The request is invalid"
+)
+
+// FieldError describes why the value at Field is invalid. Field is a path
+// such as "items[0].name"; Code identifies the failed rule and is also looked
+// up in the catalog to localize Message.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	params map[string]interface{}
+}
+
+// ValidationError accumulates field errors so that all of them are reported
+// at once. It is written by WriteError as a validation_error response whose
+// details hold the field errors, with a 400 status.
+type ValidationError struct {
+	Fields []FieldError
+
+	reg *Registry // nil for the default registry
+}
+
+var _ error = (*ValidationError)(nil)
+
+// NewValidationError returns an empty ValidationError whose messages come
+// from the default registry.
+func NewValidationError() *ValidationError {
+	return &ValidationError{}
+}
+
+// NewValidationError returns an empty ValidationError whose messages come
+// from r.
+func (r *Registry) NewValidationError() *ValidationError {
+	return &ValidationError{reg: r.ref()}
+}
+
+// Add appends an error for field. Its message is the catalog message of code
+// rendered with params, or message if the catalog has none for code.
+func (v *ValidationError) Add(field, code, message string, params map[string]interface{}) *ValidationError {
+	reg := v.registry()
+	if msg, ok := reg.Message(reg.DefaultLocale(), code); ok {
+		message = msg
+	}
+	v.Fields = append(v.Fields, FieldError{
+		Field:   field,
+		Code:    code,
+		Message: reg.render(message, params),
+		params:  params,
+	})
+	return v
+}
+
+// Merge appends the errors of other with their fields prefixed by prefix,
+// e.g. "address" turns "city" into "address.city".
+func (v *ValidationError) Merge(prefix string, other *ValidationError) *ValidationError {
+	if other == nil {
+		return v
+	}
+	for _, f := range other.Fields {
+		switch {
+		case prefix == "":
+		case f.Field == "":
+			f.Field = prefix
+		case strings.HasPrefix(f.Field, "["):
+			f.Field = prefix + f.Field
+		default:
+			f.Field = prefix + "." + f.Field
+		}
+		v.Fields = append(v.Fields, f)
+	}
+	return v
+}
+
+// Len returns the number of field errors.
+func (v *ValidationError) Len() int {
+	return len(v.Fields)
+}
+
+// Err returns v, or nil if no error was added, so that functions can end with
+// "return verr.Err()".
+func (v *ValidationError) Err() error {
+	if v == nil || len(v.Fields) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v *ValidationError) Error() string {
+	parts := make([]string, len(v.Fields))
+	for i, f := range v.Fields {
+		parts[i] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", CodeValidation, MessageValidation, strings.Join(parts, "; "))
+}
+
+// Class returns ClassValidation.
+func (v *ValidationError) Class() Class {
+	return ClassValidation
+}
+
+// Localize returns a copy whose field messages are rendered in the best
+// available locale for locale. Messages of codes missing from the catalog
+// are kept.
+func (v *ValidationError) Localize(locale string) *ValidationError {
+	reg := v.registry()
+	c := &ValidationError{Fields: make([]FieldError, len(v.Fields)), reg: v.reg}
+	for i, f := range v.Fields {
+		if msg, ok := reg.Message(locale, f.Code); ok {
+			f.Message = reg.render(msg, f.params)
+		}
+		c.Fields[i] = f
+	}
+	return c
+}
+
+// CustomError converts v into the *CustomError reported to clients: code
+// CodeValidation, status 400 and the field errors in Details["fields"].
+func (v *ValidationError) CustomError() *CustomError {
+	message, ok := v.registry().lookup(CodeValidation)
+	if !ok {
+		message = MessageValidation
+	}
+	return &CustomError{
+		Code:    CodeValidation,
+		Message: message,
+		Details: map[string]interface{}{"fields": v.Fields},
+		status:  http.StatusBadRequest,
+		reg:     v.reg,
+	}
+}
+
+func (v *ValidationError) customError() *CustomError { return v.CustomError() }
+
+func (v *ValidationError) registry() *Registry {
+	if v.reg != nil {
+		return v.reg
+	}
+	return Default()
+}
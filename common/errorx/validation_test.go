@@ -0,0 +1,63 @@
+package errorx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationErrorAccumulates(t *testing.T) {
+	useMessages(t, nil)
+	RegisterLocalized("vi", "required", "Trường này là bắt buộc")
+
+	verr := NewValidationError()
+	assert.NoError(t, verr.Err())
+
+	address := NewValidationError().Add("city", "required", "This field is required", nil)
+	verr.Add("name", "min", "Must be at least {{.min}} characters", map[string]interface{}{"min": 3})
+	verr.Merge("address", address)
+	verr.Merge("items", NewValidationError().Add("[0]", "required", "This field is required", nil))
+
+	err := verr.Err()
+	assert.Error(t, err)
+	assert.Equal(t, 3, verr.Len())
+	assert.Equal(t, "address.city", verr.Fields[1].Field)
+	assert.Equal(t, "items[0]", verr.Fields[2].Field)
+	assert.Equal(t, "Must be at least 3 characters", verr.Fields[0].Message)
+	assert.True(t, IsValidation(fmt.Errorf("create user: %w", err)))
+	assert.Equal(t, http.StatusBadRequest, HTTPStatus(err))
+	assert.True(t, IsUserFacing(err))
+
+	localized := verr.Localize("vi")
+	assert.Equal(t, "Trường này là bắt buộc", localized.Fields[1].Message)
+	assert.Equal(t, "This field is required", verr.Fields[1].Message, "Localize must not mutate the receiver")
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	useMessages(t, nil)
+	RegisterLocalized("vi", "required", "Trường này là bắt buộc")
+	verr := NewValidationError().Add("email", "required", "This field is required", nil)
+
+	resp := NewResponse(WithLocale(context.Background(), "vi"), fmt.Errorf("wrapped: %w", verr))
+	assert.Equal(t, CodeValidation, resp.Code)
+	assert.Equal(t, MessageValidation, resp.Message)
+	assert.Equal(t, []FieldError{{Field: "email", Code: "required", Message: "Trường này là bắt buộc"}}, resp.Details["fields"])
+
+	rec := httptest.NewRecorder()
+	WriteError(rec, httptest.NewRequest(http.MethodPost, "/users", nil), verr)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var body struct {
+		Code    string `json:"code"`
+		Details struct {
+			Fields []FieldError `json:"fields"`
+		} `json:"details"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, CodeValidation, body.Code)
+	assert.Equal(t, []FieldError{{Field: "email", Code: "required", Message: "This field is required"}}, body.Details.Fields)
+}
@@ -0,0 +1,249 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+USAGE EXAMPLE:
+
+	err := subscriber.ConsumeBatch(ctx, func(ctx context.Context, msgs []event.Message) error {
+		var failed event.BatchError
+		rows := make([]PageView, 0, len(msgs))
+		for i, msg := range msgs {
+			var view PageView
+			if err := msg.Decode(&view); err != nil {
+				failed.Add(i, err) // Skipped: decoding errors are validation errors
+				continue
+			}
+			rows = append(rows, view)
+		}
+		if err := store.InsertPageViews(ctx, rows); err != nil {
+			return err // The whole batch is retried
+		}
+		return failed.Err()
+	}, event.WithConsumeBatchSize(500), event.WithConsumeBatchTimeout(2*time.Second))
+*/
+
+// BatchHandler processes a batch of consecutive messages of one partition.
+// The batch is committed once the handler returns nil. A *BatchError
+// reports the messages that failed, see BatchError; any other error fails
+// the whole batch, which is retried unless it is a validation error.
+type BatchHandler func(ctx context.Context, msgs []Message) error
+
+// BatchError reports the messages of a batch that failed, by index in the
+// batch. The messages before the first failure that is not a validation
+// error are committed and the others handled again in a new batch; failures
+// that are all validation errors are reported and skipped. A BatchError
+// without a failure at a valid index fails the whole batch.
+type BatchError struct {
+	Errors map[int]error
+}
+
+// Add records that the message at index i failed with err.
+func (e *BatchError) Add(i int, err error) {
+	if e.Errors == nil {
+		e.Errors = make(map[int]error)
+	}
+	e.Errors[i] = err
+}
+
+// Err returns e if a failure was added, or nil.
+func (e *BatchError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *BatchError) Error() string {
+	indexes := e.indexes()
+	if len(indexes) == 0 {
+		return "batch failed"
+	}
+	first := indexes[0]
+	return fmt.Sprintf("%d messages of the batch failed, first at %d: %s", len(indexes), first, e.Errors[first])
+}
+
+// Unwrap returns the errors of the failed messages, so errors.Is, errors.As
+// and the errorx classes see them.
+func (e *BatchError) Unwrap() []error {
+	indexes := e.indexes()
+	errs := make([]error, len(indexes))
+	for i, index := range indexes {
+		errs[i] = e.Errors[index]
+	}
+	return errs
+}
+
+func (e *BatchError) indexes() []int {
+	indexes := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// WithConsumeBatchSize sets the maximum number of messages of a batch
+// handled by ConsumeBatch, 100 by default
+func WithConsumeBatchSize(n int) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.BatchSize = n
+	}
+}
+
+// WithConsumeBatchTimeout sets how long ConsumeBatch waits for a batch to
+// fill once its first message arrived, 1s by default
+func WithConsumeBatchTimeout(d time.Duration) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.BatchTimeout = d
+	}
+}
+
+// ConsumeBatch is like Consume but hands the messages of each partition to
+// handler in batches of up to the batch size, or what arrived within the
+// batch timeout. Once the handler succeeds, the offset following the batch
+// is committed. Retry policies are not supported.
+func (s *kafkaSubscriber) ConsumeBatch(ctx context.Context, handler BatchHandler, opts ...ConsumeOption) error {
+	if s.metrics != nil {
+		opts = append([]ConsumeOption{WithConsumeMetrics(s.metrics)}, opts...)
+	}
+	return consumeBatch(ctx, s.consumer, []string{s.topic}, s.newMessage, handler, opts...)
+}
+
+// ConsumeBatch runs the same runtime as the Kafka subscriber on the broker.
+func (s *MemorySubscriber) ConsumeBatch(ctx context.Context, handler BatchHandler, opts ...ConsumeOption) error {
+	return consumeBatch(ctx, s, []string{s.topic}, newMemoryMessage, handler, opts...)
+}
+
+func consumeBatch(ctx context.Context, client consumerClient, topics []string, newMessage func(*kafka.Message) Message, handler BatchHandler, opts ...ConsumeOption) error {
+	config := newConsumeConfig(opts)
+	if config.Retry != nil {
+		return errorx.Tag(errors.New("retry policies are not supported by ConsumeBatch"), errorx.ClassValidation)
+	}
+	// A partition is paused before it can fill a batch otherwise
+	config.QueueSize = max(config.QueueSize, config.BatchSize)
+
+	rt := newConsumeRuntime(ctx, client, config, newMessage)
+	defer rt.cancel()
+	rt.batchHandler = handler
+	return rt.start(ctx, topics)
+}
+
+// handleBatch runs the batch handler, turning a panic into an error.
+func (rt *consumeRuntime) handleBatch(msgs []Message) (err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = errorx.Tag(fmt.Errorf("handler panic: %v", r), errorx.ClassInternal)
+		}
+		if rt.config.Metrics != nil {
+			rt.config.Metrics.ObserveHandled(msgs[0].Topic, time.Since(start), err)
+		}
+	}()
+	// The batch is handled in the trace context of its first message
+	return rt.batchHandler(ContextFromHeaders(rt.base, msgs[0].Headers), msgs)
+}
+
+// nextBatch waits for the next message and the ones following it, up to
+// the batch size or until the batch timeout elapsed. It returns false once
+// the worker must stop.
+func (w *partitionWorker) nextBatch() ([]Message, bool) {
+	first, ok := w.next()
+	if !ok {
+		return nil, false
+	}
+	batch := []Message{first}
+	timeout := time.NewTimer(w.rt.config.BatchTimeout)
+	defer timeout.Stop()
+	for len(batch) < w.rt.config.BatchSize {
+		w.mu.Lock()
+		n := min(len(w.queue), w.rt.config.BatchSize-len(batch))
+		batch = append(batch, w.queue[:n]...)
+		clear(w.queue[:n])
+		w.queue = w.queue[n:]
+		w.mu.Unlock()
+		if len(batch) == w.rt.config.BatchSize {
+			break
+		}
+
+		select {
+		case <-w.wake:
+		case <-timeout.C:
+			return batch, true
+		case <-w.rt.stop:
+			return nil, false
+		case <-w.revoked:
+			return nil, false
+		}
+	}
+	return batch, true
+}
+
+// processBatch handles msgs until all of them succeeded or were skipped. It
+// returns false if the worker stopped meanwhile.
+func (w *partitionWorker) processBatch(msgs []Message) bool {
+	for len(msgs) > 0 {
+		if !w.acquire() {
+			return false
+		}
+		err := w.rt.handleBatch(msgs)
+		<-w.rt.sem
+
+		last := msgs[len(msgs)-1]
+		if err == nil {
+			w.markHandled(last.Offset)
+			return true
+		}
+
+		// A *BatchError without a failure in the batch fails the whole batch
+		var failed []int
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			for _, i := range batchErr.indexes() {
+				if i >= 0 && i < len(msgs) {
+					failed = append(failed, i)
+				}
+			}
+		}
+		if len(failed) == 0 {
+			err = fmt.Errorf("failed to handle batch %s[%d]@%d-%d: %w", last.Topic, last.Partition, msgs[0].Offset, last.Offset, err)
+			w.rt.config.OnError(err)
+			if errorx.IsValidation(err) {
+				// Retrying cannot fix the batch; skip it.
+				w.markHandled(last.Offset)
+				return true
+			}
+		} else {
+			retry := len(msgs)
+			for _, i := range failed {
+				msg := msgs[i]
+				err := fmt.Errorf("failed to handle message %s[%d]@%d: %w", msg.Topic, msg.Partition, msg.Offset, batchErr.Errors[i])
+				w.rt.config.OnError(err)
+				if !errorx.IsValidation(err) && i < retry {
+					retry = i
+				}
+			}
+			if retry > 0 {
+				w.markHandled(msgs[retry-1].Offset)
+			}
+			msgs = msgs[retry:]
+			if len(msgs) == 0 {
+				return true
+			}
+		}
+
+		if !w.sleep(w.rt.config.RetryBackoff) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runConsumeBatch runs consumeBatch in the background and returns a function
+// stopping it and returning its error.
+func runConsumeBatch(t *testing.T, c *fakeConsumer, handler BatchHandler, opts ...ConsumeOption) func() error {
+	opts = append([]ConsumeOption{WithConsumeCommitInterval(10 * time.Millisecond), WithConsumeErrorHandler(func(error) {})}, opts...)
+	return runInBackground(t, func(ctx context.Context) error {
+		return consumeBatch(ctx, c, []string{"orders"}, testNewMessage, handler, opts...)
+	})
+}
+
+// offsetsOf returns the offsets of msgs.
+func offsetsOf(msgs []Message) []int64 {
+	offsets := make([]int64, len(msgs))
+	for i, msg := range msgs {
+		offsets[i] = msg.Offset
+	}
+	return offsets
+}
+
+func TestConsumeBatchCommitsAfterBatch(t *testing.T) {
+	c := newFakeConsumer()
+	var (
+		mu      sync.Mutex
+		batches [][]int64
+	)
+	for i := int64(0); i < 5; i++ {
+		c.events <- testMessage(0, i)
+	}
+	stop := runConsumeBatch(t, c, func(ctx context.Context, msgs []Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, offsetsOf(msgs))
+		return nil
+	}, WithConsumeBatchSize(3), WithConsumeBatchTimeout(50*time.Millisecond))
+
+	require.Eventually(t, func() bool { return c.committed(0) == 5 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+	assert.Equal(t, [][]int64{{0, 1, 2}, {3, 4}}, batches)
+}
+
+func TestConsumeBatchPartialFailure(t *testing.T) {
+	c := newFakeConsumer()
+	var (
+		mu      sync.Mutex
+		batches [][]int64
+		reports []error
+	)
+	for i := int64(0); i < 4; i++ {
+		c.events <- testMessage(0, i)
+	}
+	stop := runConsumeBatch(t, c, func(ctx context.Context, msgs []Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, offsetsOf(msgs))
+		if len(batches) > 1 {
+			return nil
+		}
+		var failed BatchError
+		failed.Add(1, errorx.Tag(errors.New("bad payload"), errorx.ClassValidation))
+		failed.Add(2, errors.New("database unavailable"))
+		return failed.Err()
+	},
+		WithConsumeBatchSize(4),
+		WithConsumeBatchTimeout(50*time.Millisecond),
+		WithConsumeRetryBackoff(time.Millisecond),
+		WithConsumeErrorHandler(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reports = append(reports, err)
+		}),
+	)
+
+	require.Eventually(t, func() bool { return c.committed(0) == 4 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+	assert.Equal(t, [][]int64{{0, 1, 2, 3}, {2, 3}}, batches, "the batch is retried from the first failure that is not a validation error")
+	require.Len(t, reports, 2)
+	assert.Contains(t, reports[0].Error(), "orders[0]@1: bad payload")
+	assert.Contains(t, reports[1].Error(), "orders[0]@2: database unavailable")
+}
+
+func TestConsumeBatchEmptyBatchErrorRetriesBatch(t *testing.T) {
+	c := newFakeConsumer()
+	var (
+		mu      sync.Mutex
+		batches [][]int64
+		reports []error
+	)
+	c.events <- testMessage(0, 0)
+	c.events <- testMessage(0, 1)
+	stop := runConsumeBatch(t, c, func(ctx context.Context, msgs []Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, offsetsOf(msgs))
+		if len(batches) > 1 {
+			return nil
+		}
+		failed := &BatchError{}
+		failed.Add(5, errors.New("out of range"))
+		return failed
+	},
+		WithConsumeBatchSize(2),
+		WithConsumeRetryBackoff(time.Millisecond),
+		WithConsumeErrorHandler(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reports = append(reports, err)
+		}),
+	)
+
+	require.Eventually(t, func() bool { return c.committed(0) == 2 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+	assert.Equal(t, [][]int64{{0, 1}, {0, 1}}, batches)
+	require.Len(t, reports, 1)
+	assert.Contains(t, reports[0].Error(), "failed to handle batch orders[0]@0-1")
+}
+
+func TestConsumeBatchSkipsInvalidBatch(t *testing.T) {
+	c := newFakeConsumer()
+	var calls int
+	c.events <- testMessage(0, 0)
+	c.events <- testMessage(0, 1)
+	stop := runConsumeBatch(t, c, func(ctx context.Context, msgs []Message) error {
+		calls++
+		return errorx.Tag(errors.New("unsupported version"), errorx.ClassValidation)
+	}, WithConsumeBatchSize(2))
+
+	require.Eventually(t, func() bool { return c.committed(0) == 2 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+	assert.Equal(t, 1, calls)
+}
+
+func TestConsumeBatchRejectsRetryPolicy(t *testing.T) {
+	err := consumeBatch(context.Background(), newFakeConsumer(), []string{"orders"}, testNewMessage,
+		func(ctx context.Context, msgs []Message) error { return nil },
+		WithRetryPolicy(DefaultRetryPolicy, nil))
+	assert.True(t, errorx.IsValidation(err))
+}
+
+func TestBatchError(t *testing.T) {
+	var failed BatchError
+	assert.NoError(t, failed.Err())
+
+	notFound := errorx.Tag(errors.New("missing"), errorx.ClassNotFound)
+	failed.Add(3, errors.New("boom"))
+	failed.Add(1, notFound)
+	err := failed.Err()
+	assert.EqualError(t, err, "2 messages of the batch failed, first at 1: missing")
+	assert.ErrorIs(t, err, notFound)
+	assert.True(t, errorx.IsNotFound(err))
+}
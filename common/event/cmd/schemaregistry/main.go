@@ -0,0 +1,339 @@
+// Command schemaregistry manages the schemas of the schema registry, e.g. to
+// block incompatible schema changes in CI.
+//
+// Usage:
+//
+//	go run github.com/solum-sp/aps-be-common/common/event/cmd/schemaregistry check -url http://localhost:8081 -topic orders schemas/order.avsc
+//	go run github.com/solum-sp/aps-be-common/common/event/cmd/schemaregistry register -url http://localhost:8081 -topic orders -strategy topic-record schemas/*.avsc
+//	go run github.com/solum-sp/aps-be-common/common/event/cmd/schemaregistry compat -url http://localhost:8081 -subject orders-value -level BACKWARD_TRANSITIVE
+//	go run github.com/solum-sp/aps-be-common/common/event/cmd/schemaregistry versions -url http://localhost:8081 -subject orders-value
+//
+// check exits with status 1 when a schema file is incompatible with the
+// latest version of its subject. register registers the schema files and
+// prints their IDs. compat prints the compatibility level of a subject, or
+// the global level, and sets it with -level. versions prints the versions of
+// a subject, or the subjects if none is given.
+//
+// The format of a schema file follows its extension: .avsc is Avro, .proto
+// Protobuf and .json JSON Schema; -format overrides it.
+//
+// Every command authenticates with -user and -password, or with -token and
+// the Confluent Cloud -logical-cluster and -identity-pool, and verifies the
+// registry with -tls-ca, presenting -tls-cert and -tls-key for mutual TLS. The
+// credentials default to the SCHEMA_REGISTRY_USER, SCHEMA_REGISTRY_PASSWORD
+// and SCHEMA_REGISTRY_TOKEN environment variables, keeping them out of the
+// command line.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/solum-sp/aps-be-common/common/event"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "check":
+		var ok bool
+		ok, err = runCheck(os.Args[2:], os.Stdout)
+		if err == nil && !ok {
+			os.Exit(1)
+		}
+	case "register":
+		err = runRegister(os.Args[2:], os.Stdout)
+	case "compat":
+		err = runCompat(os.Args[2:], os.Stdout)
+	case "versions":
+		err = runVersions(os.Args[2:], os.Stdout)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "schemaregistry:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+	schemaregistry check [connection flags] -topic topic [-key] [-format format] [-strategy strategy] files
+	schemaregistry register [connection flags] -topic topic [-key] [-format format] [-strategy strategy] files
+	schemaregistry compat [connection flags] [-subject subject] [-level level]
+	schemaregistry versions [connection flags] [-subject subject]
+
+connection flags:
+	[-url url] [-user user -password password] [-token token [-logical-cluster id] [-identity-pool id]]
+	[-tls-ca file] [-tls-cert file -tls-key file]`)
+}
+
+// connFlags are the flags connecting to the schema registry.
+type connFlags struct {
+	url            *string
+	user           *string
+	password       *string
+	token          *string
+	logicalCluster *string
+	identityPool   *string
+	ca             *string
+	cert           *string
+	key            *string
+}
+
+func newConnFlags(fs *flag.FlagSet) connFlags {
+	return connFlags{
+		url:            fs.String("url", event.DefaultConfig.Schema.URL, "schema registry URL"),
+		user:           fs.String("user", "", "basic auth user, $SCHEMA_REGISTRY_USER if empty"),
+		password:       fs.String("password", "", "basic auth password, $SCHEMA_REGISTRY_PASSWORD if empty"),
+		token:          fs.String("token", "", "bearer token, $SCHEMA_REGISTRY_TOKEN if empty"),
+		logicalCluster: fs.String("logical-cluster", "", "logical cluster of the bearer token (Confluent Cloud)"),
+		identityPool:   fs.String("identity-pool", "", "identity pool of the bearer token (Confluent Cloud)"),
+		ca:             fs.String("tls-ca", "", "CA file verifying the registry"),
+		cert:           fs.String("tls-cert", "", "client certificate file for mutual TLS"),
+		key:            fs.String("tls-key", "", "client key file for mutual TLS"),
+	}
+}
+
+// options returns the options connecting to the registry.
+func (f connFlags) options() []event.KafkaOption {
+	opts := []event.KafkaOption{event.WithKafkaSchemaRegistryURL(*f.url)}
+	if token := orEnv(*f.token, "SCHEMA_REGISTRY_TOKEN"); token != "" {
+		opts = append(opts, event.WithSchemaRegistryBearerAuth(token, *f.logicalCluster, *f.identityPool))
+	}
+	if user := orEnv(*f.user, "SCHEMA_REGISTRY_USER"); user != "" {
+		opts = append(opts, event.WithSchemaRegistryBasicAuth(user, orEnv(*f.password, "SCHEMA_REGISTRY_PASSWORD")))
+	}
+	if *f.ca != "" || *f.cert != "" || *f.key != "" {
+		opts = append(opts, event.WithSchemaRegistryTLS(*f.ca, *f.cert, *f.key))
+	}
+	return opts
+}
+
+func (f connFlags) registry(opts ...event.KafkaOption) (*event.SchemaRegistry, error) {
+	return event.NewSchemaRegistry(append(f.options(), opts...)...)
+}
+
+// orEnv returns value, or the environment variable name if value is empty.
+func orEnv(value, name string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+var strategies = map[string]event.SubjectNameStrategy{
+	"topic":        event.TopicNameStrategy,
+	"record":       event.RecordNameStrategy,
+	"topic-record": event.TopicRecordNameStrategy,
+}
+
+// schemaFlags are the flags of the commands handling schema files.
+type schemaFlags struct {
+	conn     connFlags
+	topic    *string
+	key      *bool
+	format   *string
+	strategy *string
+}
+
+func newSchemaFlags(fs *flag.FlagSet) schemaFlags {
+	return schemaFlags{
+		conn:     newConnFlags(fs),
+		topic:    fs.String("topic", "", "topic of the schemas"),
+		key:      fs.Bool("key", false, "the schemas are key schemas"),
+		format:   fs.String("format", "", "avro, protobuf or jsonschema; from the file extension if empty"),
+		strategy: fs.String("strategy", "topic", "subject name strategy: topic, record or topic-record"),
+	}
+}
+
+func (f schemaFlags) registry() (*event.SchemaRegistry, error) {
+	if *f.topic == "" {
+		return nil, fmt.Errorf("-topic is required")
+	}
+	strategy, ok := strategies[*f.strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown subject name strategy %q", *f.strategy)
+	}
+	return f.conn.registry(event.WithSubjectNameStrategy(strategy))
+}
+
+// schemaFile is a schema file with its format and subject.
+type schemaFile struct {
+	path    string
+	format  event.Format
+	schema  string
+	subject string
+}
+
+func (f schemaFlags) read(sr *event.SchemaRegistry, paths []string) ([]schemaFile, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no schema file given")
+	}
+	files := make([]schemaFile, 0, len(paths))
+	for _, path := range paths {
+		format := event.Format(*f.format)
+		if format == "" {
+			var err error
+			if format, err = formatOf(path); err != nil {
+				return nil, err
+			}
+		}
+		schema, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		subject, err := sr.Subject(*f.topic, *f.key, format, string(schema))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		files = append(files, schemaFile{path: path, format: format, schema: string(schema), subject: subject})
+	}
+	return files, nil
+}
+
+// formatOf returns the format of a schema file from its extension.
+func formatOf(path string) (event.Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".avsc":
+		return event.FormatAvro, nil
+	case ".proto":
+		return event.FormatProtobuf, nil
+	case ".json":
+		return event.FormatJSONSchema, nil
+	}
+	return "", fmt.Errorf("%s: unknown schema format, set -format", path)
+}
+
+func runCheck(args []string, out io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	flags := newSchemaFlags(fs)
+	fs.Parse(args)
+
+	sr, err := flags.registry()
+	if err != nil {
+		return false, err
+	}
+	defer sr.Close()
+	files, err := flags.read(sr, fs.Args())
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	for _, f := range files {
+		compatible, err := sr.CheckCompatibility(f.subject, f.format, f.schema)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", f.path, err)
+		}
+		status := "compatible"
+		if !compatible {
+			status = "INCOMPATIBLE"
+			ok = false
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\n", f.path, f.subject, status)
+	}
+	return ok, nil
+}
+
+func runRegister(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("register", flag.ExitOnError)
+	flags := newSchemaFlags(fs)
+	fs.Parse(args)
+
+	sr, err := flags.registry()
+	if err != nil {
+		return err
+	}
+	defer sr.Close()
+	files, err := flags.read(sr, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		id, err := sr.RegisterSchema(f.subject, f.format, f.schema)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%d\n", f.path, f.subject, id)
+	}
+	return nil
+}
+
+func runCompat(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("compat", flag.ExitOnError)
+	conn := newConnFlags(fs)
+	subject := fs.String("subject", "", "subject, the global level if empty")
+	level := fs.String("level", "", "level to set, e.g. BACKWARD, FULL_TRANSITIVE or NONE")
+	fs.Parse(args)
+
+	sr, err := conn.registry()
+	if err != nil {
+		return err
+	}
+	defer sr.Close()
+
+	if *level != "" {
+		var c schemaregistry.Compatibility
+		if err := c.ParseString(strings.ToUpper(*level)); err != nil {
+			return fmt.Errorf("unknown compatibility level %q", *level)
+		}
+		if err := sr.SetCompatibility(*subject, c); err != nil {
+			return err
+		}
+	}
+	c, err := sr.Compatibility(*subject)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, c.String())
+	return nil
+}
+
+func runVersions(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("versions", flag.ExitOnError)
+	conn := newConnFlags(fs)
+	subject := fs.String("subject", "", "subject, all the subjects if empty")
+	fs.Parse(args)
+
+	sr, err := conn.registry()
+	if err != nil {
+		return err
+	}
+	defer sr.Close()
+
+	if *subject == "" {
+		subjects, err := sr.Subjects()
+		if err != nil {
+			return err
+		}
+		for _, s := range subjects {
+			fmt.Fprintln(out, s)
+		}
+		return nil
+	}
+	versions, err := sr.Versions(*subject)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		meta, err := sr.GetSchema(*subject, v)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d\t%d\n", v, meta.ID)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/solum-sp/aps-be-common/common/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatOf(t *testing.T) {
+	for path, want := range map[string]event.Format{
+		"schemas/order.avsc":  event.FormatAvro,
+		"schemas/order.proto": event.FormatProtobuf,
+		"schemas/Order.JSON":  event.FormatJSONSchema,
+	} {
+		got, err := formatOf(path)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, path)
+	}
+	_, err := formatOf("schemas/order.txt")
+	assert.Error(t, err)
+}
+
+func TestRunRegister(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "order.avsc")
+	require.NoError(t, os.WriteFile(path, []byte(`{"type":"record","name":"Order","namespace":"com.example","fields":[{"name":"id","type":"string"}]}`), 0644))
+
+	var out bytes.Buffer
+	err := runRegister([]string{"-url", "mock://register", "-topic", "orders", "-strategy", "topic-record", path}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, path+"\torders-com.example.Order\t1\n", out.String())
+
+	err = runRegister([]string{"-url", "mock://register", "-topic", "orders", "-strategy", "nope", path}, &out)
+	assert.Error(t, err)
+}
+
+func TestRunCompat(t *testing.T) {
+	var out bytes.Buffer
+	err := runCompat([]string{"-url", "mock://compat", "-subject", "orders-value", "-level", "full_transitive"}, &out)
+	require.NoError(t, err)
+	assert.Equal(t, "FULL_TRANSITIVE\n", out.String())
+}
+
+func TestRunCheck(t *testing.T) {
+	server := event.NewMockSchemaRegistryServer()
+	defer server.Close()
+	sr, err := event.NewSchemaRegistry(event.WithKafkaSchemaRegistryURL(server.URL()))
+	require.NoError(t, err)
+	_, err = sr.RegisterSchema("orders-value", event.FormatAvro, `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"}]}`)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	compatible := filepath.Join(dir, "compatible.avsc")
+	require.NoError(t, os.WriteFile(compatible, []byte(`{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"note","type":"string","default":""}]}`), 0644))
+	incompatible := filepath.Join(dir, "incompatible.avsc")
+	require.NoError(t, os.WriteFile(incompatible, []byte(`{"type":"record","name":"Order","fields":[{"name":"id","type":"int"}]}`), 0644))
+
+	var out bytes.Buffer
+	ok, err := runCheck([]string{"-url", server.URL(), "-topic", "orders", compatible}, &out)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	out.Reset()
+	ok, err = runCheck([]string{"-url", server.URL(), "-topic", "orders", compatible, incompatible}, &out)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, out.String(), incompatible+"\torders-value\tINCOMPATIBLE\n")
+}
+
+func TestConnFlags(t *testing.T) {
+	apply := func(args ...string) event.SchemaRegistryConfig {
+		t.Helper()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		conn := newConnFlags(fs)
+		require.NoError(t, fs.Parse(args))
+		var (
+			producer event.KafkaProducerConfig
+			consumer event.KafkaConsumerConfig
+			schema   event.SchemaRegistryConfig
+		)
+		for _, opt := range conn.options() {
+			opt(&producer, &consumer, &schema)
+		}
+		return schema
+	}
+
+	c := apply("-url", "https://sr:8081", "-user", "ci", "-password", "secret", "-tls-ca", "ca.pem")
+	assert.Equal(t, "https://sr:8081", c.URL)
+	assert.Equal(t, "ci", c.BasicAuthUsername)
+	assert.Equal(t, "secret", c.BasicAuthPassword)
+	assert.Equal(t, "ca.pem", c.CALocation)
+
+	t.Setenv("SCHEMA_REGISTRY_TOKEN", "token")
+	c = apply("-logical-cluster", "lsrc-1", "-identity-pool", "pool-1", "-tls-cert", "cert.pem", "-tls-key", "key.pem")
+	assert.Equal(t, "token", c.BearerToken)
+	assert.Equal(t, "lsrc-1", c.LogicalCluster)
+	assert.Equal(t, "pool-1", c.IdentityPoolID)
+	assert.Equal(t, "cert.pem", c.CertLocation)
+	assert.Equal(t, "key.pem", c.KeyLocation)
+	assert.Empty(t, c.BasicAuthUsername)
+}
@@ -0,0 +1,626 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+USAGE EXAMPLE:
+
+	err := subscriber.Consume(ctx, func(ctx context.Context, msg event.Message) error {
+		var evt OrderCreated
+		if err := msg.Decode(&evt); err != nil {
+			return err
+		}
+		return handleOrderCreated(ctx, evt)
+	}, event.WithConsumeConcurrency(8))
+*/
+
+// Handler processes a consumed message. Its context carries the trace
+// context and correlation IDs of the message. The message is committed once
+// the handler returns nil.
+type Handler func(ctx context.Context, msg Message) error
+
+// ConsumeConfig holds the Consume settings
+type ConsumeConfig struct {
+	Concurrency     int           // Handlers running in parallel, each on a different partition
+	QueueSize       int           // Messages buffered per partition before it is paused
+	RetryBackoff    time.Duration // Delay before a failed message is handled again
+	CommitInterval  time.Duration // How often the offsets of handled messages are committed
+	ShutdownTimeout time.Duration // Time given to running handlers once ctx is done
+	OnAssigned      func(partitions []kafka.TopicPartition)
+	OnRevoked       func(partitions []kafka.TopicPartition) // Called once the revoked partitions are committed
+	OnError         func(err error)
+	Retry           *RetryPolicy // See WithRetryPolicy
+	RetryProducer   MessageProducer
+	Metrics         *Metrics      // See WithConsumeMetrics
+	BatchSize       int           // Maximum messages of a batch, see ConsumeBatch
+	BatchTimeout    time.Duration // How long a batch is filled once its first message arrived
+}
+
+// DefaultConsumeConfig holds the default Consume settings
+var DefaultConsumeConfig = ConsumeConfig{
+	Concurrency:     1,
+	QueueSize:       100,
+	RetryBackoff:    time.Second,
+	CommitInterval:  time.Second,
+	ShutdownTimeout: 30 * time.Second,
+	BatchSize:       100,
+	BatchTimeout:    time.Second,
+}
+
+// ConsumeOption is a functional option for configuring Consume
+type ConsumeOption func(*ConsumeConfig)
+
+// WithConsumeConcurrency sets the number of handlers running in parallel.
+// Messages of the same partition are always handled one at a time, in order.
+func WithConsumeConcurrency(n int) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.Concurrency = n
+	}
+}
+
+// WithConsumeQueueSize sets the number of messages buffered per partition
+func WithConsumeQueueSize(n int) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.QueueSize = n
+	}
+}
+
+// WithConsumeRetryBackoff sets the delay before a failed message is retried
+func WithConsumeRetryBackoff(d time.Duration) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.RetryBackoff = d
+	}
+}
+
+// WithConsumeCommitInterval sets how often handled messages are committed
+func WithConsumeCommitInterval(d time.Duration) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.CommitInterval = d
+	}
+}
+
+// WithConsumeShutdownTimeout sets how long running handlers may finish once
+// ctx is done before their context is canceled
+func WithConsumeShutdownTimeout(d time.Duration) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.ShutdownTimeout = d
+	}
+}
+
+// WithRebalanceCallbacks sets the functions called when partitions are
+// assigned and revoked. Either may be nil.
+func WithRebalanceCallbacks(onAssigned, onRevoked func(partitions []kafka.TopicPartition)) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.OnAssigned = onAssigned
+		c.OnRevoked = onRevoked
+	}
+}
+
+// WithConsumeErrorHandler sets the function receiving handler, consumer and
+// commit errors. They are logged by default.
+func WithConsumeErrorHandler(f func(err error)) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.OnError = f
+	}
+}
+
+// WithConsumeMetrics records the consumed messages, handler latency and
+// errors, commit failures and, from the librdkafka statistics, the lag of
+// each partition in m.
+func WithConsumeMetrics(m *Metrics) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.Metrics = m
+	}
+}
+
+// consumerClient is the part of *kafka.Consumer used by Consume.
+type consumerClient interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	Poll(timeoutMs int) kafka.Event
+	CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	Pause(partitions []kafka.TopicPartition) error
+	Resume(partitions []kafka.TopicPartition) error
+}
+
+// Consume subscribes to the topic and calls handler for each message until
+// ctx is done. It replaces any subscription made with SubscribeToTopic and
+// requires auto commit to be disabled, which is the default.
+//
+// Partitions are handled in parallel up to the configured concurrency, the
+// messages of a partition one at a time and in order. A message is committed
+// once its handler succeeds. A failed message is retried after the retry
+// backoff, holding back its partition, except for validation errors
+// (errorx.IsValidation), which retrying cannot fix: they are reported and
+// skipped. WithRetryPolicy forwards failed messages to retry topics and a
+// DLQ instead. A partition whose queue is full is paused until it drains.
+//
+// When partitions are revoked, their running handlers finish and their
+// offsets are committed before the rebalance completes. When ctx is done,
+// Consume stops fetching, waits up to the shutdown timeout for the running
+// handlers, cancels their context if needed, commits and returns nil.
+// Handler contexts are not canceled by ctx, so in-flight work can finish.
+func (s *kafkaSubscriber) Consume(ctx context.Context, handler Handler, opts ...ConsumeOption) error {
+	if s.metrics != nil {
+		opts = append([]ConsumeOption{WithConsumeMetrics(s.metrics)}, opts...)
+	}
+	return consume(ctx, s.consumer, []string{s.topic}, s.newMessage, handler, opts...)
+}
+
+func consume(ctx context.Context, client consumerClient, topics []string, newMessage func(*kafka.Message) Message, handler Handler, opts ...ConsumeOption) error {
+	config := newConsumeConfig(opts)
+	if config.Retry != nil {
+		for _, topic := range topics {
+			topics = append(topics, config.Retry.Topics(topic)...)
+		}
+		handler = config.Retry.retryHandler(handler, config.RetryProducer)
+	}
+
+	rt := newConsumeRuntime(ctx, client, config, newMessage)
+	defer rt.cancel()
+	rt.handler = handler
+	return rt.start(ctx, topics)
+}
+
+// newConsumeConfig returns the default settings with opts applied.
+func newConsumeConfig(opts []ConsumeOption) ConsumeConfig {
+	config := DefaultConsumeConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	if config.QueueSize < 1 {
+		config.QueueSize = 1
+	}
+	if config.BatchSize < 1 {
+		config.BatchSize = 1
+	}
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			log.Printf("Consumer error: %s", err)
+		}
+	}
+	return config
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+type consumeRuntime struct {
+	client       consumerClient
+	config       ConsumeConfig
+	handler      Handler
+	batchHandler BatchHandler // Set instead of handler by ConsumeBatch
+	newMessage   func(*kafka.Message) Message
+	base         context.Context // Parent of the handler contexts
+	cancel       context.CancelFunc
+	sem          chan struct{}
+	stop         chan struct{} // Closed on shutdown
+	statsClient  string        // librdkafka client whose lag the metrics hold
+
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionWorker
+	workers    sync.WaitGroup
+}
+
+func newConsumeRuntime(ctx context.Context, client consumerClient, config ConsumeConfig, newMessage func(*kafka.Message) Message) *consumeRuntime {
+	base, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	return &consumeRuntime{
+		client:     client,
+		config:     config,
+		newMessage: newMessage,
+		base:       base,
+		cancel:     cancel,
+		sem:        make(chan struct{}, config.Concurrency),
+		stop:       make(chan struct{}),
+		partitions: make(map[partitionKey]*partitionWorker),
+	}
+}
+
+// start subscribes to topics and runs until ctx is done.
+func (rt *consumeRuntime) start(ctx context.Context, topics []string) error {
+	if err := rt.client.SubscribeTopics(topics, rt.rebalance); err != nil {
+		return classify(fmt.Errorf("failed to subscribe to topics: %w", err))
+	}
+	return rt.run(ctx)
+}
+
+func (rt *consumeRuntime) run(ctx context.Context) error {
+	defer func() {
+		if rt.statsClient != "" {
+			rt.config.Metrics.ForgetClient(rt.statsClient)
+		}
+	}()
+	lastCommit := time.Now()
+	for {
+		if ctx.Err() != nil {
+			rt.shutdown()
+			return nil
+		}
+
+		switch e := rt.client.Poll(100).(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				rt.config.OnError(classify(fmt.Errorf("consumer read error: %w", e.TopicPartition.Error)))
+				continue
+			}
+			rt.dispatch(e)
+		case *kafka.Stats:
+			if rt.config.Metrics != nil {
+				client, err := rt.config.Metrics.observeStats(e.String())
+				if err != nil {
+					rt.config.OnError(err)
+				}
+				if client != "" {
+					rt.statsClient = client
+				}
+			}
+		case kafka.Error:
+			err := classify(fmt.Errorf("consumer error: %w", e))
+			if e.IsFatal() {
+				rt.shutdown()
+				return err
+			}
+			rt.config.OnError(err)
+		}
+
+		rt.resumeDrained()
+		if time.Since(lastCommit) >= rt.config.CommitInterval {
+			lastCommit = time.Now()
+			rt.commit(rt.workerList())
+		}
+	}
+}
+
+// dispatch queues msg on the worker of its partition, starting it if needed.
+func (rt *consumeRuntime) dispatch(msg *kafka.Message) {
+	key := partitionKey{partition: msg.TopicPartition.Partition}
+	if msg.TopicPartition.Topic != nil {
+		key.topic = *msg.TopicPartition.Topic
+	}
+
+	rt.mu.Lock()
+	w, ok := rt.partitions[key]
+	if !ok {
+		w = newPartitionWorker(rt, key)
+		rt.partitions[key] = w
+		rt.workers.Add(1)
+		go w.run()
+	}
+	rt.mu.Unlock()
+
+	if rt.config.Metrics != nil {
+		rt.config.Metrics.ObserveConsumed(key.topic)
+	}
+	if w.push(rt.newMessage(msg)) {
+		if err := rt.client.Pause([]kafka.TopicPartition{w.topicPartition(kafka.OffsetInvalid)}); err != nil {
+			rt.config.OnError(classify(fmt.Errorf("failed to pause partition: %w", err)))
+		}
+	}
+}
+
+// resumeDrained resumes the paused partitions whose queue is half empty.
+func (rt *consumeRuntime) resumeDrained() {
+	for _, w := range rt.workerList() {
+		if w.drained() {
+			if err := rt.client.Resume([]kafka.TopicPartition{w.topicPartition(kafka.OffsetInvalid)}); err != nil {
+				rt.config.OnError(classify(fmt.Errorf("failed to resume partition: %w", err)))
+			}
+		}
+	}
+}
+
+func (rt *consumeRuntime) rebalance(_ *kafka.Consumer, e kafka.Event) error {
+	switch e := e.(type) {
+	case kafka.AssignedPartitions:
+		if rt.config.OnAssigned != nil {
+			rt.config.OnAssigned(e.Partitions)
+		}
+	case kafka.RevokedPartitions:
+		var revoked []*partitionWorker
+		rt.mu.Lock()
+		for _, tp := range e.Partitions {
+			key := partitionKey{partition: tp.Partition}
+			if tp.Topic != nil {
+				key.topic = *tp.Topic
+			}
+			if w, ok := rt.partitions[key]; ok {
+				delete(rt.partitions, key)
+				revoked = append(revoked, w)
+			}
+		}
+		rt.mu.Unlock()
+
+		for _, w := range revoked {
+			close(w.revoked)
+		}
+		for _, w := range revoked {
+			<-w.done
+		}
+		rt.commit(revoked)
+		if rt.config.OnRevoked != nil {
+			rt.config.OnRevoked(e.Partitions)
+		}
+	}
+	return nil
+}
+
+// shutdown stops the workers, waits for the running handlers and commits.
+func (rt *consumeRuntime) shutdown() {
+	close(rt.stop)
+	done := make(chan struct{})
+	go func() {
+		rt.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(rt.config.ShutdownTimeout):
+		rt.config.OnError(fmt.Errorf("handlers still running after %s, canceling them", rt.config.ShutdownTimeout))
+		rt.cancel()
+		<-done
+	}
+	rt.commit(rt.workerList())
+}
+
+// commit commits the offsets handled by workers since their last commit.
+func (rt *consumeRuntime) commit(workers []*partitionWorker) {
+	var (
+		offsets []kafka.TopicPartition
+		pending []*partitionWorker
+	)
+	for _, w := range workers {
+		if offset, ok := w.uncommitted(); ok {
+			offsets = append(offsets, w.topicPartition(kafka.Offset(offset)))
+			pending = append(pending, w)
+		}
+	}
+	if len(offsets) == 0 {
+		return
+	}
+	if _, err := rt.client.CommitOffsets(offsets); err != nil {
+		rt.config.OnError(classify(fmt.Errorf("offset commit error: %w", err)))
+		if rt.config.Metrics != nil {
+			rt.config.Metrics.ObserveCommitFailure()
+		}
+		return
+	}
+	for i, w := range pending {
+		w.markCommitted(int64(offsets[i].Offset))
+	}
+}
+
+func (rt *consumeRuntime) workerList() []*partitionWorker {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	workers := make([]*partitionWorker, 0, len(rt.partitions))
+	for _, w := range rt.partitions {
+		workers = append(workers, w)
+	}
+	return workers
+}
+
+// handle runs the handler, turning a panic into an error.
+func (rt *consumeRuntime) handle(msg Message) (err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = errorx.Tag(fmt.Errorf("handler panic: %v", r), errorx.ClassInternal)
+		}
+		if rt.config.Metrics != nil {
+			rt.config.Metrics.ObserveHandled(msg.Topic, time.Since(start), err)
+		}
+	}()
+	return rt.handler(ContextFromHeaders(rt.base, msg.Headers), msg)
+}
+
+// partitionWorker handles the messages of one partition in order.
+type partitionWorker struct {
+	rt  *consumeRuntime
+	key partitionKey
+
+	mu        sync.Mutex
+	queue     []Message
+	paused    bool
+	handled   int64 // Offset following the last handled message, -1 if none
+	committed int64
+
+	wake    chan struct{}
+	revoked chan struct{} // Closed when the partition is revoked
+	done    chan struct{} // Closed when the worker exits
+}
+
+func newPartitionWorker(rt *consumeRuntime, key partitionKey) *partitionWorker {
+	return &partitionWorker{
+		rt:        rt,
+		key:       key,
+		handled:   -1,
+		committed: -1,
+		wake:      make(chan struct{}, 1),
+		revoked:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (w *partitionWorker) topicPartition(offset kafka.Offset) kafka.TopicPartition {
+	topic := w.key.topic
+	return kafka.TopicPartition{Topic: &topic, Partition: w.key.partition, Offset: offset}
+}
+
+// push queues msg and reports whether the partition must be paused.
+func (w *partitionWorker) push(msg Message) bool {
+	w.mu.Lock()
+	w.queue = append(w.queue, msg)
+	pause := !w.paused && len(w.queue) >= w.rt.config.QueueSize
+	if pause {
+		w.paused = true
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return pause
+}
+
+// drained reports whether the partition is paused and its queue is half
+// empty, marking it resumed.
+func (w *partitionWorker) drained() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused && len(w.queue) <= w.rt.config.QueueSize/2 {
+		w.paused = false
+		return true
+	}
+	return false
+}
+
+func (w *partitionWorker) uncommitted() (int64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.handled, w.handled > w.committed
+}
+
+func (w *partitionWorker) markCommitted(offset int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if offset > w.committed {
+		w.committed = offset
+	}
+}
+
+func (w *partitionWorker) run() {
+	defer w.rt.workers.Done()
+	defer close(w.done)
+	for {
+		if w.rt.batchHandler != nil {
+			msgs, ok := w.nextBatch()
+			if !ok || !w.processBatch(msgs) {
+				return
+			}
+			continue
+		}
+		msg, ok := w.next()
+		if !ok || !w.process(msg) {
+			return
+		}
+	}
+}
+
+// next waits for the next queued message. It returns false once the worker
+// must stop; the messages still queued are left uncommitted.
+func (w *partitionWorker) next() (Message, bool) {
+	for {
+		if w.stopped() {
+			return Message{}, false
+		}
+		w.mu.Lock()
+		if len(w.queue) > 0 {
+			msg := w.queue[0]
+			w.queue[0] = Message{}
+			w.queue = w.queue[1:]
+			w.mu.Unlock()
+			return msg, true
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.wake:
+		case <-w.rt.stop:
+		case <-w.revoked:
+		}
+	}
+}
+
+// process handles msg until it succeeds or is skipped. It returns false if
+// the worker stopped while waiting to handle it. Messages forwarded to a
+// retry topic are held until their delay elapsed.
+func (w *partitionWorker) process(msg Message) bool {
+	if !w.sleep(time.Until(notBefore(msg))) {
+		return false
+	}
+	for {
+		if !w.acquire() {
+			return false
+		}
+		err := w.rt.handle(msg)
+		<-w.rt.sem
+
+		if err == nil {
+			w.markHandled(msg.Offset)
+			return true
+		}
+		err = fmt.Errorf("failed to handle message %s[%d]@%d: %w", msg.Topic, msg.Partition, msg.Offset, err)
+		w.rt.config.OnError(err)
+		if errorx.IsValidation(err) {
+			// Retrying cannot fix the message; skip it.
+			w.markHandled(msg.Offset)
+			return true
+		}
+		if !w.sleep(w.rt.config.RetryBackoff) {
+			return false
+		}
+	}
+}
+
+// acquire waits for a handler slot, which the caller must release. It
+// returns false if the worker stopped meanwhile.
+func (w *partitionWorker) acquire() bool {
+	select {
+	case w.rt.sem <- struct{}{}:
+	case <-w.rt.stop:
+		return false
+	case <-w.revoked:
+		return false
+	}
+	if w.stopped() {
+		<-w.rt.sem
+		return false
+	}
+	return true
+}
+
+// sleep waits for d and returns false if the worker stopped meanwhile.
+func (w *partitionWorker) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-w.rt.stop:
+		return false
+	case <-w.revoked:
+		return false
+	}
+}
+
+func (w *partitionWorker) markHandled(offset int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handled = offset + 1
+}
+
+func (w *partitionWorker) stopped() bool {
+	select {
+	case <-w.rt.stop:
+		return true
+	case <-w.revoked:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,293 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/solum-sp/aps-be-common/common/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConsumer serves the events sent on its channel from Poll and records
+// commits and pauses.
+type fakeConsumer struct {
+	events chan kafka.Event
+
+	mu          sync.Mutex
+	rebalanceCb kafka.RebalanceCb
+	topics      []string
+	commits     map[int32]kafka.Offset
+	paused      map[int32]bool
+}
+
+func newFakeConsumer() *fakeConsumer {
+	return &fakeConsumer{
+		events:  make(chan kafka.Event, 100),
+		commits: make(map[int32]kafka.Offset),
+		paused:  make(map[int32]bool),
+	}
+}
+
+func (c *fakeConsumer) SubscribeTopics(topics []string, cb kafka.RebalanceCb) error {
+	c.topics = topics
+	c.rebalanceCb = cb
+	return nil
+}
+
+func (c *fakeConsumer) Poll(timeoutMs int) kafka.Event {
+	select {
+	case e := <-c.events:
+		switch e.(type) {
+		case kafka.AssignedPartitions, kafka.RevokedPartitions:
+			_ = c.rebalanceCb(nil, e)
+			return nil
+		}
+		return e
+	case <-time.After(time.Duration(timeoutMs) * time.Millisecond):
+		return nil
+	}
+}
+
+func (c *fakeConsumer) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tp := range offsets {
+		c.commits[tp.Partition] = tp.Offset
+	}
+	return offsets, nil
+}
+
+func (c *fakeConsumer) Pause(partitions []kafka.TopicPartition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tp := range partitions {
+		c.paused[tp.Partition] = true
+	}
+	return nil
+}
+
+func (c *fakeConsumer) Resume(partitions []kafka.TopicPartition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tp := range partitions {
+		c.paused[tp.Partition] = false
+	}
+	return nil
+}
+
+func (c *fakeConsumer) committed(partition int32) kafka.Offset {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.commits[partition]
+}
+
+func testMessage(partition int32, offset int64) *kafka.Message {
+	topic := "orders"
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)},
+		Value:          []byte("value"),
+	}
+}
+
+func testNewMessage(msg *kafka.Message) Message {
+	return Message{
+		Topic:     *msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Value:     msg.Value,
+		Headers:   fromKafkaHeaders(msg.Headers),
+		raw:       msg,
+	}
+}
+
+// runConsume runs consume in the background and returns a function stopping
+// it and returning its error.
+func runConsume(t *testing.T, c *fakeConsumer, handler Handler, opts ...ConsumeOption) func() error {
+	opts = append([]ConsumeOption{WithConsumeCommitInterval(10 * time.Millisecond), WithConsumeErrorHandler(func(error) {})}, opts...)
+	return runInBackground(t, func(ctx context.Context) error {
+		return consume(ctx, c, []string{"orders"}, testNewMessage, handler, opts...)
+	})
+}
+
+// runInBackground calls run in the background and returns a function
+// canceling its context and returning its error.
+func runInBackground(t *testing.T, run func(ctx context.Context) error) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- run(ctx)
+	}()
+	return func() error {
+		cancel()
+		select {
+		case err := <-errCh:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("consume did not return")
+			return nil
+		}
+	}
+}
+
+func TestConsumeCommitsInPartitionOrder(t *testing.T) {
+	c := newFakeConsumer()
+	var (
+		mu      sync.Mutex
+		offsets = make(map[int32][]int64)
+		wg      sync.WaitGroup
+	)
+	wg.Add(6)
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		defer wg.Done()
+		mu.Lock()
+		offsets[msg.Partition] = append(offsets[msg.Partition], msg.Offset)
+		mu.Unlock()
+		return nil
+	}, WithConsumeConcurrency(2))
+
+	for i := int64(0); i < 3; i++ {
+		c.events <- testMessage(0, i)
+		c.events <- testMessage(1, 10+i)
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		return c.committed(0) == 3 && c.committed(1) == 13
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+	assert.Equal(t, []int64{0, 1, 2}, offsets[0])
+	assert.Equal(t, []int64{10, 11, 12}, offsets[1])
+}
+
+func TestConsumeRetriesFailedMessages(t *testing.T) {
+	c := newFakeConsumer()
+	var (
+		mu       sync.Mutex
+		attempts = make(map[int64]int)
+	)
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[msg.Offset]++
+		switch {
+		case msg.Offset == 0 && attempts[0] < 3:
+			return errors.New("temporary failure")
+		case msg.Offset == 1:
+			return errorx.Tag(errors.New("bad payload"), errorx.ClassValidation)
+		}
+		return nil
+	}, WithConsumeRetryBackoff(time.Millisecond))
+
+	c.events <- testMessage(0, 0)
+	c.events <- testMessage(0, 1)
+	c.events <- testMessage(0, 2)
+
+	require.Eventually(t, func() bool { return c.committed(0) == 3 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+	assert.Equal(t, map[int64]int{0: 3, 1: 1, 2: 1}, attempts)
+}
+
+func TestConsumeCommitsBeforeRevoke(t *testing.T) {
+	c := newFakeConsumer()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var revokedCommit kafka.Offset
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		close(started)
+		<-release
+		return nil
+	}, WithConsumeCommitInterval(time.Hour), WithRebalanceCallbacks(nil, func([]kafka.TopicPartition) {
+		revokedCommit = c.committed(0)
+	}))
+
+	c.events <- testMessage(0, 5)
+	<-started
+	topic := "orders"
+	c.events <- kafka.RevokedPartitions{Partitions: []kafka.TopicPartition{{Topic: &topic, Partition: 0}}}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	require.Eventually(t, func() bool { return c.committed(0) == 6 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+	assert.Equal(t, kafka.Offset(6), revokedCommit)
+}
+
+func TestConsumeDrainsOnShutdown(t *testing.T) {
+	c := newFakeConsumer()
+	started := make(chan struct{})
+	var handlerErr error
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		handlerErr = ctx.Err()
+		return nil
+	})
+
+	c.events <- testMessage(0, 0)
+	<-started
+	require.NoError(t, stop())
+	assert.NoError(t, handlerErr)
+	assert.Equal(t, kafka.Offset(1), c.committed(0))
+}
+
+func TestConsumeCancelsHandlersAfterShutdownTimeout(t *testing.T) {
+	c := newFakeConsumer()
+	started := make(chan struct{})
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithConsumeShutdownTimeout(20*time.Millisecond))
+
+	c.events <- testMessage(0, 0)
+	<-started
+	require.NoError(t, stop())
+	assert.Equal(t, kafka.Offset(0), c.committed(0))
+}
+
+func TestConsumePausesFullPartitions(t *testing.T) {
+	c := newFakeConsumer()
+	release := make(chan struct{})
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		<-release
+		return nil
+	}, WithConsumeQueueSize(2))
+
+	for i := int64(0); i < 4; i++ {
+		c.events <- testMessage(0, i)
+	}
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.paused[0]
+	}, time.Second, 10*time.Millisecond)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return !c.paused[0] && c.commits[0] == 4
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+}
+
+func TestConsumeHandlerContextCarriesHeaders(t *testing.T) {
+	c := newFakeConsumer()
+	got := make(chan string, 1)
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		got <- utils.RequestIDFromContext(ctx)
+		return nil
+	})
+
+	msg := testMessage(0, 0)
+	msg.Headers = []kafka.Header{{Key: HeaderRequestID, Value: []byte("req-1")}}
+	c.events <- msg
+	assert.Equal(t, "req-1", <-got)
+	require.NoError(t, stop())
+}
@@ -0,0 +1,57 @@
+package event
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// DeliveryReport is the outcome of an asynchronous send.
+type DeliveryReport struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
+// DeliveryFuture completes when the message it was returned for is
+// delivered or failed.
+type DeliveryFuture struct {
+	once      sync.Once
+	done      chan struct{}
+	report    DeliveryReport
+	callbacks []func(DeliveryReport)
+}
+
+func newDeliveryFuture(callbacks []func(DeliveryReport)) *DeliveryFuture {
+	return &DeliveryFuture{done: make(chan struct{}), callbacks: slices.Clone(callbacks)}
+}
+
+// complete runs the callbacks before completing f, so that waiters observe
+// their effects.
+func (f *DeliveryFuture) complete(report DeliveryReport) {
+	f.once.Do(func() {
+		f.report = report
+		defer close(f.done)
+		for _, cb := range f.callbacks {
+			cb(report)
+		}
+	})
+}
+
+// Done is closed once the delivery completed.
+func (f *DeliveryFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the delivery completed or ctx is done. The error is the
+// delivery error, or ctx.Err() if ctx ended first; the message may still be
+// delivered in that case.
+func (f *DeliveryFuture) Wait(ctx context.Context) (DeliveryReport, error) {
+	select {
+	case <-f.done:
+		return f.report, f.report.Err
+	case <-ctx.Done():
+		return DeliveryReport{}, ctx.Err()
+	}
+}
@@ -0,0 +1,40 @@
+package event
+
+import (
+	"errors"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+// classify tags err with the errorx classes of the kafka.Error it wraps, so
+// callers can decide with errorx.IsRetryable whether to try again.
+func classify(err error) error {
+	var kerr kafka.Error
+	if !errors.As(err, &kerr) {
+		return err
+	}
+	var class errorx.Class
+	if kerr.IsTimeout() || kerr.Code() == kafka.ErrTimedOut || kerr.Code() == kafka.ErrMsgTimedOut {
+		class |= errorx.ClassTimeout | errorx.ClassRetryable
+	}
+	if kerr.IsRetriable() {
+		class |= errorx.ClassRetryable
+	}
+	switch kerr.Code() {
+	case kafka.ErrTransport, kafka.ErrAllBrokersDown, kafka.ErrQueueFull, kafka.ErrNotEnoughReplicas,
+		kafka.ErrNotLeaderForPartition, kafka.ErrLeaderNotAvailable:
+		class |= errorx.ClassRetryable
+	case kafka.ErrUnknownTopicOrPart, kafka.ErrUnknownTopic, kafka.ErrUnknownPartition:
+		class |= errorx.ClassNotFound
+	case kafka.ErrMsgSizeTooLarge, kafka.ErrInvalidArg:
+		class |= errorx.ClassValidation
+	}
+	if kerr.IsFatal() {
+		class = errorx.ClassInternal
+	}
+	if class == 0 {
+		return err
+	}
+	return errorx.Tag(err, class)
+}
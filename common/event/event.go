@@ -6,10 +6,51 @@ type IPublisher interface {
 	SendMessage(ctx context.Context, value interface{}) error
 }
 
+// IKeyedPublisher is a publisher of messages carrying a key, headers, a
+// timestamp or a partition.
+type IKeyedPublisher interface {
+	IPublisher
+	Publish(ctx context.Context, msg ProducerMessage) error
+}
+
+// IAsyncPublisher is a publisher that can send without waiting for the
+// broker acknowledgement.
+type IAsyncPublisher interface {
+	IKeyedPublisher
+	SendMessageAsync(ctx context.Context, value interface{}, callbacks ...func(DeliveryReport)) *DeliveryFuture
+	PublishAsync(ctx context.Context, msg ProducerMessage, callbacks ...func(DeliveryReport)) *DeliveryFuture
+	Flush(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
 type ISubscriber interface {
 	SubscribeToTopic(ctx context.Context) error
 	ConsumeMessages(ctx context.Context, msgTypeConf func() ConsumerMessage) (chMsg <-chan ConsumerMessage, chErr <-chan error, chCommitRequest chan<- bool)
 }
+
+// IConsumer is a subscriber reading messages with their headers and
+// committing them explicitly, or handing them to a handler with Consume.
+type IConsumer interface {
+	ISubscriber
+	ReadMessage(ctx context.Context) (context.Context, Message, error)
+	CommitMessage(msg Message) error
+	Consume(ctx context.Context, handler Handler, opts ...ConsumeOption) error
+}
+
+// IBatchConsumer is a consumer that can also hand messages to a handler in
+// batches.
+type IBatchConsumer interface {
+	IConsumer
+	ConsumeBatch(ctx context.Context, handler BatchHandler, opts ...ConsumeOption) error
+}
+
 type ConsumerMessage interface {
 	EventName() string
 }
+
+// ContextSetter is implemented by the ConsumerMessage values that want the
+// context of their message, carrying its trace context, request ID and
+// tenant ID, when received from ConsumeMessages.
+type ContextSetter interface {
+	SetContext(ctx context.Context)
+}
@@ -0,0 +1,198 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+Idempotent consumers: a message redelivered after a rebalance or a retry is
+recognized by its ID and skipped once it was handled successfully.
+
+USAGE EXAMPLE:
+
+	store := event.NewCacheIdempotencyStore(redisCache) // or NewMemoryIdempotencyStore, NewSQLIdempotencyStore
+	err := subscriber.Consume(ctx, event.Idempotent(store, handler))
+
+	// With the SQL store, record the message in the handler's transaction
+	func handler(ctx context.Context, msg event.Message) error {
+		tx, _ := db.BeginTx(ctx, nil)
+		// ... side effects with tx
+		if err := event.CompleteInTx(ctx, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+*/
+
+// HeaderMessageID is the header carrying the ID of a message, set by
+// publishers that want duplicates recognized across republishing.
+const HeaderMessageID = "x-message-id"
+
+// ClaimResult is the outcome of IdempotencyStore.Claim.
+type ClaimResult int
+
+const (
+	ClaimAcquired  ClaimResult = iota // The caller may process the message
+	ClaimDuplicate                    // The message was already processed
+	ClaimBusy                         // The message is being processed elsewhere
+)
+
+// IdempotencyStore records the IDs of processed messages. A claim is owned
+// by the token passed to Claim: Complete and Release only act on the claim
+// of their token, so a consumer whose lease expired cannot complete or drop
+// the claim another consumer took over.
+type IdempotencyStore interface {
+	// Claim reserves id for processing under token. The claim expires after
+	// lease if it is neither completed nor released, e.g. because the
+	// consumer crashed.
+	Claim(ctx context.Context, id, token string, lease time.Duration) (ClaimResult, error)
+	// Complete records id as processed for ttl. It fails with a retryable
+	// conflict if the claim of token expired and id was claimed again.
+	Complete(ctx context.Context, id, token string, ttl time.Duration) error
+	// Release drops the claim of id held by token so it can be processed
+	// again. Completed IDs and the claims of other tokens are kept.
+	Release(ctx context.Context, id, token string) error
+}
+
+// TxIdempotencyStore is an IdempotencyStore that can complete an ID within
+// the transaction of the handler's side effects, see CompleteInTx.
+type TxIdempotencyStore interface {
+	IdempotencyStore
+	CompleteTx(ctx context.Context, tx DBTX, id, token string, ttl time.Duration) error
+}
+
+// errClaimTaken reports that the claim of id expired and another consumer
+// claimed it.
+func errClaimTaken(id string) error {
+	return errorx.Tag(fmt.Errorf("claim of message %s expired and was taken by another consumer", id), errorx.ClassConflict|errorx.ClassRetryable)
+}
+
+// IdempotencyConfig holds the Idempotent settings
+type IdempotencyConfig struct {
+	TTL       time.Duration // How long processed IDs are remembered
+	Lease     time.Duration // How long a claim lasts; longer than the slowest handler
+	MessageID func(msg Message) string
+}
+
+// DefaultIdempotencyConfig holds the default Idempotent settings
+var DefaultIdempotencyConfig = IdempotencyConfig{
+	TTL:       24 * time.Hour,
+	Lease:     5 * time.Minute,
+	MessageID: MessageID,
+}
+
+// IdempotencyOption is a functional option for configuring Idempotent
+type IdempotencyOption func(*IdempotencyConfig)
+
+// WithIdempotencyTTL sets how long processed message IDs are remembered
+func WithIdempotencyTTL(d time.Duration) IdempotencyOption {
+	return func(c *IdempotencyConfig) {
+		c.TTL = d
+	}
+}
+
+// WithIdempotencyLease sets how long a message is reserved for its handler
+func WithIdempotencyLease(d time.Duration) IdempotencyOption {
+	return func(c *IdempotencyConfig) {
+		c.Lease = d
+	}
+}
+
+// WithMessageID sets the function deriving the ID of a message
+func WithMessageID(f func(msg Message) string) IdempotencyOption {
+	return func(c *IdempotencyConfig) {
+		c.MessageID = f
+	}
+}
+
+// MessageID returns the HeaderMessageID header of msg, or else its topic,
+// partition, offset and key. A message forwarded to a retry topic keeps the
+// ID of the original.
+func MessageID(msg Message) string {
+	if id := msg.Headers[HeaderMessageID]; id != "" {
+		return id
+	}
+	topic, partition, offset := msg.Topic, strconv.Itoa(int(msg.Partition)), strconv.FormatInt(msg.Offset, 10)
+	if original := msg.Headers[HeaderOriginalTopic]; original != "" {
+		topic, partition, offset = original, msg.Headers[HeaderOriginalPartition], msg.Headers[HeaderOriginalOffset]
+	}
+	return fmt.Sprintf("%s/%s/%s/%x", topic, partition, offset, msg.Key)
+}
+
+type idempotencyScopeKey struct{}
+
+type idempotencyScope struct {
+	store IdempotencyStore
+	id    string
+	token string
+	ttl   time.Duration
+	done  bool
+}
+
+// Idempotent wraps next so that each message is handled successfully at most
+// once per TTL. A message already processed is skipped; one being processed
+// by another consumer fails with a retryable conflict. The message is
+// recorded as processed when next succeeds, or by next itself with
+// CompleteInTx.
+func Idempotent(store IdempotencyStore, next Handler, opts ...IdempotencyOption) Handler {
+	config := DefaultIdempotencyConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return func(ctx context.Context, msg Message) error {
+		id := config.MessageID(msg)
+		token := uuid.NewString()
+		result, err := store.Claim(ctx, id, token, config.Lease)
+		if err != nil {
+			return fmt.Errorf("failed to claim message %s: %w", id, err)
+		}
+		switch result {
+		case ClaimDuplicate:
+			return nil
+		case ClaimBusy:
+			return errorx.Tag(fmt.Errorf("message %s is being processed by another consumer", id), errorx.ClassConflict|errorx.ClassRetryable)
+		}
+
+		scope := &idempotencyScope{store: store, id: id, token: token, ttl: config.TTL}
+		if err := next(context.WithValue(ctx, idempotencyScopeKey{}, scope), msg); err != nil {
+			if rerr := store.Release(context.WithoutCancel(ctx), id, token); rerr != nil {
+				return errors.Join(err, fmt.Errorf("failed to release message %s: %w", id, rerr))
+			}
+			return err
+		}
+		if scope.done {
+			return nil
+		}
+		if err := store.Complete(ctx, id, token, config.TTL); err != nil {
+			return fmt.Errorf("failed to complete message %s: %w", id, err)
+		}
+		return nil
+	}
+}
+
+// CompleteInTx records the message handled with ctx as processed within tx,
+// so the record commits or rolls back with the handler's side effects. ctx
+// must come from a handler wrapped by Idempotent with a TxIdempotencyStore.
+func CompleteInTx(ctx context.Context, tx DBTX) error {
+	scope, ok := ctx.Value(idempotencyScopeKey{}).(*idempotencyScope)
+	if !ok {
+		return errors.New("context does not come from an idempotent handler")
+	}
+	store, ok := scope.store.(TxIdempotencyStore)
+	if !ok {
+		return fmt.Errorf("idempotency store %T does not support transactions", scope.store)
+	}
+	if err := store.CompleteTx(ctx, tx, scope.id, scope.token, scope.ttl); err != nil {
+		return fmt.Errorf("failed to complete message %s: %w", scope.id, err)
+	}
+	scope.done = true
+	return nil
+}
@@ -0,0 +1,95 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+// IdempotencyCache is the part of the cache package's Redis cache used by
+// CacheIdempotencyStore.
+type IdempotencyCache interface {
+	SetNX(key string, value interface{}, expireTime time.Duration) (bool, error)
+	Get(key string) (interface{}, error)
+	CompareAndSet(key string, old, value interface{}, expireTime time.Duration) (bool, error)
+	CompareAndDelete(key string, value interface{}) (bool, error)
+}
+
+const (
+	idempotencyProcessing = "processing"
+	idempotencyDone       = "done"
+)
+
+// CacheIdempotencyStore is an IdempotencyStore backed by a cache, typically
+// the Redis cache of the cache package, so that all the consumers of a group
+// share it. Keys are the message IDs prefixed with "idempotency:"; a claim
+// holds "processing:<token>" and is completed or released atomically only
+// while it still does.
+type CacheIdempotencyStore struct {
+	cache IdempotencyCache
+}
+
+var _ IdempotencyStore = (*CacheIdempotencyStore)(nil)
+
+func NewCacheIdempotencyStore(cache IdempotencyCache) *CacheIdempotencyStore {
+	return &CacheIdempotencyStore{cache: cache}
+}
+
+func (s *CacheIdempotencyStore) Claim(_ context.Context, id, token string, lease time.Duration) (ClaimResult, error) {
+	key := s.key(id)
+	ok, err := s.cache.SetNX(key, claimValue(token), lease)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return ClaimAcquired, nil
+	}
+	val, err := s.cache.Get(key)
+	if errorx.IsNotFound(err) {
+		// Expired in between; let the caller try again.
+		return ClaimBusy, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if fmt.Sprint(val) == idempotencyDone {
+		return ClaimDuplicate, nil
+	}
+	return ClaimBusy, nil
+}
+
+func (s *CacheIdempotencyStore) Complete(_ context.Context, id, token string, ttl time.Duration) error {
+	key := s.key(id)
+	ok, err := s.cache.CompareAndSet(key, claimValue(token), idempotencyDone, ttl)
+	if err != nil || ok {
+		return err
+	}
+	// The claim expired; record the ID unless it was claimed again meanwhile.
+	ok, err = s.cache.SetNX(key, idempotencyDone, ttl)
+	if err != nil || ok {
+		return err
+	}
+	val, err := s.cache.Get(key)
+	if err == nil && fmt.Sprint(val) == idempotencyDone {
+		return nil
+	}
+	if err != nil && !errorx.IsNotFound(err) {
+		return err
+	}
+	return errClaimTaken(id)
+}
+
+func (s *CacheIdempotencyStore) Release(_ context.Context, id, token string) error {
+	_, err := s.cache.CompareAndDelete(s.key(id), claimValue(token))
+	return err
+}
+
+func claimValue(token string) string {
+	return idempotencyProcessing + ":" + token
+}
+
+func (s *CacheIdempotencyStore) key(id string) string {
+	return "idempotency:" + id
+}
@@ -0,0 +1,81 @@
+package event
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryIdempotencyStore is an IdempotencyStore keeping IDs in memory, for
+// tests and single-instance consumers.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryIdempotencyEntry
+	now     func() time.Time
+}
+
+type memoryIdempotencyEntry struct {
+	done      bool
+	token     string
+	expiresAt time.Time
+}
+
+var _ IdempotencyStore = (*MemoryIdempotencyStore)(nil)
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries: make(map[string]memoryIdempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryIdempotencyStore) Claim(_ context.Context, id, token string, lease time.Duration) (ClaimResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if e, ok := s.entries[id]; ok && now.Before(e.expiresAt) {
+		if e.done {
+			return ClaimDuplicate, nil
+		}
+		return ClaimBusy, nil
+	}
+	s.entries[id] = memoryIdempotencyEntry{token: token, expiresAt: now.Add(lease)}
+	return ClaimAcquired, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(_ context.Context, id, token string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if e, ok := s.entries[id]; ok && now.Before(e.expiresAt) {
+		if e.done {
+			return nil
+		}
+		if e.token != token {
+			return errClaimTaken(id)
+		}
+	}
+	s.entries[id] = memoryIdempotencyEntry{done: true, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, id, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[id]; ok && !e.done && e.token == token {
+		delete(s.entries, id)
+	}
+	return nil
+}
+
+// Purge removes the expired IDs.
+func (s *MemoryIdempotencyStore) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for id, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, id)
+		}
+	}
+}
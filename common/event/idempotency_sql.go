@@ -0,0 +1,169 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SQLIdempotencyStore is an IdempotencyStore backed by a database/sql table.
+// It supports CompleteInTx, recording a message in the transaction of its
+// side effects. The table must exist; on PostgreSQL it is created with:
+//
+//	CREATE TABLE event_idempotency (
+//		id         TEXT PRIMARY KEY,
+//		status     TEXT NOT NULL,
+//		token      TEXT NOT NULL DEFAULT '',
+//		expires_at TIMESTAMPTZ NOT NULL
+//	);
+//	CREATE INDEX event_idempotency_expires ON event_idempotency (expires_at);
+type SQLIdempotencyStore struct {
+	db          *sql.DB
+	table       string
+	placeholder func(n int) string
+}
+
+var _ TxIdempotencyStore = (*SQLIdempotencyStore)(nil)
+
+// SQLIdempotencyOption is a functional option for configuring a SQLIdempotencyStore
+type SQLIdempotencyOption func(*SQLIdempotencyStore)
+
+// WithIdempotencyTable sets the name of the table, "event_idempotency" by default
+func WithIdempotencyTable(table string) SQLIdempotencyOption {
+	return func(s *SQLIdempotencyStore) {
+		s.table = table
+	}
+}
+
+// WithIdempotencyQuestionPlaceholders uses "?" placeholders (MySQL, SQLite)
+// instead of the PostgreSQL "$1" style
+func WithIdempotencyQuestionPlaceholders() SQLIdempotencyOption {
+	return func(s *SQLIdempotencyStore) {
+		s.placeholder = func(int) string { return "?" }
+	}
+}
+
+func NewSQLIdempotencyStore(db *sql.DB, opts ...SQLIdempotencyOption) *SQLIdempotencyStore {
+	s := &SQLIdempotencyStore{
+		db:          db,
+		table:       "event_idempotency",
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Claim inserts id as processing by token. An existing row decides the
+// result: a completed ID is a duplicate, a claimed one is busy. Expired rows
+// are replaced.
+func (s *SQLIdempotencyStore) Claim(ctx context.Context, id, token string, lease time.Duration) (ClaimResult, error) {
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE id = %s AND expires_at < %s", s.table, s.placeholder(1), s.placeholder(2)),
+		id, now)
+	if err != nil {
+		return 0, err
+	}
+	_, insertErr := s.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (id, status, token, expires_at) VALUES (%s, %s, %s, %s)",
+			s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4)),
+		id, idempotencyProcessing, token, now.Add(lease))
+	if insertErr == nil {
+		return ClaimAcquired, nil
+	}
+
+	// The insert failed, most likely on the primary key.
+	var status string
+	err = s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT status FROM %s WHERE id = %s", s.table, s.placeholder(1)), id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, insertErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status == idempotencyDone {
+		return ClaimDuplicate, nil
+	}
+	return ClaimBusy, nil
+}
+
+func (s *SQLIdempotencyStore) Complete(ctx context.Context, id, token string, ttl time.Duration) error {
+	return s.CompleteTx(ctx, s.db, id, token, ttl)
+}
+
+// CompleteTx records id as processed with tx if token still holds its claim.
+// Otherwise the row of id is inspected within tx, which must also implement
+// QueryRowContext like *sql.Tx and *sql.DB: an expired row is replaced, a
+// completed ID is left as is and a live claim of another consumer is
+// reported as a conflict.
+func (s *SQLIdempotencyStore) CompleteTx(ctx context.Context, tx DBTX, id, token string, ttl time.Duration) error {
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET status = %s, expires_at = %s WHERE id = %s AND status = %s AND token = %s",
+			s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4), s.placeholder(5)),
+		idempotencyDone, now.Add(ttl), id, idempotencyProcessing, token)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+
+	querier, ok := tx.(rowQuerier)
+	if !ok {
+		return fmt.Errorf("claim of message %s is no longer held and %T cannot inspect it", id, tx)
+	}
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE id = %s AND expires_at < %s", s.table, s.placeholder(1), s.placeholder(2)),
+		id, now)
+	if err != nil {
+		return err
+	}
+	var status string
+	err = querier.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT status FROM %s WHERE id = %s", s.table, s.placeholder(1)), id).Scan(&status)
+	switch {
+	case err == nil && status == idempotencyDone:
+		return nil
+	case err == nil:
+		return errClaimTaken(id)
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (id, status, token, expires_at) VALUES (%s, %s, %s, %s)",
+			s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4)),
+		id, idempotencyDone, token, now.Add(ttl))
+	return err
+}
+
+// rowQuerier is implemented by *sql.Tx and *sql.DB.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Release deletes the claim of id held by token.
+func (s *SQLIdempotencyStore) Release(ctx context.Context, id, token string) error {
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE id = %s AND status = %s AND token = %s",
+			s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3)),
+		id, idempotencyProcessing, token)
+	return err
+}
+
+// Purge deletes the expired IDs and returns their number.
+func (s *SQLIdempotencyStore) Purge(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE expires_at < %s", s.table, s.placeholder(1)),
+		time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
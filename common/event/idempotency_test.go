@@ -0,0 +1,275 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/solum-sp/aps-be-common/common/cache"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageID(t *testing.T) {
+	msg := Message{Topic: "orders", Partition: 2, Offset: 42, Key: []byte("k")}
+	assert.Equal(t, "orders/2/42/6b", MessageID(msg))
+
+	msg.Headers = map[string]string{HeaderOriginalTopic: "orders", HeaderOriginalPartition: "1", HeaderOriginalOffset: "7"}
+	msg.Topic = "orders.retry.1m"
+	assert.Equal(t, "orders/1/7/6b", MessageID(msg))
+
+	msg.Headers[HeaderMessageID] = "order-1-created"
+	assert.Equal(t, "order-1-created", MessageID(msg))
+}
+
+func TestIdempotentSkipsDuplicates(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	calls := 0
+	fail := true
+	handler := Idempotent(store, func(ctx context.Context, msg Message) error {
+		calls++
+		if fail {
+			return errors.New("failure")
+		}
+		return nil
+	})
+	msg := Message{Topic: "orders", Offset: 1}
+
+	assert.Error(t, handler(context.Background(), msg))
+	fail = false
+	assert.NoError(t, handler(context.Background(), msg), "released after a failure")
+	assert.NoError(t, handler(context.Background(), msg))
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotentBusy(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	msg := Message{Topic: "orders", Offset: 1}
+	result, err := store.Claim(context.Background(), MessageID(msg), "other", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, ClaimAcquired, result)
+
+	err = Idempotent(store, func(ctx context.Context, msg Message) error {
+		t.Fatal("handler called for a claimed message")
+		return nil
+	})(context.Background(), msg)
+	assert.True(t, errorx.IsConflict(err))
+	assert.True(t, errorx.IsRetryable(err))
+}
+
+func TestMemoryIdempotencyStoreExpiry(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, store.Complete(ctx, "id", "t1", time.Minute))
+	result, _ := store.Claim(ctx, "id", "t2", time.Minute)
+	assert.Equal(t, ClaimDuplicate, result)
+
+	now = now.Add(2 * time.Minute)
+	result, _ = store.Claim(ctx, "id", "t2", time.Minute)
+	assert.Equal(t, ClaimAcquired, result)
+
+	now = now.Add(2 * time.Minute)
+	store.Purge()
+	assert.Empty(t, store.entries)
+}
+
+func TestMemoryIdempotencyStoreOwnership(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	result, _ := store.Claim(ctx, "id", "t1", time.Minute)
+	require.Equal(t, ClaimAcquired, result)
+	now = now.Add(2 * time.Minute)
+	result, _ = store.Claim(ctx, "id", "t2", time.Minute)
+	require.Equal(t, ClaimAcquired, result, "the claim of t1 expired")
+
+	// t1 neither drops nor completes the claim of t2
+	require.NoError(t, store.Release(ctx, "id", "t1"))
+	err := store.Complete(ctx, "id", "t1", time.Hour)
+	assert.True(t, errorx.IsConflict(err))
+	result, _ = store.Claim(ctx, "id", "t3", time.Minute)
+	assert.Equal(t, ClaimBusy, result)
+
+	require.NoError(t, store.Complete(ctx, "id", "t2", time.Hour))
+	result, _ = store.Claim(ctx, "id", "t3", time.Minute)
+	assert.Equal(t, ClaimDuplicate, result)
+}
+
+func TestCacheIdempotencyStore(t *testing.T) {
+	mredis := miniredis.RunT(t)
+	redisCache, err := cache.NewRedisCache(cache.RedisConfig{Addr: mredis.Addr(), Service: "orders"})
+	require.NoError(t, err)
+	defer redisCache.Close()
+	store := NewCacheIdempotencyStore(redisCache)
+	ctx := context.Background()
+
+	result, err := store.Claim(ctx, "id", "t1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, ClaimAcquired, result)
+	result, err = store.Claim(ctx, "id", "t2", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, ClaimBusy, result)
+
+	require.NoError(t, store.Release(ctx, "id", "t2"))
+	assert.True(t, mredis.Exists("orders:idempotency:id"), "the claim of t1 is kept")
+	require.NoError(t, store.Release(ctx, "id", "t1"))
+	result, _ = store.Claim(ctx, "id", "t2", time.Minute)
+	assert.Equal(t, ClaimAcquired, result)
+
+	// The claim of t2 expires and t3 takes it over
+	mredis.FastForward(2 * time.Minute)
+	result, _ = store.Claim(ctx, "id", "t3", time.Minute)
+	require.Equal(t, ClaimAcquired, result)
+	err = store.Complete(ctx, "id", "t2", time.Hour)
+	assert.True(t, errorx.IsConflict(err))
+	assert.True(t, errorx.IsRetryable(err))
+
+	require.NoError(t, store.Complete(ctx, "id", "t3", time.Hour))
+	require.NoError(t, store.Release(ctx, "id", "t3"))
+	result, err = store.Claim(ctx, "id", "t4", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, ClaimDuplicate, result)
+	assert.True(t, mredis.Exists("orders:idempotency:id"))
+
+	// Completing after the claim expired records the ID
+	mredis.FastForward(2 * time.Hour)
+	require.NoError(t, store.Complete(ctx, "id", "t4", time.Hour))
+	result, _ = store.Claim(ctx, "id", "t5", time.Minute)
+	assert.Equal(t, ClaimDuplicate, result)
+}
+
+// txMemoryStore is a MemoryIdempotencyStore completing IDs through a
+// recorded transaction.
+type txMemoryStore struct {
+	*MemoryIdempotencyStore
+	txs []DBTX
+}
+
+func (s *txMemoryStore) CompleteTx(ctx context.Context, tx DBTX, id, token string, ttl time.Duration) error {
+	s.txs = append(s.txs, tx)
+	return s.Complete(ctx, id, token, ttl)
+}
+
+func TestCompleteInTx(t *testing.T) {
+	store := &txMemoryStore{MemoryIdempotencyStore: NewMemoryIdempotencyStore()}
+	tx := &recordingExecutor{}
+	handler := Idempotent(store, func(ctx context.Context, msg Message) error {
+		return CompleteInTx(ctx, tx)
+	})
+	msg := Message{Topic: "orders", Offset: 1}
+
+	require.NoError(t, handler(context.Background(), msg))
+	assert.Equal(t, []DBTX{tx}, store.txs)
+	result, _ := store.Claim(context.Background(), MessageID(msg), "other", time.Minute)
+	assert.Equal(t, ClaimDuplicate, result)
+
+	assert.Error(t, CompleteInTx(context.Background(), tx))
+	err := Idempotent(NewMemoryIdempotencyStore(), func(ctx context.Context, msg Message) error {
+		return CompleteInTx(ctx, tx)
+	})(context.Background(), msg)
+	assert.ErrorContains(t, err, "does not support transactions")
+}
+
+func TestSQLIdempotencyStoreCompleteTx(t *testing.T) {
+	tx := &recordingExecutor{}
+	store := NewSQLIdempotencyStore(nil, WithIdempotencyTable("processed"), WithIdempotencyQuestionPlaceholders())
+
+	require.NoError(t, store.CompleteTx(context.Background(), tx, "id", "t1", time.Hour))
+	assert.Equal(t, []string{"UPDATE processed SET status = ?, expires_at = ? WHERE id = ? AND status = ? AND token = ?"}, tx.queries)
+	assert.Equal(t, "done", tx.args[0][0])
+	assert.Equal(t, []interface{}{"id", "processing", "t1"}, tx.args[0][2:])
+}
+
+// scriptedConn is a database/sql connection recording its statements. Its
+// UPDATE statements affect no row and its queries return status, if set.
+type scriptedConn struct {
+	status  string
+	queries []string
+}
+
+func (c *scriptedConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *scriptedConn) Driver() driver.Driver                        { return nil }
+func (c *scriptedConn) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (c *scriptedConn) Close() error                                 { return nil }
+func (c *scriptedConn) Begin() (driver.Tx, error)                    { return c, nil }
+func (c *scriptedConn) Commit() error                                { return nil }
+func (c *scriptedConn) Rollback() error                              { return nil }
+
+func (c *scriptedConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.queries = append(c.queries, query)
+	if strings.HasPrefix(query, "UPDATE") {
+		return driver.RowsAffected(0), nil
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *scriptedConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.queries = append(c.queries, query)
+	return &scriptedRows{status: c.status}, nil
+}
+
+type scriptedRows struct{ status string }
+
+func (r *scriptedRows) Columns() []string { return []string{"status"} }
+func (r *scriptedRows) Close() error      { return nil }
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if r.status == "" {
+		return io.EOF
+	}
+	dest[0], r.status = r.status, ""
+	return nil
+}
+
+func TestSQLIdempotencyStoreCompleteTxLostClaim(t *testing.T) {
+	store := NewSQLIdempotencyStore(nil, WithIdempotencyTable("processed"), WithIdempotencyQuestionPlaceholders())
+	complete := func(status string) ([]string, error) {
+		conn := &scriptedConn{status: status}
+		db := sql.OpenDB(conn)
+		defer db.Close()
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		defer tx.Rollback()
+		err = store.CompleteTx(context.Background(), tx, "id", "t1", time.Hour)
+		return conn.queries[1:], err
+	}
+
+	// Another consumer holds a live claim
+	queries, err := complete("processing")
+	assert.True(t, errorx.IsConflict(err))
+	assert.True(t, errorx.IsRetryable(err))
+	assert.Equal(t, []string{
+		"DELETE FROM processed WHERE id = ? AND expires_at < ?",
+		"SELECT status FROM processed WHERE id = ?",
+	}, queries)
+
+	// The ID was completed meanwhile
+	queries, err = complete("done")
+	assert.NoError(t, err)
+	assert.Len(t, queries, 2)
+
+	// The claim expired and was removed
+	queries, err = complete("")
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO processed (id, status, token, expires_at) VALUES (?, ?, ?, ?)", queries[2])
+
+	err = store.CompleteTx(context.Background(), notAffectingExecutor{}, "id", "t1", time.Hour)
+	assert.ErrorContains(t, err, "cannot inspect")
+}
+
+type notAffectingExecutor struct{}
+
+func (notAffectingExecutor) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return driver.RowsAffected(0), nil
+}
@@ -3,40 +3,30 @@ package event
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
 	"github.com/solum-sp/aps-be-common/common/utils"
 )
 
+// CreateTopicIfNotExist creates topicName unless it exists. Use TopicManager
+// to set topic configs or to check existing topics.
 func CreateTopicIfNotExist(adminClient *kafka.AdminClient, topicName string, numPartitions int, replicationFactor int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Second)
 	defer cancel()
 
-	maxDur, err := time.ParseDuration("60s")
-	if err != nil {
-		panic("ParseDuration(3s)")
-	}
-	// Create the topic if it does not exist
-	results, err := utils.Retry(retryCount, retryInterval, func() ([]kafka.TopicResult, error) {
-		return adminClient.CreateTopics(
-			ctx,
-			[]kafka.TopicSpecification{{
-				Topic:             topicName,
-				NumPartitions:     numPartitions,
-				ReplicationFactor: replicationFactor,
-			}},
-			kafka.SetAdminOperationTimeout(maxDur))
+	retryable := func(err error) bool { return ctx.Err() == nil && errorx.IsRetryable(err) }
+	_, err := utils.RetryIf(retryCount, retryInterval, retryable, func() (TopicReport, error) {
+		return NewTopicManager(adminClient).Ensure(ctx, TopicSpec{
+			Name:              topicName,
+			Partitions:        numPartitions,
+			ReplicationFactor: replicationFactor,
+		})
 	})
 	if err != nil {
-		fmt.Printf("Failed to create topic: %v\n", err)
-		os.Exit(1)
-	}
-	// Print results
-	for _, result := range results {
-		fmt.Printf("Topic created: %v\n", result)
+		return fmt.Errorf("failed to create topic %s: %w", topicName, err)
 	}
 	return nil
 }
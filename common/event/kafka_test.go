@@ -1,8 +1,12 @@
 package event
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,12 +28,18 @@ func TestKafkaProducerOptions(t *testing.T) {
 		WithKafkaClientID("test-client"),
 		WithKafkaConsumerGroupID("test-group"),
 		WithKafkaSchemaRegistryURL("http://schema-registry:8081"),
+		WithKafkaLingerMs(20),
+		WithKafkaBatchSize(65536),
+		WithKafkaCompression("zstd"),
 	}
 
 	for _, opt := range opts {
 		opt(&producerConfig, &consumerConfig, &schemaConfig)
 	}
 
+	assert.Equal(t, 20, producerConfig.LingerMs)
+	assert.Equal(t, 65536, producerConfig.BatchSize)
+	assert.Equal(t, "zstd", producerConfig.CompressionType)
 	assert.Equal(t, "kafka:9092", producerConfig.Brokers)
 	assert.Equal(t, "test-client", producerConfig.ClientID)
 	assert.Equal(t, "test-group", consumerConfig.GroupID)
@@ -64,3 +74,77 @@ func TestKafkaConsumerOptions(t *testing.T) {
 	assert.Equal(t, "test-group", consumerConfig.GroupID)
 	assert.Equal(t, "http://schema-registry:8081", schemaConfig.URL)
 }
+
+func TestKafkaSecurityOptions(t *testing.T) {
+	producerConfig := DefaultConfig.Producer
+	consumerConfig := DefaultConfig.Consumer
+	schemaConfig := DefaultConfig.Schema
+
+	opts := []KafkaOption{
+		WithKafkaSecurityProtocol("SASL_SSL"),
+		WithKafkaSASL("SCRAM-SHA-512", "user", "secret"),
+		WithKafkaTLS("/etc/kafka/ca.pem", "", ""),
+		WithKafkaConfig("socket.keepalive.enable", true),
+		WithKafkaConsumerConfig("auto.offset.reset", "latest"),
+		WithSchemaRegistryBasicAuth("sr-user", "sr-secret"),
+		WithSchemaRegistryTLS("/etc/registry/ca.pem", "", ""),
+	}
+	for _, opt := range opts {
+		opt(&producerConfig, &consumerConfig, &schemaConfig)
+	}
+
+	producer := *producerConfig.configMap()
+	assert.Equal(t, "SASL_SSL", producer["security.protocol"])
+	assert.Equal(t, "SCRAM-SHA-512", producer["sasl.mechanism"])
+	assert.Equal(t, "user", producer["sasl.username"])
+	assert.Equal(t, "secret", producer["sasl.password"])
+	assert.Equal(t, "/etc/kafka/ca.pem", producer["ssl.ca.location"])
+	assert.NotContains(t, producer, "ssl.certificate.location")
+	assert.Equal(t, true, producer["socket.keepalive.enable"])
+	assert.NotContains(t, producer, "auto.offset.reset")
+
+	consumer := *consumerConfig.configMap()
+	assert.Equal(t, "SASL_SSL", consumer["security.protocol"])
+	assert.Equal(t, "latest", consumer["auto.offset.reset"], "properties override the other options")
+	assert.Nil(t, DefaultConfig.Consumer.Properties)
+
+	registry := schemaConfig.clientConfig()
+	assert.Equal(t, "sr-user:sr-secret", registry.BasicAuthUserInfo)
+	assert.Equal(t, "/etc/registry/ca.pem", registry.SslCaLocation)
+
+	WithSchemaRegistryBearerAuth("token", "lsrc-1", "pool-1")(&producerConfig, &consumerConfig, &schemaConfig)
+	registry = schemaConfig.clientConfig()
+	assert.Equal(t, "token", registry.BearerAuthToken)
+	assert.Equal(t, "lsrc-1", registry.BearerAuthLogicalCluster)
+}
+
+func TestKafkaTransactionalID(t *testing.T) {
+	producerConfig := DefaultConfig.Producer
+	consumerConfig := DefaultConfig.Consumer
+	schemaConfig := DefaultConfig.Schema
+	WithKafkaIdempotence(false)(&producerConfig, &consumerConfig, &schemaConfig)
+	assert.Equal(t, false, (*producerConfig.configMap())["enable.idempotence"])
+
+	WithKafkaTransactionalID("billing-0")(&producerConfig, &consumerConfig, &schemaConfig)
+	cm := *producerConfig.configMap()
+	assert.Equal(t, "billing-0", cm["transactional.id"])
+	assert.Equal(t, true, cm["enable.idempotence"])
+}
+
+func TestClassifyKafkaErrors(t *testing.T) {
+	err := classify(fmt.Errorf("delivery failed: %w", kafka.NewError(kafka.ErrMsgTimedOut, "timed out", false)))
+	assert.True(t, errorx.IsTimeout(err))
+	assert.True(t, errorx.IsRetryable(err))
+	assert.Contains(t, err.Error(), "delivery failed")
+
+	err = classify(kafka.NewError(kafka.ErrUnknownTopicOrPart, "unknown topic", false))
+	assert.True(t, errorx.IsNotFound(err))
+	assert.False(t, errorx.IsRetryable(err))
+
+	err = classify(kafka.NewError(kafka.ErrFatal, "fenced", true))
+	assert.True(t, errorx.IsInternal(err))
+	assert.False(t, errorx.IsRetryable(err))
+
+	plain := errors.New("plain")
+	assert.Equal(t, plain, classify(plain))
+}
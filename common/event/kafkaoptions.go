@@ -1,9 +1,24 @@
 package event
 
+import (
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+)
+
 // KafkaProducerConfig holds Kafka producer settings
 type KafkaProducerConfig struct {
-	Brokers  string
-	ClientID string
+	Brokers         string
+	ClientID        string
+	LingerMs        int    // Time to wait for more messages before sending a batch
+	BatchSize       int    // Maximum size of a batch in bytes
+	CompressionType string // none, gzip, snappy, lz4 or zstd
+	Partitioner     string // librdkafka partitioner of keyed messages
+	Idempotent      bool   // No duplicates nor reordering on retries; requires acks=all
+	TransactionalID string // Enables transactions, see NewTransactionalProcessor
+	Security        KafkaSecurityConfig
+	Properties      map[string]interface{} // Other librdkafka properties, applied last
 }
 
 // KafkaConsumerConfig holds Kafka consumer settings
@@ -18,11 +33,35 @@ type KafkaConsumerConfig struct {
 	RetryBackoffMs      int
 	FetchMinBytes       int
 	FetchWaitMaxMs      int
+	Security            KafkaSecurityConfig
+	Properties          map[string]interface{} // Other librdkafka properties, applied last
+}
+
+// KafkaSecurityConfig holds the settings securing the connections to the
+// brokers. Empty fields keep the librdkafka defaults.
+type KafkaSecurityConfig struct {
+	Protocol      string // PLAINTEXT, SSL, SASL_PLAINTEXT or SASL_SSL
+	SASLMechanism string // PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 or OAUTHBEARER
+	SASLUsername  string
+	SASLPassword  string
+	CALocation    string // CA certificates verifying the brokers, PEM file
+	CertLocation  string // Client certificate, PEM file
+	KeyLocation   string // Client private key, PEM file
+	KeyPassword   string
 }
 
 // SchemaRegistryConfig holds Schema Registry settings
 type SchemaRegistryConfig struct {
-	URL string
+	URL                 string
+	SubjectNameStrategy SubjectNameStrategy // TopicNameStrategy if nil
+	BasicAuthUsername   string
+	BasicAuthPassword   string
+	BearerToken         string
+	LogicalCluster      string // Target cluster of bearer requests, e.g. Confluent Cloud lsrc-xxxxx
+	IdentityPoolID      string // Identity pool of bearer requests
+	CALocation          string
+	CertLocation        string
+	KeyLocation         string
 }
 
 // DefaultConfig holds the default Kafka settings
@@ -32,8 +71,13 @@ var DefaultConfig = struct {
 	Schema   SchemaRegistryConfig
 }{
 	Producer: KafkaProducerConfig{
-		Brokers:  "localhost:9092",
-		ClientID: "default-client",
+		Brokers:         "localhost:9092",
+		ClientID:        "default-client",
+		LingerMs:        5,
+		BatchSize:       1000000,
+		CompressionType: "none",
+		Partitioner:     "consistent_random",
+		Idempotent:      true,
 	},
 	Consumer: KafkaConsumerConfig{
 		Brokers:             "localhost:9092",
@@ -70,6 +114,53 @@ func WithKafkaClientID(clientID string) KafkaOption {
 	}
 }
 
+// WithKafkaLingerMs sets how long the producer waits to fill a batch
+func WithKafkaLingerMs(ms int) KafkaOption {
+	return func(p *KafkaProducerConfig, _ *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.LingerMs = ms
+	}
+}
+
+// WithKafkaBatchSize sets the maximum size of a producer batch in bytes
+func WithKafkaBatchSize(bytes int) KafkaOption {
+	return func(p *KafkaProducerConfig, _ *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.BatchSize = bytes
+	}
+}
+
+// WithKafkaCompression sets the producer compression codec: none, gzip, snappy, lz4 or zstd
+func WithKafkaCompression(codec string) KafkaOption {
+	return func(p *KafkaProducerConfig, _ *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.CompressionType = codec
+	}
+}
+
+// WithKafkaPartitioner sets the librdkafka partitioner, e.g. "murmur2_random"
+// to place keys on the same partitions as the Java client
+func WithKafkaPartitioner(partitioner string) KafkaOption {
+	return func(p *KafkaProducerConfig, _ *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.Partitioner = partitioner
+	}
+}
+
+// WithKafkaIdempotence enables or disables the idempotent producer, enabled
+// by default
+func WithKafkaIdempotence(enable bool) KafkaOption {
+	return func(p *KafkaProducerConfig, _ *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.Idempotent = enable
+	}
+}
+
+// WithKafkaTransactionalID sets the transactional ID of the producer, which
+// must be unique per producer instance and stable across its restarts. It
+// implies idempotence.
+func WithKafkaTransactionalID(id string) KafkaOption {
+	return func(p *KafkaProducerConfig, _ *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.TransactionalID = id
+		p.Idempotent = true
+	}
+}
+
 // WithKafkaConsumerGroupID sets Kafka consumer group ID
 func WithKafkaConsumerGroupID(groupID string) KafkaOption {
 	return func(_ *KafkaProducerConfig, c *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
@@ -84,6 +175,13 @@ func WithKafkaSchemaRegistryURL(url string) KafkaOption {
 	}
 }
 
+// WithSubjectNameStrategy sets how the subjects of the schemas are named
+func WithSubjectNameStrategy(strategy SubjectNameStrategy) KafkaOption {
+	return func(_ *KafkaProducerConfig, _ *KafkaConsumerConfig, s *SchemaRegistryConfig) {
+		s.SubjectNameStrategy = strategy
+	}
+}
+
 // WithKafkaAutoOffsetReset sets the auto offset reset policy
 func WithKafkaAutoOffsetReset(offset string) KafkaOption {
 	return func(_ *KafkaProducerConfig, c *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
@@ -138,3 +236,190 @@ func WithKafkaFetchWaitMaxMs(ms int) KafkaOption {
 		c.FetchWaitMaxMs = ms
 	}
 }
+
+// WithKafkaSecurityProtocol sets the security protocol of the connections to
+// the brokers: PLAINTEXT, SSL, SASL_PLAINTEXT or SASL_SSL
+func WithKafkaSecurityProtocol(protocol string) KafkaOption {
+	return func(p *KafkaProducerConfig, c *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.Security.Protocol = protocol
+		c.Security.Protocol = protocol
+	}
+}
+
+// WithKafkaSASL sets the SASL mechanism and credentials, e.g. SCRAM-SHA-512
+func WithKafkaSASL(mechanism, username, password string) KafkaOption {
+	return func(p *KafkaProducerConfig, c *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		for _, s := range []*KafkaSecurityConfig{&p.Security, &c.Security} {
+			s.SASLMechanism = mechanism
+			s.SASLUsername = username
+			s.SASLPassword = password
+		}
+	}
+}
+
+// WithKafkaTLS sets the CA file verifying the brokers and, for mutual TLS,
+// the client certificate and key files; empty paths are ignored
+func WithKafkaTLS(caFile, certFile, keyFile string) KafkaOption {
+	return func(p *KafkaProducerConfig, c *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		for _, s := range []*KafkaSecurityConfig{&p.Security, &c.Security} {
+			s.CALocation = caFile
+			s.CertLocation = certFile
+			s.KeyLocation = keyFile
+		}
+	}
+}
+
+// WithKafkaTLSKeyPassword sets the password of the client key file
+func WithKafkaTLSKeyPassword(password string) KafkaOption {
+	return func(p *KafkaProducerConfig, c *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.Security.KeyPassword = password
+		c.Security.KeyPassword = password
+	}
+}
+
+// WithKafkaConfig sets a librdkafka property of producers and consumers,
+// overriding the other options
+func WithKafkaConfig(key string, value interface{}) KafkaOption {
+	return func(p *KafkaProducerConfig, c *KafkaConsumerConfig, s *SchemaRegistryConfig) {
+		WithKafkaProducerConfig(key, value)(p, c, s)
+		WithKafkaConsumerConfig(key, value)(p, c, s)
+	}
+}
+
+// WithKafkaProducerConfig sets a librdkafka property of producers
+func WithKafkaProducerConfig(key string, value interface{}) KafkaOption {
+	return func(p *KafkaProducerConfig, _ *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		p.Properties = withProperty(p.Properties, key, value)
+	}
+}
+
+// WithKafkaConsumerConfig sets a librdkafka property of consumers
+func WithKafkaConsumerConfig(key string, value interface{}) KafkaOption {
+	return func(_ *KafkaProducerConfig, c *KafkaConsumerConfig, _ *SchemaRegistryConfig) {
+		c.Properties = withProperty(c.Properties, key, value)
+	}
+}
+
+// WithKafkaStatisticsInterval makes consumers emit librdkafka statistics
+// every d, from which Consume reads the partition lag, see
+// WithConsumeMetrics. Producers are not affected: their statistics would
+// pile up unread.
+func WithKafkaStatisticsInterval(d time.Duration) KafkaOption {
+	return WithKafkaConsumerConfig("statistics.interval.ms", int(d.Milliseconds()))
+}
+
+// WithSchemaRegistryBasicAuth sets the credentials of the schema registry
+func WithSchemaRegistryBasicAuth(username, password string) KafkaOption {
+	return func(_ *KafkaProducerConfig, _ *KafkaConsumerConfig, s *SchemaRegistryConfig) {
+		s.BasicAuthUsername = username
+		s.BasicAuthPassword = password
+	}
+}
+
+// WithSchemaRegistryBearerAuth sets the bearer token of the schema registry.
+// logicalCluster and identityPoolID are required by Confluent Cloud.
+func WithSchemaRegistryBearerAuth(token, logicalCluster, identityPoolID string) KafkaOption {
+	return func(_ *KafkaProducerConfig, _ *KafkaConsumerConfig, s *SchemaRegistryConfig) {
+		s.BearerToken = token
+		s.LogicalCluster = logicalCluster
+		s.IdentityPoolID = identityPoolID
+	}
+}
+
+// WithSchemaRegistryTLS sets the CA file verifying the schema registry and,
+// for mutual TLS, the client certificate and key files
+func WithSchemaRegistryTLS(caFile, certFile, keyFile string) KafkaOption {
+	return func(_ *KafkaProducerConfig, _ *KafkaConsumerConfig, s *SchemaRegistryConfig) {
+		s.CALocation = caFile
+		s.CertLocation = certFile
+		s.KeyLocation = keyFile
+	}
+}
+
+// withProperty returns a copy of properties with key set, so configs copied
+// from DefaultConfig never share a map.
+func withProperty(properties map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(properties)+1)
+	for k, v := range properties {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+// apply sets the non-empty security settings on cm.
+func (s KafkaSecurityConfig) apply(cm kafka.ConfigMap) {
+	for key, value := range map[string]string{
+		"security.protocol":        s.Protocol,
+		"sasl.mechanism":           s.SASLMechanism,
+		"sasl.username":            s.SASLUsername,
+		"sasl.password":            s.SASLPassword,
+		"ssl.ca.location":          s.CALocation,
+		"ssl.certificate.location": s.CertLocation,
+		"ssl.key.location":         s.KeyLocation,
+		"ssl.key.password":         s.KeyPassword,
+	} {
+		if value != "" {
+			cm[key] = value
+		}
+	}
+}
+
+// configMap returns the librdkafka configuration of the producer.
+func (c KafkaProducerConfig) configMap() *kafka.ConfigMap {
+	cm := kafka.ConfigMap{
+		"bootstrap.servers":  c.Brokers,
+		"client.id":          c.ClientID,
+		"linger.ms":          c.LingerMs,
+		"batch.size":         c.BatchSize,
+		"compression.type":   c.CompressionType,
+		"partitioner":        c.Partitioner,
+		"enable.idempotence": c.Idempotent,
+	}
+	if c.TransactionalID != "" {
+		cm["transactional.id"] = c.TransactionalID
+	}
+	c.Security.apply(cm)
+	for k, v := range c.Properties {
+		cm[k] = v
+	}
+	return &cm
+}
+
+// configMap returns the librdkafka configuration of the consumer.
+func (c KafkaConsumerConfig) configMap() *kafka.ConfigMap {
+	cm := kafka.ConfigMap{
+		"bootstrap.servers":     c.Brokers,
+		"group.id":              c.GroupID,
+		"auto.offset.reset":     c.AutoOffsetReset,
+		"enable.auto.commit":    c.EnableAutoCommit,
+		"max.poll.interval.ms":  c.MaxPollIntervalMs,
+		"session.timeout.ms":    c.SessionTimeoutMs,
+		"heartbeat.interval.ms": c.HeartbeatIntervalMs,
+		"retry.backoff.ms":      c.RetryBackoffMs,
+		"fetch.min.bytes":       c.FetchMinBytes,
+		"fetch.wait.max.ms":     c.FetchWaitMaxMs,
+	}
+	c.Security.apply(cm)
+	for k, v := range c.Properties {
+		cm[k] = v
+	}
+	return &cm
+}
+
+// clientConfig returns the configuration of the schema registry client.
+func (c SchemaRegistryConfig) clientConfig() *schemaregistry.Config {
+	var config *schemaregistry.Config
+	switch {
+	case c.BearerToken != "":
+		config = schemaregistry.NewConfigWithBearerAuthentication(c.URL, c.BearerToken, c.LogicalCluster, c.IdentityPoolID)
+	case c.BasicAuthUsername != "":
+		config = schemaregistry.NewConfigWithBasicAuthentication(c.URL, c.BasicAuthUsername, c.BasicAuthPassword)
+	default:
+		config = schemaregistry.NewConfig(c.URL)
+	}
+	config.SslCaLocation = c.CALocation
+	config.SslCertificateLocation = c.CertLocation
+	config.SslKeyLocation = c.KeyLocation
+	return config
+}
@@ -2,62 +2,318 @@ package event
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
-	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/avrov2"
+	"github.com/solum-sp/aps-be-common/common/errorx"
 )
 
+// ErrPublisherClosed is returned when sending through a closed publisher.
+var ErrPublisherClosed = errors.New("publisher is closed")
+
 type kafkaPublisher struct {
 	producer *kafka.Producer
-	serde    serde.Serializer
+	serde    Serializer
+	format   Format
 	topic    string
+
+	partitioner  Partitioner
+	metrics      *Metrics
+	partitionsMu sync.Mutex
+	partitions   int
+	partitionsAt time.Time
+
+	deliveries chan kafka.Event
+	pending    atomic.Int64
+	stopOnce   sync.Once
+	stop       chan struct{}
+	closeMu    sync.RWMutex
+	closed     bool
+	done       chan struct{}
 }
 
-var _ IPublisher = (*kafkaPublisher)(nil)
+var _ IAsyncPublisher = (*kafkaPublisher)(nil)
 
-func NewKafkaPublisher(producer *kafka.Producer, sr *SchemaRegistry, schemaID int, topic string) (*kafkaPublisher, error) {
-	serde, err := avrov2.NewSerializer(sr.client, serde.ValueSerde, &avrov2.SerializerConfig{
-		SerializerConfig: serde.SerializerConfig{
-			AutoRegisterSchemas: false,
-			UseSchemaID:         schemaID,
-			UseLatestVersion:    true,
-			NormalizeSchemas:    true,
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create avro serializer: %s", err)
+// partitionRefresh is how long the partition count of a topic is cached.
+const partitionRefresh = time.Minute
+
+// PublisherOption is a functional option for configuring a publisher
+type PublisherOption func(*kafkaPublisher)
+
+// WithPartitioner sets the partitioner choosing the partition of messages
+// without an explicit one. By default librdkafka chooses from the key, see
+// WithKafkaPartitioner.
+func WithPartitioner(p Partitioner) PublisherOption {
+	return func(s *kafkaPublisher) {
+		s.partitioner = p
+	}
+}
+
+// WithPublisherFormat sets the encoding of the values, FormatAvro by default
+func WithPublisherFormat(format Format) PublisherOption {
+	return func(s *kafkaPublisher) {
+		s.format = format
+	}
+}
+
+// WithSerializer sets the serializer of the values, overriding the format
+func WithSerializer(serializer Serializer) PublisherOption {
+	return func(s *kafkaPublisher) {
+		s.serde = serializer
+	}
+}
+
+// WithPublisherMetrics counts the delivered messages and the failures, by
+// error kind, in m.
+func WithPublisherMetrics(m *Metrics) PublisherOption {
+	return func(s *kafkaPublisher) {
+		s.metrics = m
+	}
+}
+
+// NewKafkaPublisher returns a publisher of topic. Values are encoded with
+// the schema schemaID of the registry, in Avro unless another format is set
+// with WithPublisherFormat; schemaID <= 0 uses the latest version of the
+// subject.
+func NewKafkaPublisher(producer *kafka.Producer, sr *SchemaRegistry, schemaID int, topic string, opts ...PublisherOption) (*kafkaPublisher, error) {
+	p := applyPublisherOptions(opts)
+	if p.serde == nil {
+		serializer, err := NewSerializer(sr, p.format, WithSchemaID(schemaID))
+		if err != nil {
+			return nil, err
+		}
+		p.serde = serializer
 	}
-	return &kafkaPublisher{producer: producer, serde: serde, topic: topic}, nil
+	return p.start(producer, topic), nil
 }
 
+// newKafkaPublisher returns a publisher encoding values with serializer,
+// unless opts set another one.
+func newKafkaPublisher(producer *kafka.Producer, serializer Serializer, topic string, opts ...PublisherOption) *kafkaPublisher {
+	p := applyPublisherOptions(opts)
+	if p.serde == nil {
+		p.serde = serializer
+	}
+	return p.start(producer, topic)
+}
+
+// applyPublisherOptions returns a publisher with the defaults and opts
+// applied, not started yet.
+func applyPublisherOptions(opts []PublisherOption) *kafkaPublisher {
+	p := &kafkaPublisher{format: FormatAvro}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// start makes p publish to topic through producer.
+func (p *kafkaPublisher) start(producer *kafka.Producer, topic string) *kafkaPublisher {
+	p.producer = producer
+	p.topic = topic
+	p.deliveries = make(chan kafka.Event, 1024)
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.handleDeliveries()
+	return p
+}
+
+// SendMessage publishes value without key or headers and waits for the
+// broker to acknowledge it.
 func (s *kafkaPublisher) SendMessage(ctx context.Context, value interface{}) error {
-	deliveryChan := make(chan kafka.Event)
+	return s.Publish(ctx, ProducerMessage{Value: value})
+}
 
-	payload, err := s.serde.Serialize(s.topic, &value)
-	if err != nil {
-		return fmt.Errorf("failed to serialize: %s", err)
+// Publish sends msg and waits for the broker to acknowledge it.
+func (s *kafkaPublisher) Publish(ctx context.Context, msg ProducerMessage) error {
+	_, err := s.PublishAsync(ctx, msg).Wait(ctx)
+	return err
+}
+
+// SendMessageAsync is like PublishAsync for a message without key or headers.
+func (s *kafkaPublisher) SendMessageAsync(ctx context.Context, value interface{}, callbacks ...func(DeliveryReport)) *DeliveryFuture {
+	return s.PublishAsync(ctx, ProducerMessage{Value: value}, callbacks...)
+}
+
+// PublishAsync enqueues msg and returns immediately. The trace context,
+// request ID and tenant ID of ctx are added to its headers. The returned
+// future completes, and the callbacks are invoked from the delivery
+// goroutine, once the broker acknowledged the message or delivery failed.
+// Callbacks must not block.
+func (s *kafkaPublisher) PublishAsync(ctx context.Context, msg ProducerMessage, callbacks ...func(DeliveryReport)) *DeliveryFuture {
+	if s.metrics != nil {
+		callbacks = append([]func(DeliveryReport){s.observeDelivery}, callbacks...)
 	}
+	f := newDeliveryFuture(callbacks)
 
-	err = s.producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &s.topic, Partition: kafka.PartitionAny},
+	payload, err := s.serde.Serialize(s.topic, msg.Value)
+	if err != nil {
+		f.complete(DeliveryReport{Topic: s.topic, Err: errorx.Tag(fmt.Errorf("failed to serialize: %w", err), errorx.ClassValidation)})
+		return f
+	}
+	partition, err := s.partition(&msg)
+	if err != nil {
+		f.complete(DeliveryReport{Topic: s.topic, Err: err})
+		return f
+	}
+	s.produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &s.topic, Partition: partition},
+		Key:            msg.Key,
 		Value:          payload,
-	}, deliveryChan)
+		Headers:        toKafkaHeaders(InjectHeaders(ctx, eventHeaders(msg.Value, msg.Headers))),
+		Timestamp:      msg.Timestamp,
+	}, f)
+	return f
+}
+
+func (s *kafkaPublisher) observeDelivery(report DeliveryReport) {
+	s.metrics.ObserveProduced(report.Topic, report.Err)
+}
+
+// partition returns the partition of msg: the explicit one, the one chosen
+// by the partitioner, or kafka.PartitionAny to let librdkafka choose from
+// the key.
+func (s *kafkaPublisher) partition(msg *ProducerMessage) (int32, error) {
+	if msg.Partition != nil {
+		return *msg.Partition, nil
+	}
+	if s.partitioner == nil {
+		return kafka.PartitionAny, nil
+	}
+	n, err := s.partitionCount()
+	if err != nil {
+		return 0, err
+	}
+	return s.partitioner.Partition(msg, n), nil
+}
+
+// partitionCount returns the number of partitions of the topic, looked up
+// in the cluster metadata at most every partitionRefresh.
+func (s *kafkaPublisher) partitionCount() (int, error) {
+	s.partitionsMu.Lock()
+	defer s.partitionsMu.Unlock()
+	if s.partitions > 0 && time.Since(s.partitionsAt) < partitionRefresh {
+		return s.partitions, nil
+	}
+	md, err := s.producer.GetMetadata(&s.topic, false, 5000)
 	if err != nil {
-		return fmt.Errorf("produce failed: %v", err)
+		return 0, classify(fmt.Errorf("failed to get metadata of topic %s: %w", s.topic, err))
+	}
+	tm, ok := md.Topics[s.topic]
+	if ok && tm.Error.Code() != kafka.ErrNoError {
+		return 0, classify(fmt.Errorf("failed to get partitions of topic %s: %w", s.topic, tm.Error))
+	}
+	if !ok || len(tm.Partitions) == 0 {
+		return 0, errorx.Tag(fmt.Errorf("topic %s has no partitions", s.topic), errorx.ClassNotFound)
+	}
+	s.partitions = len(tm.Partitions)
+	s.partitionsAt = time.Now()
+	return s.partitions, nil
+}
+
+// produce hands msg to librdkafka; f completes when its delivery report is
+// received.
+func (s *kafkaPublisher) produce(msg *kafka.Message, f *DeliveryFuture) {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		f.complete(DeliveryReport{Topic: *msg.TopicPartition.Topic, Err: ErrPublisherClosed})
+		return
+	}
+
+	msg.Opaque = f
+	s.pending.Add(1)
+	// The message stops being pending once the other callbacks ran, so that
+	// Flush returns after them.
+	f.callbacks = append(f.callbacks, func(DeliveryReport) { s.pending.Add(-1) })
+	if err := s.producer.Produce(msg, s.deliveries); err != nil {
+		f.complete(DeliveryReport{Topic: *msg.TopicPartition.Topic, Err: classify(fmt.Errorf("produce failed: %w", err))})
 	}
+}
 
-	e := <-deliveryChan
-	m := e.(*kafka.Message)
+// handleDeliveries completes the futures of the delivery reports until the
+// publisher is closed and no message is outstanding. The deliveries channel
+// is never closed since librdkafka may still write late reports to it.
+func (s *kafkaPublisher) handleDeliveries() {
+	defer close(s.done)
+	for {
+		select {
+		case e := <-s.deliveries:
+			s.deliver(e)
+			if s.isClosed() && s.pending.Load() == 0 {
+				return
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
 
+func (s *kafkaPublisher) deliver(e kafka.Event) {
+	m, ok := e.(*kafka.Message)
+	if !ok {
+		return
+	}
+	f, ok := m.Opaque.(*DeliveryFuture)
+	if !ok {
+		return
+	}
+	report := DeliveryReport{
+		Partition: m.TopicPartition.Partition,
+		Offset:    int64(m.TopicPartition.Offset),
+	}
+	if m.TopicPartition.Topic != nil {
+		report.Topic = *m.TopicPartition.Topic
+	}
 	if m.TopicPartition.Error != nil {
-		return fmt.Errorf("delivery failed: %v", m.TopicPartition.Error)
+		report.Err = classify(fmt.Errorf("delivery failed: %w", m.TopicPartition.Error))
+	}
+	f.complete(report)
+}
+
+func (s *kafkaPublisher) isClosed() bool {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	return s.closed
+}
+
+// Flush waits until every message sent through the publisher is delivered
+// or failed, or until ctx is done.
+func (s *kafkaPublisher) Flush(ctx context.Context) error {
+	for s.pending.Load() > 0 {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("flush interrupted with %d messages outstanding: %w", s.pending.Load(), err)
+		}
+		s.producer.Flush(100)
+		select {
+		case <-ctx.Done():
+		case <-time.After(10 * time.Millisecond):
+		}
 	}
+	return nil
+}
 
-	fmt.Printf("Delivered message to topic: %s [%d] at offset: %v\n",
-		*m.TopicPartition.Topic, m.TopicPartition.Partition, m.TopicPartition.Offset)
+// Close stops accepting messages and waits for the outstanding ones like
+// Flush. If ctx is done first, the outstanding messages still complete their
+// futures and the delivery goroutine stops after the last one. The
+// underlying producer is left open since it may be shared by several
+// publishers; close it once all of them are closed.
+func (s *kafkaPublisher) Close(ctx context.Context) error {
+	s.closeMu.Lock()
+	s.closed = true
+	s.closeMu.Unlock()
 
+	if err := s.Flush(ctx); err != nil {
+		return err
+	}
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
 	return nil
 }
 
@@ -69,12 +325,7 @@ func NewKafkaProducer(opts ...KafkaOption) (*kafka.Producer, error) {
 	for _, opt := range opts {
 		opt(&producerConfig, &consumerConfig, &schemaConfig)
 	}
-	return kafka.NewProducer(&kafka.ConfigMap{
-		// "bootstrap.servers": cfg.Brokers,
-		// "client.id":         cfg.ClientID,
-		"bootstrap.servers": producerConfig.Brokers,
-		"client.id":         producerConfig.ClientID,
-	})
+	return kafka.NewProducer(producerConfig.configMap())
 }
 
 func NewAdminClientFromProducer(producer *kafka.Producer) (*kafka.AdminClient, error) {
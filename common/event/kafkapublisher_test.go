@@ -0,0 +1,134 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUnreachablePublisher returns a publisher whose messages time out since
+// no broker listens on its bootstrap address.
+func newUnreachablePublisher(t *testing.T, opts ...PublisherOption) *kafkaPublisher {
+	t.Helper()
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  "127.0.0.1:1",
+		"message.timeout.ms": 200,
+		"log_level":          0,
+	})
+	require.NoError(t, err)
+	t.Cleanup(producer.Close)
+	return newKafkaPublisher(producer, jsonSerde{}, "orders", opts...)
+}
+
+func TestSendMessageAsyncReportsDelivery(t *testing.T) {
+	p := newUnreachablePublisher(t)
+	ctx := context.Background()
+
+	var called atomic.Int32
+	futures := make([]*DeliveryFuture, 3)
+	for i := range futures {
+		futures[i] = p.SendMessageAsync(ctx, map[string]int{"n": i}, func(r DeliveryReport) {
+			called.Add(1)
+		})
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, p.Flush(waitCtx))
+	for _, f := range futures {
+		report, err := f.Wait(waitCtx)
+		assert.Error(t, err)
+		assert.Equal(t, "orders", report.Topic)
+		assert.True(t, errorx.IsTimeout(err))
+		assert.True(t, errorx.IsRetryable(err))
+	}
+	assert.Equal(t, int32(3), called.Load())
+}
+
+func TestDeliveryFutureRunsCallbacksBeforeCompleting(t *testing.T) {
+	var called atomic.Bool
+	f := newDeliveryFuture([]func(DeliveryReport){func(DeliveryReport) {
+		time.Sleep(10 * time.Millisecond)
+		called.Store(true)
+	}})
+	go f.complete(DeliveryReport{Topic: "orders"})
+
+	_, err := f.Wait(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, called.Load())
+}
+
+func TestSendMessageAsyncSerializationError(t *testing.T) {
+	p := newUnreachablePublisher(t)
+	f := p.SendMessageAsync(context.Background(), make(chan int))
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("serialization errors must complete the future immediately")
+	}
+	_, err := f.Wait(context.Background())
+	assert.True(t, errorx.IsValidation(err))
+}
+
+func TestPublisherClose(t *testing.T) {
+	p := newUnreachablePublisher(t)
+	f := p.SendMessageAsync(context.Background(), "pending")
+
+	// An expired context interrupts the wait but keeps the publisher usable
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, p.Close(expired), context.Canceled)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, p.Close(ctx))
+	_, err := f.Wait(ctx)
+	assert.Error(t, err)
+
+	_, err = p.SendMessageAsync(ctx, "late").Wait(ctx)
+	assert.True(t, errors.Is(err, ErrPublisherClosed))
+	assert.NoError(t, p.Close(ctx))
+}
+
+func TestPublisherCloseInterruptedStopsDeliveries(t *testing.T) {
+	p := newUnreachablePublisher(t)
+	f := p.SendMessageAsync(context.Background(), "pending")
+
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, p.Close(expired), context.Canceled)
+
+	// The delivery goroutine stops once the outstanding message timed out
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery goroutine still running")
+	}
+	_, err := f.Wait(context.Background())
+	assert.True(t, errorx.IsTimeout(err))
+}
+
+func TestNewKafkaPublisherAppliesOptionsOnce(t *testing.T) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": "127.0.0.1:1", "log_level": 0})
+	require.NoError(t, err)
+	t.Cleanup(producer.Close)
+
+	applied := 0
+	opts := make([]PublisherOption, 2, 3)
+	opts[0] = WithSerializer(jsonSerde{})
+	opts[1] = func(*kafkaPublisher) { applied++ }
+	p, err := NewKafkaPublisher(producer, nil, 0, "orders", opts...)
+	require.NoError(t, err)
+	defer p.Close(context.Background())
+
+	assert.Equal(t, 1, applied)
+	assert.Nil(t, opts[:3][2], "the caller's slice is left untouched")
+	assert.Equal(t, jsonSerde{}, p.serde)
+}
@@ -2,12 +2,14 @@ package event
 
 import (
 	"embed"
+	"errors"
 	"fmt"
 	"log"
 
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/rest"
 	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
 	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/avrov2"
 	"github.com/solum-sp/aps-be-common/common/utils"
@@ -19,7 +21,8 @@ const (
 )
 
 type SchemaRegistry struct {
-	client schemaregistry.Client
+	client   schemaregistry.Client
+	strategy SubjectNameStrategy
 }
 
 func NewSchemaRegistry(opts ...KafkaOption) (*SchemaRegistry, error) {
@@ -33,13 +36,14 @@ func NewSchemaRegistry(opts ...KafkaOption) (*SchemaRegistry, error) {
 		opt(&producerConfig, &consumerConfig, &schemaConfig)
 	}
 	sr, err := utils.Retry(retryCount, retryInterval, func() (schemaregistry.Client, error) {
-		return schemaregistry.NewClient(schemaregistry.NewConfig(schemaConfig.URL))
+		return schemaregistry.NewClient(schemaConfig.clientConfig())
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create schema registry client: %s", err)
 	}
 	return &SchemaRegistry{
-		client: sr,
+		client:   sr,
+		strategy: schemaConfig.SubjectNameStrategy,
 	}, nil
 }
 
@@ -48,23 +52,65 @@ func (s *SchemaRegistry) Close() {
 }
 
 func (s *SchemaRegistry) FindOrCreateArvoSchema(topic string, baseFS embed.FS, fullFileName string) (int, error) {
+	return s.FindOrCreateSchema(topic, FormatAvro, baseFS, fullFileName)
+}
+
+// FindOrCreateProtobufSchema registers the .proto file fullFileName as the
+// value schema of topic and returns its ID
+func (s *SchemaRegistry) FindOrCreateProtobufSchema(topic string, baseFS embed.FS, fullFileName string) (int, error) {
+	return s.FindOrCreateSchema(topic, FormatProtobuf, baseFS, fullFileName)
+}
+
+// FindOrCreateJSONSchema registers the JSON Schema file fullFileName as the
+// value schema of topic and returns its ID
+func (s *SchemaRegistry) FindOrCreateJSONSchema(topic string, baseFS embed.FS, fullFileName string) (int, error) {
+	return s.FindOrCreateSchema(topic, FormatJSONSchema, baseFS, fullFileName)
+}
+
+// FindOrCreateSchema registers the schema file fullFileName of format as the
+// value schema of topic and returns its ID. Registering a schema that already
+// exists returns the existing ID.
+func (s *SchemaRegistry) FindOrCreateSchema(topic string, format Format, baseFS embed.FS, fullFileName string, refs ...schemaregistry.Reference) (int, error) {
+	return s.findOrCreateSchema(topic, false, format, baseFS, fullFileName, refs)
+}
+
+// FindOrCreateKeySchema registers the schema file fullFileName of format as
+// the key schema of topic and returns its ID
+func (s *SchemaRegistry) FindOrCreateKeySchema(topic string, format Format, baseFS embed.FS, fullFileName string, refs ...schemaregistry.Reference) (int, error) {
+	return s.findOrCreateSchema(topic, true, format, baseFS, fullFileName, refs)
+}
+
+func (s *SchemaRegistry) findOrCreateSchema(topic string, isKey bool, format Format, baseFS embed.FS, fullFileName string, refs []schemaregistry.Reference) (int, error) {
 	schema, err := baseFS.ReadFile(fullFileName)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read avro schema file: %s", err)
+		return 0, fmt.Errorf("failed to read %s schema file: %s", format, err)
 	}
-	id, err := s.createAvroSchema(topic, schema)
+	subject, err := s.Subject(topic, isKey, format, string(schema))
 	if err != nil {
-		return 0, fmt.Errorf("failed to create avro schema: %s", err)
+		return 0, err
+	}
+	id, err := s.RegisterSchema(subject, format, string(schema), refs...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s schema: %s", format, err)
 	}
 	return id, nil
+}
 
+// Subject returns the subject of the key or value schema of topic under the
+// subject name strategy of the registry
+func (s *SchemaRegistry) Subject(topic string, isKey bool, format Format, schema string) (string, error) {
+	return subjectName(s.strategy, topic, isKey, format, schema)
 }
 
-func (s *SchemaRegistry) createAvroSchema(topic string, schema []byte) (int, error) {
-	id, err := utils.Retry(retryCount, retryInterval, func() (int, error) {
-		return s.client.Register(topic+"-value", schemaregistry.SchemaInfo{
-			Schema: string(schema),
-		}, false)
+// RegisterSchema registers schema of format under subject and returns its ID.
+// refs are the schemas it imports, registered beforehand; Name is the name
+// the schema refers to them by, e.g. a Protobuf import path or an Avro
+// record name.
+func (s *SchemaRegistry) RegisterSchema(subject string, format Format, schema string, refs ...schemaregistry.Reference) (int, error) {
+	info := registrySchema(format, schema)
+	info.References = refs
+	id, err := utils.RetryIf(retryCount, retryInterval, isTransientRegistryError, func() (int, error) {
+		return s.client.Register(subject, info, false)
 	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to register schema: %s", err)
@@ -72,6 +118,123 @@ func (s *SchemaRegistry) createAvroSchema(topic string, schema []byte) (int, err
 	return id, nil
 }
 
+// CheckCompatibility reports whether schema can be registered under subject
+// given the compatibility level of the subject. A subject without versions
+// accepts any schema.
+func (s *SchemaRegistry) CheckCompatibility(subject string, format Format, schema string, refs ...schemaregistry.Reference) (bool, error) {
+	info := registrySchema(format, schema)
+	info.References = refs
+	ok, err := s.client.TestCompatibility(subject, -1, info) // -1 is the latest version
+	if err != nil {
+		if isSubjectNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to test compatibility with %s: %w", subject, err)
+	}
+	return ok, nil
+}
+
+// SetCompatibility sets the compatibility level of subject, or the global
+// level if subject is empty
+func (s *SchemaRegistry) SetCompatibility(subject string, level schemaregistry.Compatibility) error {
+	var err error
+	if subject == "" {
+		_, err = s.client.UpdateDefaultCompatibility(level)
+	} else {
+		_, err = s.client.UpdateCompatibility(subject, level)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set the compatibility of %q: %w", subject, err)
+	}
+	return nil
+}
+
+// Compatibility returns the compatibility level of subject, or the global
+// level if subject is empty or has none of its own
+func (s *SchemaRegistry) Compatibility(subject string) (schemaregistry.Compatibility, error) {
+	if subject != "" {
+		level, err := s.client.GetCompatibility(subject)
+		if err == nil {
+			return level, nil
+		}
+		if !isSubjectNotFound(err) {
+			return level, fmt.Errorf("failed to get the compatibility of %s: %w", subject, err)
+		}
+	}
+	level, err := s.client.GetDefaultCompatibility()
+	if err != nil {
+		return level, fmt.Errorf("failed to get the global compatibility: %w", err)
+	}
+	return level, nil
+}
+
+// Subjects returns the registered subjects
+func (s *SchemaRegistry) Subjects() ([]string, error) {
+	subjects, err := s.client.GetAllSubjects()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subjects: %w", err)
+	}
+	return subjects, nil
+}
+
+// Versions returns the versions of subject
+func (s *SchemaRegistry) Versions(subject string) ([]int, error) {
+	versions, err := s.client.GetAllVersions(subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the versions of %s: %w", subject, err)
+	}
+	return versions, nil
+}
+
+// GetSchema returns version of subject, or its latest version if version is
+// -1
+func (s *SchemaRegistry) GetSchema(subject string, version int) (schemaregistry.SchemaMetadata, error) {
+	var (
+		meta schemaregistry.SchemaMetadata
+		err  error
+	)
+	if version == -1 {
+		meta, err = s.client.GetLatestSchemaMetadata(subject)
+	} else {
+		meta, err = s.client.GetSchemaMetadata(subject, version)
+	}
+	if err != nil {
+		return meta, fmt.Errorf("failed to get version %d of %s: %w", version, subject, err)
+	}
+	return meta, nil
+}
+
+// schemaByID returns the schema with id.
+func (s *SchemaRegistry) schemaByID(id int) (string, error) {
+	versions, err := s.client.GetSubjectsAndVersionsByID(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the subjects of schema %d: %w", id, err)
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("schema %d is not registered", id)
+	}
+	meta, err := s.client.GetSchemaMetadata(versions[0].Subject, versions[0].Version)
+	if err != nil {
+		return "", fmt.Errorf("failed to get schema %d: %w", id, err)
+	}
+	return meta.Schema, nil
+}
+
+// isTransientRegistryError reports whether a registry request failing with
+// err may succeed when retried: rejections such as an incompatible or invalid
+// schema are final.
+func isTransientRegistryError(err error) bool {
+	var rerr *rest.Error
+	return !errors.As(err, &rerr) || rerr.Code >= 50000 || rerr.Code == 429
+}
+
+// isSubjectNotFound reports whether err is the registry's answer for an
+// unknown subject or a subject without versions.
+func isSubjectNotFound(err error) bool {
+	var rerr *rest.Error
+	return errors.As(err, &rerr) && (rerr.Code == 40401 || rerr.Code == 40402 || rerr.Code == 40408)
+}
+
 func (s *SchemaRegistry) CreateAvroSerializer(schemaConfig avrov2.SerializerConfig) (*avrov2.Serializer, error) {
 	serde, err := utils.Retry(retryCount, retryInterval, func() (*avrov2.Serializer, error) {
 		return avrov2.NewSerializer(s.client, serde.ValueSerde, &schemaConfig)
@@ -2,42 +2,139 @@ package event
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
-	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/avrov2"
+	"github.com/solum-sp/aps-be-common/common/errorx"
 	"github.com/solum-sp/aps-be-common/common/utils"
 )
 
 type kafkaSubscriber struct {
 	consumer *kafka.Consumer
-	serde    serde.Deserializer
+	serde    Deserializer
+	format   Format
 	topic    string
+	metrics  *Metrics
 }
 
-var _ ISubscriber = (*kafkaSubscriber)(nil)
+var _ IBatchConsumer = (*kafkaSubscriber)(nil)
 
-func NewKafkaSubscriber(consumer *kafka.Consumer, sr *SchemaRegistry, topic string) (*kafkaSubscriber, error) {
-	serde, err := avrov2.NewDeserializer(sr.client, serde.ValueSerde, &avrov2.DeserializerConfig{
-		DeserializerConfig: serde.DeserializerConfig{},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create avro serializer: %s", err)
+// SubscriberOption is a functional option for configuring a subscriber
+type SubscriberOption func(*kafkaSubscriber)
+
+// WithSubscriberFormat sets the encoding of the values, FormatAvro by default
+func WithSubscriberFormat(format Format) SubscriberOption {
+	return func(s *kafkaSubscriber) {
+		s.format = format
+	}
+}
+
+// WithDeserializer sets the deserializer of the values, overriding the format
+func WithDeserializer(deserializer Deserializer) SubscriberOption {
+	return func(s *kafkaSubscriber) {
+		s.serde = deserializer
+	}
+}
+
+// WithSubscriberMetrics records the metrics of the subscriber in m, see
+// WithConsumeMetrics.
+func WithSubscriberMetrics(m *Metrics) SubscriberOption {
+	return func(s *kafkaSubscriber) {
+		s.metrics = m
+	}
+}
+
+func NewKafkaSubscriber(consumer *kafka.Consumer, sr *SchemaRegistry, topic string, opts ...SubscriberOption) (*kafkaSubscriber, error) {
+	s := &kafkaSubscriber{consumer: consumer, format: FormatAvro, topic: topic}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.serde == nil {
+		deserializer, err := NewDeserializer(sr, s.format)
+		if err != nil {
+			return nil, err
+		}
+		s.serde = deserializer
 	}
-	return &kafkaSubscriber{consumer: consumer, serde: serde, topic: topic}, nil
+	return s, nil
 }
 
 func (s *kafkaSubscriber) SubscribeToTopic(ctx context.Context) error {
 	err := s.consumer.SubscribeTopics([]string{s.topic}, nil)
 	if err != nil {
-		return fmt.Errorf("failed to subscribe to topic: %s", err)
+		return classify(fmt.Errorf("failed to subscribe to topic: %w", err))
 	}
 	return nil
 }
 
+// ReadMessage waits for the next message of the subscribed topics. The
+// returned context derives from ctx and carries the trace context, request
+// ID and tenant ID found in the message headers. The message is not
+// committed; call CommitMessage once it is processed.
+func (s *kafkaSubscriber) ReadMessage(ctx context.Context) (context.Context, Message, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return ctx, Message{}, err
+		}
+		msg, err := s.consumer.ReadMessage(100 * time.Millisecond)
+		if err != nil {
+			var kerr kafka.Error
+			if errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut {
+				continue
+			}
+			return ctx, Message{}, classify(fmt.Errorf("consumer read error: %w", err))
+		}
+		m := s.newMessage(msg)
+		if s.metrics != nil {
+			s.metrics.ObserveConsumed(m.Topic)
+		}
+		return ContextFromHeaders(ctx, m.Headers), m, nil
+	}
+}
+
+// CommitMessage commits the offset following msg.
+func (s *kafkaSubscriber) CommitMessage(msg Message) error {
+	if _, err := s.consumer.CommitMessage(msg.raw); err != nil {
+		if s.metrics != nil {
+			s.metrics.ObserveCommitFailure()
+		}
+		return classify(fmt.Errorf("offset commit error: %w", err))
+	}
+	return nil
+}
+
+func (s *kafkaSubscriber) newMessage(msg *kafka.Message) Message {
+	m := Message{
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   fromKafkaHeaders(msg.Headers),
+		Timestamp: msg.Timestamp,
+		raw:       msg,
+	}
+	if msg.TopicPartition.Topic != nil {
+		m.Topic = *msg.TopicPartition.Topic
+	}
+	m.decode = func(value []byte, v interface{}) error {
+		if err := s.serde.DeserializeInto(m.Topic, value, v); err != nil {
+			return errorx.Tag(fmt.Errorf("deserialization error: %w", err), errorx.ClassValidation)
+		}
+		return nil
+	}
+	return m
+}
+
+// ConsumeMessages delivers the messages of the topic one at a time and
+// waits for a value on the commit channel after each of them: true commits
+// the message, false leaves it uncommitted. The message and error channels
+// are closed once ctx is done; the commit channel is owned by the caller.
+//
+// Deprecated: use Consume, which commits, retries and runs partitions in
+// parallel.
 func (s *kafkaSubscriber) ConsumeMessages(
 	ctx context.Context,
 	msgTypeConstructor func() ConsumerMessage,
@@ -48,7 +145,15 @@ func (s *kafkaSubscriber) ConsumeMessages(
 	go func() {
 		defer close(chMsg)
 		defer close(chErr)
-		defer close(chCommitRequest)
+
+		sendErr := func(err error) bool {
+			select {
+			case chErr <- err:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
 
 		for {
 			select {
@@ -57,12 +162,15 @@ func (s *kafkaSubscriber) ConsumeMessages(
 			default:
 				msg, err := s.consumer.ReadMessage(100 * time.Millisecond)
 				if err != nil {
-					if err.(kafka.Error).Code() == kafka.ErrTimedOut {
+					var kerr kafka.Error
+					if errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut {
 						continue // Normal timeout, just retry
 					}
 
 					// Log and potentially retry or send to error channel
-					chErr <- fmt.Errorf("consumer read error: %v", err)
+					if !sendErr(classify(fmt.Errorf("consumer read error: %w", err))) {
+						return
+					}
 					continue
 				}
 
@@ -70,17 +178,32 @@ func (s *kafkaSubscriber) ConsumeMessages(
 				msgObj := msgTypeConstructor()
 				err = s.serde.DeserializeInto(s.topic, msg.Value, &msgObj)
 				if err != nil {
-					chErr <- fmt.Errorf("deserialization error: %v", err)
+					if !sendErr(errorx.Tag(fmt.Errorf("deserialization error: %w", err), errorx.ClassValidation)) {
+						return
+					}
 					continue
 				}
+				if setter, ok := msgObj.(ContextSetter); ok {
+					setter.SetContext(ContextFromHeaders(ctx, fromKafkaHeaders(msg.Headers)))
+				}
 				log.Printf("Message on Topic: %s, Offset: %+v\n", *msg.TopicPartition.Topic, msg.TopicPartition.Offset)
-				chMsg <- msgObj
+				select {
+				case chMsg <- msgObj:
+				case <-ctx.Done():
+					return
+				}
 
 				// Manual offset commit
-				if <-chCommitRequest {
+				var commit bool
+				select {
+				case commit = <-chCommitRequest:
+				case <-ctx.Done():
+					return
+				}
+				if commit {
 					_, err := s.consumer.CommitMessage(msg)
-					if err != nil {
-						chErr <- fmt.Errorf("offset commit error: %v", err)
+					if err != nil && !sendErr(classify(fmt.Errorf("offset commit error: %w", err))) {
+						return
 					}
 				}
 			}
@@ -120,18 +243,7 @@ func NewKafkaConsumer(opts ...KafkaOption) (*kafka.Consumer, error) {
 	}
 
 	c, err := utils.Retry(10, 1*time.Second, func() (*kafka.Consumer, error) {
-		return kafka.NewConsumer(&kafka.ConfigMap{
-			"bootstrap.servers":     consumerConfig.Brokers,
-			"group.id":              consumerConfig.GroupID,
-			"auto.offset.reset":     consumerConfig.AutoOffsetReset,
-			"enable.auto.commit":    consumerConfig.EnableAutoCommit,
-			"max.poll.interval.ms":  consumerConfig.MaxPollIntervalMs,
-			"session.timeout.ms":    consumerConfig.SessionTimeoutMs,
-			"heartbeat.interval.ms": consumerConfig.HeartbeatIntervalMs,
-			"retry.backoff.ms":      consumerConfig.RetryBackoffMs,
-			"fetch.min.bytes":       consumerConfig.FetchMinBytes,
-			"fetch.wait.max.ms":     consumerConfig.FetchWaitMaxMs,
-		})
+		return kafka.NewConsumer(consumerConfig.configMap())
 	})
 	if err != nil {
 		log.Printf("Failed to create kafka consumer: %s", err)
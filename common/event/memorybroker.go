@@ -0,0 +1,673 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+MemoryBroker is an in-process stand-in for Kafka and the schema registry, so
+code using IPublisher and ISubscriber can be tested hermetically. Values are
+encoded as JSON. Topics have partitions, subscribers sharing a group ID split
+the partitions of their topics, and a group resumes from its committed
+offsets.
+
+USAGE EXAMPLE:
+
+	broker := event.NewMemoryBroker(event.WithMemoryPartitions(3))
+	svc := NewOrderService(broker.Publisher("orders"))
+
+	svc.CreateOrder(ctx, "order-1")
+
+	msg := broker.ExpectPublished(t, "orders", func(m event.Message) bool {
+		return string(m.Key) == "order-1"
+	})
+	var evt OrderCreated
+	require.NoError(t, msg.Decode(&evt))
+
+	sub := broker.Subscriber("orders", "billing")
+	go sub.Consume(ctx, billing.Handle)
+*/
+
+// MemoryBroker is an in-memory message broker for tests.
+type MemoryBroker struct {
+	partitions int
+
+	mu     sync.Mutex
+	topics map[string][][]*kafka.Message
+	groups map[string]*memoryGroup
+	notify chan struct{} // Closed and replaced on every change
+	nextRR map[string]int
+}
+
+type memoryGroup struct {
+	committed map[partitionKey]int64
+	members   []*MemorySubscriber // In join order
+}
+
+// MemoryBrokerOption is a functional option for configuring a MemoryBroker
+type MemoryBrokerOption func(*MemoryBroker)
+
+// TestingT is the part of testing.TB used by the assertions of MemoryBroker.
+type TestingT interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+// WithMemoryPartitions sets the number of partitions of the topics created
+// on first use, 1 by default
+func WithMemoryPartitions(n int) MemoryBrokerOption {
+	return func(b *MemoryBroker) {
+		b.partitions = n
+	}
+}
+
+func NewMemoryBroker(opts ...MemoryBrokerOption) *MemoryBroker {
+	b := &MemoryBroker{
+		partitions: 1,
+		topics:     make(map[string][][]*kafka.Message),
+		groups:     make(map[string]*memoryGroup),
+		notify:     make(chan struct{}),
+		nextRR:     make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.partitions < 1 {
+		b.partitions = 1
+	}
+	return b
+}
+
+// CreateTopic creates topic with the given number of partitions, at least
+// one. Topics are otherwise created on first use. Creating an existing topic
+// fails if its number of partitions differs.
+func (b *MemoryBroker) CreateTopic(topic string, partitions int) error {
+	if partitions < 1 {
+		return errorx.Tag(fmt.Errorf("topic %s needs at least one partition, got %d", topic, partitions), errorx.ClassValidation)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if parts, ok := b.topics[topic]; ok {
+		if len(parts) != partitions {
+			return errorx.Tag(fmt.Errorf("topic %s already exists with %d partitions, not %d", topic, len(parts), partitions), errorx.ClassConflict)
+		}
+		return nil
+	}
+	b.topics[topic] = make([][]*kafka.Message, partitions)
+	b.changed()
+	return nil
+}
+
+// topic returns the partitions of topic, creating it if needed. b.mu must
+// be held.
+func (b *MemoryBroker) topic(topic string) [][]*kafka.Message {
+	parts, ok := b.topics[topic]
+	if !ok {
+		parts = make([][]*kafka.Message, b.partitions)
+		b.topics[topic] = parts
+		b.changed()
+	}
+	return parts
+}
+
+// changed wakes up the waiting consumers. b.mu must be held.
+func (b *MemoryBroker) changed() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Produce appends msg to its topic, so the broker can be used as the
+// MessageProducer of a retry policy. A delivery report is sent on
+// deliveryChan if it is not nil.
+func (b *MemoryBroker) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	if msg.TopicPartition.Topic == nil {
+		return kafka.NewError(kafka.ErrInvalidArg, "message has no topic", false)
+	}
+	b.mu.Lock()
+	topic := *msg.TopicPartition.Topic
+	parts := b.topic(topic)
+	p := msg.TopicPartition.Partition
+	switch {
+	case p == kafka.PartitionAny && msg.Key != nil:
+		p = HashPartitioner.Partition(&ProducerMessage{Key: msg.Key}, len(parts))
+	case p == kafka.PartitionAny:
+		p = int32(b.nextRR[topic] % len(parts))
+		b.nextRR[topic]++
+	case p < 0 || int(p) >= len(parts):
+		b.mu.Unlock()
+		return kafka.NewError(kafka.ErrUnknownPartition, fmt.Sprintf("topic %s has no partition %d", topic, p), false)
+	}
+	stored := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: p, Offset: kafka.Offset(len(parts[p]))},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        msg.Headers,
+		Timestamp:      msg.Timestamp,
+		Opaque:         msg.Opaque,
+	}
+	if stored.Timestamp.IsZero() {
+		stored.Timestamp = time.Now()
+	}
+	parts[p] = append(parts[p], stored)
+	b.changed()
+	b.mu.Unlock()
+
+	if deliveryChan != nil {
+		deliveryChan <- stored
+	}
+	return nil
+}
+
+// Messages returns the messages published to topic, in partition order.
+func (b *MemoryBroker) Messages(topic string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var msgs []Message
+	for _, part := range b.topics[topic] {
+		for _, km := range part {
+			msgs = append(msgs, newMemoryMessage(km))
+		}
+	}
+	return msgs
+}
+
+// Topics returns the names of the topics of the broker, sorted.
+func (b *MemoryBroker) Topics() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	topics := make([]string, 0, len(b.topics))
+	for topic := range b.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Committed returns the offset committed by group for a partition, or -1.
+func (b *MemoryBroker) Committed(group, topic string, partition int32) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[group]
+	if !ok {
+		return -1
+	}
+	offset, ok := g.committed[partitionKey{topic: topic, partition: partition}]
+	if !ok {
+		return -1
+	}
+	return offset
+}
+
+// ExpectPublished fails t unless a message accepted by match, or any message
+// if match is nil, was published to topic, and returns the first one.
+func (b *MemoryBroker) ExpectPublished(t TestingT, topic string, match func(Message) bool) Message {
+	t.Helper()
+	msgs := b.Messages(topic)
+	for _, msg := range msgs {
+		if match == nil || match(msg) {
+			return msg
+		}
+	}
+	t.Fatalf("no matching message published to %s among %d", topic, len(msgs))
+	return Message{}
+}
+
+// WaitForMessages waits until at least n messages were published to topic
+// and returns them, failing t after timeout.
+func (b *MemoryBroker) WaitForMessages(t TestingT, topic string, n int, timeout time.Duration) []Message {
+	t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		b.mu.Lock()
+		count := 0
+		for _, part := range b.topics[topic] {
+			count += len(part)
+		}
+		notify := b.notify
+		b.mu.Unlock()
+		if count >= n {
+			return b.Messages(topic)
+		}
+
+		select {
+		case <-notify:
+		case <-deadline.C:
+			t.Fatalf("got %d messages on %s after %s, want %d", count, topic, timeout, n)
+			return nil
+		}
+	}
+}
+
+// Publisher returns a publisher of topic. Keyed messages are partitioned
+// with HashPartitioner, the others round-robin.
+func (b *MemoryBroker) Publisher(topic string) *MemoryPublisher {
+	return &MemoryPublisher{broker: b, topic: topic}
+}
+
+// Subscriber returns a subscriber of topic in the consumer group groupID.
+func (b *MemoryBroker) Subscriber(topic, groupID string) *MemorySubscriber {
+	return &MemorySubscriber{
+		broker:    b,
+		topic:     topic,
+		group:     groupID,
+		positions: make(map[partitionKey]int64),
+		paused:    make(map[partitionKey]bool),
+	}
+}
+
+// MemoryPublisher publishes to a MemoryBroker.
+type MemoryPublisher struct {
+	broker *MemoryBroker
+	topic  string
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+var _ IAsyncPublisher = (*MemoryPublisher)(nil)
+
+func (p *MemoryPublisher) SendMessage(ctx context.Context, value interface{}) error {
+	return p.Publish(ctx, ProducerMessage{Value: value})
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, msg ProducerMessage) error {
+	_, err := p.PublishAsync(ctx, msg).Wait(ctx)
+	return err
+}
+
+func (p *MemoryPublisher) SendMessageAsync(ctx context.Context, value interface{}, callbacks ...func(DeliveryReport)) *DeliveryFuture {
+	return p.PublishAsync(ctx, ProducerMessage{Value: value}, callbacks...)
+}
+
+// PublishAsync stores msg right away; the returned future is already
+// complete.
+func (p *MemoryPublisher) PublishAsync(ctx context.Context, msg ProducerMessage, callbacks ...func(DeliveryReport)) *DeliveryFuture {
+	f := newDeliveryFuture(callbacks)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		f.complete(DeliveryReport{Topic: p.topic, Err: ErrPublisherClosed})
+		return f
+	}
+
+	payload, err := json.Marshal(msg.Value)
+	if err != nil {
+		f.complete(DeliveryReport{Topic: p.topic, Err: errorx.Tag(fmt.Errorf("failed to serialize: %w", err), errorx.ClassValidation)})
+		return f
+	}
+	partition := kafka.PartitionAny
+	if msg.Partition != nil {
+		partition = *msg.Partition
+	}
+	deliveries := make(chan kafka.Event, 1)
+	err = p.broker.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &p.topic, Partition: partition},
+		Key:            msg.Key,
+		Value:          payload,
+		Headers:        toKafkaHeaders(InjectHeaders(ctx, eventHeaders(msg.Value, msg.Headers))),
+		Timestamp:      msg.Timestamp,
+	}, deliveries)
+	if err != nil {
+		f.complete(DeliveryReport{Topic: p.topic, Err: classify(fmt.Errorf("produce failed: %w", err))})
+		return f
+	}
+	m := (<-deliveries).(*kafka.Message)
+	f.complete(DeliveryReport{Topic: p.topic, Partition: m.TopicPartition.Partition, Offset: int64(m.TopicPartition.Offset)})
+	return f
+}
+
+func (p *MemoryPublisher) Flush(context.Context) error {
+	return nil
+}
+
+func (p *MemoryPublisher) Close(context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// MemorySubscriber consumes from a MemoryBroker. It implements the same
+// commit semantics as the Kafka subscriber: a group resumes from its last
+// committed offsets, and uncommitted messages are delivered again to the
+// member taking over their partition.
+type MemorySubscriber struct {
+	broker *MemoryBroker
+	topic  string
+	group  string
+
+	// Guarded by broker.mu
+	topics    []string
+	cb        kafka.RebalanceCb
+	assigned  []partitionKey
+	positions map[partitionKey]int64
+	paused    map[partitionKey]bool
+	next      int
+}
+
+var (
+	_ IBatchConsumer = (*MemorySubscriber)(nil)
+	_ consumerClient = (*MemorySubscriber)(nil)
+)
+
+func (s *MemorySubscriber) SubscribeToTopic(context.Context) error {
+	return s.SubscribeTopics([]string{s.topic}, nil)
+}
+
+// SubscribeTopics joins the group of the subscriber for topics. Partitions
+// are assigned on the next Poll.
+func (s *MemorySubscriber) SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, topic := range topics {
+		b.topic(topic)
+	}
+	g, ok := b.groups[s.group]
+	if !ok {
+		g = &memoryGroup{committed: make(map[partitionKey]int64)}
+		b.groups[s.group] = g
+	}
+	if s.topics == nil {
+		g.members = append(g.members, s)
+	}
+	s.topics = append([]string(nil), topics...)
+	s.cb = rebalanceCb
+	b.changed()
+	return nil
+}
+
+// Close leaves the group; the partitions of the subscriber go to the other
+// members.
+func (s *MemorySubscriber) Close() error {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if g, ok := b.groups[s.group]; ok {
+		for i, m := range g.members {
+			if m == s {
+				g.members = append(g.members[:i], g.members[i+1:]...)
+				break
+			}
+		}
+	}
+	s.topics = nil
+	s.assigned = nil
+	b.changed()
+	return nil
+}
+
+// assignment returns the partitions of s: the partitions of each topic are
+// spread over the members subscribed to it, in join order. b.mu must be held.
+func (s *MemorySubscriber) assignment() []partitionKey {
+	b := s.broker
+	g := b.groups[s.group]
+	var assigned []partitionKey
+	for _, topic := range s.topics {
+		var members []*MemorySubscriber
+		for _, m := range g.members {
+			for _, t := range m.topics {
+				if t == topic {
+					members = append(members, m)
+					break
+				}
+			}
+		}
+		for p := range b.topics[topic] {
+			if members[p%len(members)] == s {
+				assigned = append(assigned, partitionKey{topic: topic, partition: int32(p)})
+			}
+		}
+	}
+	return assigned
+}
+
+// rebalance applies the current assignment, calling the rebalance callback
+// with the revoked and the newly assigned partitions.
+func (s *MemorySubscriber) rebalance() {
+	b := s.broker
+	b.mu.Lock()
+	if s.topics == nil {
+		b.mu.Unlock()
+		return
+	}
+	current := s.assignment()
+	keep := make(map[partitionKey]bool, len(current))
+	for _, k := range current {
+		keep[k] = true
+	}
+	var revoked, added []kafka.TopicPartition
+	old := make(map[partitionKey]bool, len(s.assigned))
+	for _, k := range s.assigned {
+		old[k] = true
+		if !keep[k] {
+			revoked = append(revoked, memoryTopicPartition(k, kafka.OffsetInvalid))
+			delete(s.positions, k)
+			delete(s.paused, k)
+		}
+	}
+	g := b.groups[s.group]
+	for _, k := range current {
+		if !old[k] {
+			offset, ok := g.committed[k]
+			if !ok {
+				offset = 0
+			}
+			s.positions[k] = offset
+			added = append(added, memoryTopicPartition(k, kafka.Offset(offset)))
+		}
+	}
+	s.assigned = current
+	cb := s.cb
+	b.mu.Unlock()
+
+	if cb == nil {
+		return
+	}
+	if len(revoked) > 0 {
+		_ = cb(nil, kafka.RevokedPartitions{Partitions: revoked})
+	}
+	if len(added) > 0 {
+		_ = cb(nil, kafka.AssignedPartitions{Partitions: added})
+	}
+}
+
+// Poll returns the next message of the assigned partitions, or nil if none
+// arrived within timeoutMs.
+func (s *MemorySubscriber) Poll(timeoutMs int) kafka.Event {
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	defer timer.Stop()
+	for {
+		s.rebalance()
+
+		b := s.broker
+		b.mu.Lock()
+		if msg := s.fetch(); msg != nil {
+			b.mu.Unlock()
+			return msg
+		}
+		notify := b.notify
+		b.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// fetch returns the next message of the assigned partitions, rotating
+// between them. b.mu must be held.
+func (s *MemorySubscriber) fetch() *kafka.Message {
+	n := len(s.assigned)
+	for i := 0; i < n; i++ {
+		k := s.assigned[(s.next+i)%n]
+		if s.paused[k] {
+			continue
+		}
+		part := s.broker.topics[k.topic][k.partition]
+		if pos := s.positions[k]; pos < int64(len(part)) {
+			s.positions[k] = pos + 1
+			s.next = (s.next + i + 1) % n
+			return part[pos]
+		}
+	}
+	return nil
+}
+
+func (s *MemorySubscriber) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[s.group]
+	if !ok {
+		return nil, kafka.NewError(kafka.ErrUnknownMemberID, "subscriber is not in a group", false)
+	}
+	for _, tp := range offsets {
+		if tp.Topic == nil {
+			continue
+		}
+		g.committed[partitionKey{topic: *tp.Topic, partition: tp.Partition}] = int64(tp.Offset)
+	}
+	return offsets, nil
+}
+
+func (s *MemorySubscriber) Pause(partitions []kafka.TopicPartition) error {
+	s.setPaused(partitions, true)
+	return nil
+}
+
+func (s *MemorySubscriber) Resume(partitions []kafka.TopicPartition) error {
+	s.setPaused(partitions, false)
+	return nil
+}
+
+func (s *MemorySubscriber) setPaused(partitions []kafka.TopicPartition, paused bool) {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, tp := range partitions {
+		if tp.Topic != nil {
+			s.paused[partitionKey{topic: *tp.Topic, partition: tp.Partition}] = paused
+		}
+	}
+	b.changed()
+}
+
+// ReadMessage subscribes to the topic if needed and waits for the next
+// message, like the Kafka subscriber.
+func (s *MemorySubscriber) ReadMessage(ctx context.Context) (context.Context, Message, error) {
+	if err := s.ensureSubscribed(); err != nil {
+		return ctx, Message{}, err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return ctx, Message{}, err
+		}
+		if km, ok := s.Poll(100).(*kafka.Message); ok {
+			msg := newMemoryMessage(km)
+			return ContextFromHeaders(ctx, msg.Headers), msg, nil
+		}
+	}
+}
+
+func (s *MemorySubscriber) CommitMessage(msg Message) error {
+	_, err := s.CommitOffsets([]kafka.TopicPartition{{Topic: &msg.Topic, Partition: msg.Partition, Offset: kafka.Offset(msg.Offset + 1)}})
+	return err
+}
+
+// Consume runs the same runtime as the Kafka subscriber on the broker.
+func (s *MemorySubscriber) Consume(ctx context.Context, handler Handler, opts ...ConsumeOption) error {
+	return consume(ctx, s, []string{s.topic}, newMemoryMessage, handler, opts...)
+}
+
+// ConsumeMessages behaves like the Kafka subscriber's.
+//
+// Deprecated: use Consume.
+func (s *MemorySubscriber) ConsumeMessages(ctx context.Context, msgTypeConstructor func() ConsumerMessage) (<-chan ConsumerMessage, <-chan error, chan<- bool) {
+	chMsg := make(chan ConsumerMessage)
+	chCommitRequest := make(chan bool)
+	chErr := make(chan error)
+	go func() {
+		defer close(chMsg)
+		defer close(chErr)
+		for {
+			msgCtx, msg, err := s.ReadMessage(ctx)
+			if err != nil {
+				return
+			}
+			msgObj := msgTypeConstructor()
+			if err := msg.Decode(msgObj); err != nil {
+				select {
+				case chErr <- err:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+			if setter, ok := msgObj.(ContextSetter); ok {
+				setter.SetContext(msgCtx)
+			}
+			select {
+			case chMsg <- msgObj:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case commit := <-chCommitRequest:
+				if commit {
+					_ = s.CommitMessage(msg)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return chMsg, chErr, chCommitRequest
+}
+
+func (s *MemorySubscriber) ensureSubscribed() error {
+	s.broker.mu.Lock()
+	subscribed := s.topics != nil
+	s.broker.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+	return s.SubscribeTopics([]string{s.topic}, nil)
+}
+
+func memoryTopicPartition(k partitionKey, offset kafka.Offset) kafka.TopicPartition {
+	topic := k.topic
+	return kafka.TopicPartition{Topic: &topic, Partition: k.partition, Offset: offset}
+}
+
+// newMemoryMessage converts a stored message; its value decodes as JSON.
+func newMemoryMessage(km *kafka.Message) Message {
+	msg := Message{
+		Topic:     *km.TopicPartition.Topic,
+		Partition: km.TopicPartition.Partition,
+		Offset:    int64(km.TopicPartition.Offset),
+		Key:       km.Key,
+		Value:     km.Value,
+		Headers:   fromKafkaHeaders(km.Headers),
+		Timestamp: km.Timestamp,
+		raw:       km,
+	}
+	msg.decode = func(value []byte, v interface{}) error {
+		if err := json.Unmarshal(value, v); err != nil {
+			return errorx.Tag(fmt.Errorf("deserialization error: %w", err), errorx.ClassValidation)
+		}
+		return nil
+	}
+	return msg
+}
@@ -0,0 +1,186 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/solum-sp/aps-be-common/common/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBrokerPublishAndRead(t *testing.T) {
+	broker := NewMemoryBroker()
+	publisher := broker.Publisher("orders")
+	ctx := utils.WithRequestID(context.Background(), "req-1")
+
+	require.NoError(t, publisher.Publish(ctx, ProducerMessage{Key: []byte("order-1"), Value: orderCreated{ID: "order-1", Total: 10}}))
+
+	msg := broker.ExpectPublished(t, "orders", func(m Message) bool { return string(m.Key) == "order-1" })
+	var evt orderCreated
+	require.NoError(t, msg.Decode(&evt))
+	assert.Equal(t, orderCreated{ID: "order-1", Total: 10}, evt)
+
+	sub := broker.Subscriber("orders", "billing")
+	msgCtx, got, err := sub.ReadMessage(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), got.Offset)
+	assert.Equal(t, "req-1", utils.RequestIDFromContext(msgCtx))
+	require.NoError(t, sub.CommitMessage(got))
+	assert.Equal(t, int64(1), broker.Committed("billing", "orders", 0))
+}
+
+// contextOrder keeps the context ConsumeMessages sets.
+type contextOrder struct {
+	orderCreated
+	ctx context.Context
+}
+
+func (o *contextOrder) SetContext(ctx context.Context) { o.ctx = ctx }
+
+func TestMemoryBrokerConsumeMessagesSetsContext(t *testing.T) {
+	broker := NewMemoryBroker()
+	ctx := utils.WithTenantID(utils.WithRequestID(context.Background(), "req-1"), "tenant-1")
+	require.NoError(t, broker.Publisher("orders").Publish(ctx, ProducerMessage{Value: orderCreated{ID: "order-1"}}))
+
+	consumeCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chMsg, _, chCommit := broker.Subscriber("orders", "billing").ConsumeMessages(consumeCtx, func() ConsumerMessage { return &contextOrder{} })
+	got := (<-chMsg).(*contextOrder)
+	chCommit <- true
+	assert.Equal(t, "order-1", got.ID)
+	require.NotNil(t, got.ctx)
+	assert.Equal(t, "req-1", utils.RequestIDFromContext(got.ctx))
+	assert.Equal(t, "tenant-1", utils.TenantIDFromContext(got.ctx))
+}
+
+func TestMemoryBrokerResumesFromCommittedOffset(t *testing.T) {
+	broker := NewMemoryBroker()
+	publisher := broker.Publisher("orders")
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, publisher.SendMessage(context.Background(), orderCreated{ID: id}))
+	}
+
+	first := broker.Subscriber("orders", "billing")
+	_, msg, err := first.ReadMessage(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, first.CommitMessage(msg))
+	_, _, err = first.ReadMessage(context.Background()) // Read, not committed
+	require.NoError(t, err)
+	require.NoError(t, first.Close())
+
+	second := broker.Subscriber("orders", "billing")
+	_, msg, err = second.ReadMessage(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), msg.Offset, "uncommitted message delivered again")
+
+	other := broker.Subscriber("orders", "shipping")
+	_, msg, err = other.ReadMessage(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), msg.Offset, "groups are independent")
+}
+
+func TestMemoryBrokerCreateTopic(t *testing.T) {
+	broker := NewMemoryBroker(WithMemoryPartitions(0))
+	err := broker.CreateTopic("orders", 0)
+	assert.True(t, errorx.IsValidation(err))
+
+	require.NoError(t, broker.CreateTopic("payments", 2))
+	require.NoError(t, broker.CreateTopic("payments", 2))
+	err = broker.CreateTopic("payments", 3)
+	assert.True(t, errorx.IsConflict(err))
+	assert.ErrorContains(t, err, "topic payments already exists with 2 partitions, not 3")
+
+	publisher := broker.Publisher("orders")
+	require.NoError(t, publisher.SendMessage(context.Background(), orderCreated{ID: "order-1"}))
+	assert.Len(t, broker.Messages("orders"), 1)
+}
+
+func TestMemoryBrokerSplitsPartitionsInGroup(t *testing.T) {
+	broker := NewMemoryBroker(WithMemoryPartitions(4))
+	a := broker.Subscriber("orders", "billing")
+	b := broker.Subscriber("orders", "billing")
+	require.NoError(t, a.SubscribeToTopic(context.Background()))
+	require.NoError(t, b.SubscribeToTopic(context.Background()))
+	a.rebalance()
+	b.rebalance()
+
+	assert.Len(t, a.assigned, 2)
+	assert.Len(t, b.assigned, 2)
+	assert.NotEqual(t, a.assigned, b.assigned)
+
+	require.NoError(t, b.Close())
+	a.rebalance()
+	assert.Len(t, a.assigned, 4)
+}
+
+func TestMemoryBrokerConsume(t *testing.T) {
+	broker := NewMemoryBroker(WithMemoryPartitions(3))
+	invoices := broker.Publisher("invoices")
+	sub := broker.Subscriber("orders", "billing")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.Consume(ctx, func(ctx context.Context, msg Message) error {
+			var evt orderCreated
+			if err := msg.Decode(&evt); err != nil {
+				return err
+			}
+			return invoices.Publish(ctx, ProducerMessage{Key: msg.Key, Value: evt})
+		}, WithConsumeConcurrency(3))
+	}()
+
+	orders := broker.Publisher("orders")
+	for _, id := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, orders.Publish(context.Background(), ProducerMessage{Key: []byte(id), Value: orderCreated{ID: id}}))
+	}
+	published := broker.WaitForMessages(t, "invoices", 4, time.Second)
+	cancel()
+	require.NoError(t, <-done)
+
+	var keys []string
+	for _, msg := range published {
+		keys = append(keys, string(msg.Key))
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d"}, keys)
+	ends := make(map[int32]int64)
+	for _, msg := range broker.Messages("orders") {
+		ends[msg.Partition] = msg.Offset + 1
+	}
+	for partition, end := range ends {
+		assert.Equal(t, end, broker.Committed("billing", "orders", partition), "partition %d", partition)
+	}
+}
+
+func TestMemoryBrokerRetryToDLQ(t *testing.T) {
+	broker := NewMemoryBroker()
+	sub := broker.Subscriber("orders", "billing")
+	policy := RetryPolicy{Attempts: 1, DLQSuffix: "dlq"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.Consume(ctx, func(ctx context.Context, msg Message) error {
+			return errors.New("always fails")
+		}, WithRetryPolicy(policy, broker), WithConsumeErrorHandler(func(error) {}))
+	}()
+
+	require.NoError(t, broker.Publisher("orders").SendMessage(context.Background(), orderCreated{ID: "a"}))
+	dead := broker.WaitForMessages(t, "orders.dlq", 1, time.Second)
+	cancel()
+	require.NoError(t, <-done)
+
+	assert.Equal(t, "orders", dead[0].Headers[HeaderOriginalTopic])
+	assert.Equal(t, "always fails", dead[0].Headers[HeaderError])
+	assert.Equal(t, int64(1), broker.Committed("billing", "orders", 0))
+}
+
+func TestMemoryPublisherClosed(t *testing.T) {
+	publisher := NewMemoryBroker().Publisher("orders")
+	require.NoError(t, publisher.Close(context.Background()))
+	assert.ErrorIs(t, publisher.SendMessage(context.Background(), orderCreated{ID: "a"}), ErrPublisherClosed)
+}
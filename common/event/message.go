@@ -0,0 +1,139 @@
+package event
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// ProducerMessage is a message to publish with its metadata.
+type ProducerMessage struct {
+	Key       []byte // Messages with the same key go to the same partition
+	Value     interface{}
+	Headers   map[string]string
+	Timestamp time.Time // Zero lets the producer set it
+	Partition *int32    // Nil lets the partitioner choose
+}
+
+func toKafkaHeaders(headers map[string]string) []kafka.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]kafka.Header, len(keys))
+	for i, k := range keys {
+		out[i] = kafka.Header{Key: k, Value: []byte(headers[k])}
+	}
+	return out
+}
+
+// fromKafkaHeaders converts message headers into a map; the last value of a
+// repeated header wins.
+func fromKafkaHeaders(headers []kafka.Header) map[string]string {
+	out := make(map[string]string, len(headers))
+	for _, h := range headers {
+		out[h.Key] = string(h.Value)
+	}
+	return out
+}
+
+// Message is a consumed message with its metadata.
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte // Serialized value, see Decode
+	Headers   map[string]string
+	Timestamp time.Time
+
+	raw    *kafka.Message
+	decode func(value []byte, v interface{}) error
+}
+
+// Decode deserializes the value of the message into v, a pointer.
+func (m Message) Decode(v interface{}) error {
+	if m.decode == nil {
+		return errors.New("message has no deserializer")
+	}
+	return m.decode(m.Value, v)
+}
+
+// Partition returns a pointer to p, for ProducerMessage.Partition.
+func Partition(p int32) *int32 {
+	return &p
+}
+
+// Partitioner chooses the partition of a message among n partitions.
+// Returning kafka.PartitionAny leaves the choice to librdkafka.
+type Partitioner interface {
+	Partition(msg *ProducerMessage, n int) int32
+}
+
+// PartitionerFunc adapts a function to a Partitioner.
+type PartitionerFunc func(msg *ProducerMessage, n int) int32
+
+func (f PartitionerFunc) Partition(msg *ProducerMessage, n int) int32 {
+	return f(msg, n)
+}
+
+// HashPartitioner places messages by the murmur2 hash of their key, like the
+// Java client's default partitioner, so producers in both languages agree.
+// Messages without a key are left to librdkafka.
+var HashPartitioner Partitioner = PartitionerFunc(func(msg *ProducerMessage, n int) int32 {
+	if len(msg.Key) == 0 {
+		return kafka.PartitionAny
+	}
+	return int32((murmur2(msg.Key) & 0x7fffffff) % uint32(n))
+})
+
+// NewRoundRobinPartitioner returns a Partitioner spreading messages evenly
+// over the partitions, ignoring their keys.
+func NewRoundRobinPartitioner() Partitioner {
+	var next atomic.Uint32
+	return PartitionerFunc(func(_ *ProducerMessage, n int) int32 {
+		return int32((next.Add(1) - 1) % uint32(n))
+	})
+}
+
+// murmur2 is the hash used by the Java client to partition keyed messages.
+func murmur2(data []byte) uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
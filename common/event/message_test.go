@@ -0,0 +1,64 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMurmur2MatchesJavaClient(t *testing.T) {
+	// Expected values from the Java client's Utils.murmur2 tests
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, want := range cases {
+		assert.Equal(t, want, int32(murmur2([]byte(key))), key)
+	}
+}
+
+func TestPartitioners(t *testing.T) {
+	msg := &ProducerMessage{Key: []byte("order-1")}
+	p := HashPartitioner.Partition(msg, 6)
+	assert.True(t, p >= 0 && p < 6)
+	assert.Equal(t, p, HashPartitioner.Partition(&ProducerMessage{Key: []byte("order-1")}, 6))
+	assert.Equal(t, kafka.PartitionAny, HashPartitioner.Partition(&ProducerMessage{}, 6))
+
+	rr := NewRoundRobinPartitioner()
+	var got []int32
+	for i := 0; i < 4; i++ {
+		got = append(got, rr.Partition(msg, 3))
+	}
+	assert.Equal(t, []int32{0, 1, 2, 0}, got)
+}
+
+func TestProducerMessageHeaders(t *testing.T) {
+	headers := toKafkaHeaders(map[string]string{"b": "2", "a": "1"})
+	assert.Equal(t, []kafka.Header{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}}, headers)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, fromKafkaHeaders(headers))
+	assert.Nil(t, toKafkaHeaders(nil))
+}
+
+func TestPublishPartitioning(t *testing.T) {
+	p := newUnreachablePublisher(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	report, err := p.PublishAsync(ctx, ProducerMessage{Value: "v", Partition: Partition(2)}).Wait(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), report.Partition)
+
+	// A known partition count avoids the metadata lookup
+	p.partitioner = PartitionerFunc(func(*ProducerMessage, int) int32 { return 1 })
+	p.partitions, p.partitionsAt = 4, time.Now()
+	report, err = p.PublishAsync(ctx, ProducerMessage{Key: []byte("k"), Value: "v"}).Wait(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), report.Partition)
+}
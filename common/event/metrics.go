@@ -0,0 +1,396 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+Publisher and consumer metrics: message counts, handler latency, errors by
+kind, commit failures and the consumer lag of each partition, read from the
+librdkafka statistics.
+
+USAGE EXAMPLE:
+
+	metrics := event.NewMetrics()
+	consumer, _ := event.NewKafkaConsumer(event.WithKafkaStatisticsInterval(15 * time.Second))
+	subscriber, _ := event.NewKafkaSubscriber(consumer, sr, "orders", event.WithSubscriberMetrics(metrics))
+	publisher, _ := event.NewKafkaPublisher(producer, sr, 0, "invoices", event.WithPublisherMetrics(metrics))
+
+	http.Handle("/metrics", metrics.Handler())
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := metrics.CheckLag(10000); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+	})
+*/
+
+// handlerLatencyBuckets are the upper bounds, in seconds, of the handler
+// latency histogram.
+var handlerLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics collects the metrics of publishers and subscribers. A Metrics may
+// be shared by several of them and is safe for concurrent use.
+type Metrics struct {
+	mu             sync.Mutex
+	topics         map[string]*topicMetrics
+	commitFailures uint64
+	lag            map[string]map[partitionKey]int64 // Consumer lag per librdkafka client
+	statsAt        time.Time
+}
+
+type topicMetrics struct {
+	produced      uint64
+	consumed      uint64
+	produceErrors map[string]uint64
+	handlerErrors map[string]uint64
+	latency       LatencyStats
+	buckets       []uint64 // Cumulative counts of handlerLatencyBuckets
+	latencySum    float64
+}
+
+// TopicMetrics are the metrics of a topic.
+type TopicMetrics struct {
+	Produced       uint64
+	Consumed       uint64
+	ProduceErrors  map[string]uint64 // By error kind, see ErrorKind
+	HandlerErrors  map[string]uint64 // By error kind, see ErrorKind
+	HandlerLatency LatencyStats
+	Lag            map[int32]int64 // Consumer lag per partition
+}
+
+// TotalLag returns the sum of the lag of the partitions.
+func (t TopicMetrics) TotalLag() int64 {
+	var total int64
+	for _, lag := range t.Lag {
+		total += lag
+	}
+	return total
+}
+
+// LatencyStats summarizes the handler latency.
+type LatencyStats struct {
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the mean latency, 0 if nothing was measured.
+func (l LatencyStats) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
+
+// MetricsSnapshot is a copy of the metrics at a point in time.
+type MetricsSnapshot struct {
+	Topics         map[string]TopicMetrics
+	CommitFailures uint64
+	StatsAt        time.Time // When the last librdkafka statistics were read, zero if never
+}
+
+// NewMetrics returns empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		topics: make(map[string]*topicMetrics),
+		lag:    make(map[string]map[partitionKey]int64),
+	}
+}
+
+// ErrorKind returns the label of err in the error metrics: its errorx
+// classes, e.g. "retryable|timeout", or "unknown".
+func ErrorKind(err error) string {
+	if kind := errorx.ClassOf(err).String(); kind != "" {
+		return kind
+	}
+	return "unknown"
+}
+
+// topic returns the metrics of name, creating them. m.mu must be held.
+func (m *Metrics) topic(name string) *topicMetrics {
+	t, ok := m.topics[name]
+	if !ok {
+		t = &topicMetrics{
+			produceErrors: make(map[string]uint64),
+			handlerErrors: make(map[string]uint64),
+			buckets:       make([]uint64, len(handlerLatencyBuckets)),
+		}
+		m.topics[name] = t
+	}
+	return t
+}
+
+// ObserveProduced counts a message delivered to topic, or failed with err.
+func (m *Metrics) ObserveProduced(topic string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.topic(topic)
+	if err != nil {
+		t.produceErrors[ErrorKind(err)]++
+		return
+	}
+	t.produced++
+}
+
+// ObserveConsumed counts a message read from topic.
+func (m *Metrics) ObserveConsumed(topic string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.topic(topic).consumed++
+}
+
+// ObserveHandled records a handler run on a message of topic that took d and
+// returned err.
+func (m *Metrics) ObserveHandled(topic string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.topic(topic)
+	t.latency.Count++
+	t.latency.Total += d
+	if d > t.latency.Max {
+		t.latency.Max = d
+	}
+	t.latencySum += d.Seconds()
+	for i, bound := range handlerLatencyBuckets {
+		if d.Seconds() <= bound {
+			t.buckets[i]++
+		}
+	}
+	if err != nil {
+		t.handlerErrors[ErrorKind(err)]++
+	}
+}
+
+// ObserveCommitFailure counts a failed offset commit.
+func (m *Metrics) ObserveCommitFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commitFailures++
+}
+
+// rdkafkaStats is the part of the librdkafka statistics used by Metrics.
+type rdkafkaStats struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Topics map[string]struct {
+		Partitions map[string]struct {
+			Partition       int32  `json:"partition"`
+			FetchState      string `json:"fetch_state"`
+			HiOffset        int64  `json:"hi_offset"`
+			LsOffset        int64  `json:"ls_offset"`
+			CommittedOffset int64  `json:"committed_offset"`
+			ConsumerLag     int64  `json:"consumer_lag"`
+		} `json:"partitions"`
+	} `json:"topics"`
+}
+
+// ObserveStats reads the consumer lag of each partition from the JSON
+// statistics of a librdkafka client, emitted as *kafka.Stats events with
+// statistics.interval.ms set. The lag is the distance between the high
+// watermark, or the last stable offset of transactional topics, and the
+// committed offset. Consume calls it for the statistics it polls.
+func (m *Metrics) ObserveStats(stats string) error {
+	_, err := m.observeStats(stats)
+	return err
+}
+
+// observeStats is ObserveStats returning the name of the client.
+func (m *Metrics) observeStats(stats string) (string, error) {
+	var s rdkafkaStats
+	if err := json.Unmarshal([]byte(stats), &s); err != nil {
+		return "", fmt.Errorf("invalid librdkafka statistics: %w", err)
+	}
+	if s.Type != "" && s.Type != "consumer" {
+		return s.Name, nil
+	}
+
+	lag := make(map[partitionKey]int64)
+	for topic, t := range s.Topics {
+		for _, p := range t.Partitions {
+			if p.Partition < 0 || p.FetchState == "none" {
+				continue // Internal UA partition or not assigned
+			}
+			end := p.LsOffset
+			if end < 0 {
+				end = p.HiOffset
+			}
+			switch {
+			case end >= 0 && p.CommittedOffset >= 0:
+				lag[partitionKey{topic: topic, partition: p.Partition}] = max(end-p.CommittedOffset, 0)
+			case p.ConsumerLag >= 0:
+				lag[partitionKey{topic: topic, partition: p.Partition}] = p.ConsumerLag
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lag[s.Name] = lag
+	m.statsAt = time.Now()
+	return s.Name, nil
+}
+
+// ForgetClient drops the lag read from the statistics of the librdkafka
+// client name, e.g. "rdkafka#consumer-1", once it is closed. Consume does it
+// when it returns.
+func (m *Metrics) ForgetClient(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.lag, name)
+}
+
+// Snapshot returns a copy of the metrics.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := MetricsSnapshot{
+		Topics:         make(map[string]TopicMetrics, len(m.topics)),
+		CommitFailures: m.commitFailures,
+		StatsAt:        m.statsAt,
+	}
+	for name, t := range m.topics {
+		snapshot.Topics[name] = TopicMetrics{
+			Produced:       t.produced,
+			Consumed:       t.consumed,
+			ProduceErrors:  copyCounts(t.produceErrors),
+			HandlerErrors:  copyCounts(t.handlerErrors),
+			HandlerLatency: t.latency,
+		}
+	}
+	for _, lags := range m.lag {
+		for key, lag := range lags {
+			t := snapshot.Topics[key.topic]
+			if t.Lag == nil {
+				t.Lag = make(map[int32]int64)
+			}
+			t.Lag[key.partition] = max(t.Lag[key.partition], lag)
+			snapshot.Topics[key.topic] = t
+		}
+	}
+	return snapshot
+}
+
+// CheckLag returns an error if the lag of a partition exceeds limit. It is
+// meant for health checks.
+func (m *Metrics) CheckLag(limit int64) error {
+	snapshot := m.Snapshot()
+	for _, topic := range sortedKeys(snapshot.Topics) {
+		lags := snapshot.Topics[topic].Lag
+		for _, p := range sortedPartitions(lags) {
+			if lags[p] > limit {
+				return fmt.Errorf("consumer lag of %s[%d] is %d, above %d", topic, p, lags[p], limit)
+			}
+		}
+	}
+	return nil
+}
+
+// Handler returns an HTTP handler serving the metrics in the Prometheus
+// text format.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	m.mu.Lock()
+	histograms := make(map[string]topicMetrics, len(m.topics))
+	for name, t := range m.topics {
+		h := *t
+		h.buckets = append([]uint64(nil), t.buckets...)
+		histograms[name] = h
+	}
+	m.mu.Unlock()
+
+	var b strings.Builder
+	topics := sortedKeys(snapshot.Topics)
+	counter := func(name, help string, value func(TopicMetrics) uint64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, topic := range topics {
+			fmt.Fprintf(&b, "%s{topic=%q} %d\n", name, topic, value(snapshot.Topics[topic]))
+		}
+	}
+	errorCounter := func(name, help string, counts func(TopicMetrics) map[string]uint64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, topic := range topics {
+			c := counts(snapshot.Topics[topic])
+			for _, kind := range sortedKeys(c) {
+				fmt.Fprintf(&b, "%s{topic=%q,kind=%q} %d\n", name, topic, kind, c[kind])
+			}
+		}
+	}
+
+	counter("event_messages_produced_total", "Messages delivered to the broker.", func(t TopicMetrics) uint64 { return t.Produced })
+	errorCounter("event_produce_errors_total", "Messages that failed to be published, by error kind.", func(t TopicMetrics) map[string]uint64 { return t.ProduceErrors })
+	counter("event_messages_consumed_total", "Messages read from the broker.", func(t TopicMetrics) uint64 { return t.Consumed })
+	errorCounter("event_handler_errors_total", "Handler failures, by error kind.", func(t TopicMetrics) map[string]uint64 { return t.HandlerErrors })
+
+	name := "event_handler_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Time spent in handlers.\n# TYPE %s histogram\n", name, name)
+	for _, topic := range topics {
+		h, ok := histograms[topic]
+		if !ok {
+			continue // Only lag is known
+		}
+		for i, bound := range handlerLatencyBuckets {
+			fmt.Fprintf(&b, "%s_bucket{topic=%q,le=%q} %d\n", name, topic, strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket{topic=%q,le=\"+Inf\"} %d\n", name, topic, h.latency.Count)
+		fmt.Fprintf(&b, "%s_sum{topic=%q} %s\n", name, topic, strconv.FormatFloat(h.latencySum, 'g', -1, 64))
+		fmt.Fprintf(&b, "%s_count{topic=%q} %d\n", name, topic, h.latency.Count)
+	}
+
+	fmt.Fprintf(&b, "# HELP event_commit_failures_total Failed offset commits.\n# TYPE event_commit_failures_total counter\nevent_commit_failures_total %d\n", snapshot.CommitFailures)
+
+	name = "event_consumer_lag"
+	fmt.Fprintf(&b, "# HELP %s Messages between the committed offset and the end of the partition.\n# TYPE %s gauge\n", name, name)
+	for _, topic := range topics {
+		lags := snapshot.Topics[topic].Lag
+		for _, p := range sortedPartitions(lags) {
+			fmt.Fprintf(&b, "%s{topic=%q,partition=\"%d\"} %d\n", name, topic, p, lags[p])
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	out := make(map[string]uint64, len(counts))
+	for k, v := range counts {
+		out[k] = v
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPartitions(lags map[int32]int64) []int32 {
+	partitions := make([]int32, 0, len(lags))
+	for p := range lags {
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	return partitions
+}
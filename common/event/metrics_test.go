@@ -0,0 +1,128 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConsumerStats = `{
+	"name": "rdkafka#consumer-1",
+	"type": "consumer",
+	"topics": {
+		"orders": {
+			"partitions": {
+				"0": {"partition": 0, "fetch_state": "active", "hi_offset": 120, "ls_offset": -1, "committed_offset": 100, "consumer_lag": 20},
+				"1": {"partition": 1, "fetch_state": "active", "hi_offset": 50, "ls_offset": 45, "committed_offset": 40, "consumer_lag": 5},
+				"2": {"partition": 2, "fetch_state": "active", "hi_offset": 30, "ls_offset": -1, "committed_offset": -1001, "consumer_lag": 7},
+				"3": {"partition": 3, "fetch_state": "none", "hi_offset": 90, "ls_offset": -1, "committed_offset": 0, "consumer_lag": -1},
+				"-1": {"partition": -1, "fetch_state": "none", "hi_offset": -1, "ls_offset": -1, "committed_offset": -1, "consumer_lag": -1}
+			}
+		}
+	}
+}`
+
+func TestMetricsObserveStats(t *testing.T) {
+	m := NewMetrics()
+	require.NoError(t, m.ObserveStats(testConsumerStats))
+
+	snapshot := m.Snapshot()
+	assert.False(t, snapshot.StatsAt.IsZero())
+	assert.Equal(t, map[int32]int64{0: 20, 1: 5, 2: 7}, snapshot.Topics["orders"].Lag)
+	assert.Equal(t, int64(32), snapshot.Topics["orders"].TotalLag())
+
+	assert.NoError(t, m.CheckLag(20))
+	assert.EqualError(t, m.CheckLag(10), "consumer lag of orders[0] is 20, above 10")
+
+	assert.Error(t, m.ObserveStats("not json"))
+	require.NoError(t, m.ObserveStats(`{"name": "rdkafka#producer-1", "type": "producer", "topics": {}}`))
+	assert.Len(t, m.Snapshot().Topics["orders"].Lag, 3, "producer statistics carry no lag")
+}
+
+func TestMetricsConsume(t *testing.T) {
+	m := NewMetrics()
+	c := newFakeConsumer()
+	handled := make(chan struct{}, 2)
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		defer func() { handled <- struct{}{} }()
+		if msg.Offset == 1 {
+			return errorx.Tag(errors.New("bad payload"), errorx.ClassValidation)
+		}
+		return nil
+	}, WithConsumeMetrics(m))
+
+	c.events <- testMessage(0, 0)
+	c.events <- testMessage(0, 1)
+	<-handled
+	<-handled
+	require.NoError(t, stop())
+
+	orders := m.Snapshot().Topics["orders"]
+	assert.Equal(t, uint64(2), orders.Consumed)
+	assert.Equal(t, uint64(2), orders.HandlerLatency.Count)
+	assert.Equal(t, map[string]uint64{"validation": 1}, orders.HandlerErrors)
+}
+
+func TestMetricsPublisherErrors(t *testing.T) {
+	m := NewMetrics()
+	p := newUnreachablePublisher(t, WithPublisherMetrics(m))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := p.SendMessageAsync(ctx, map[string]int{"n": 1}).Wait(ctx)
+	require.Error(t, err)
+	_, err = p.SendMessageAsync(ctx, make(chan int)).Wait(ctx)
+	require.Error(t, err)
+
+	// Callbacks run once the future is done
+	assert.Eventually(t, func() bool {
+		orders := m.Snapshot().Topics["orders"]
+		return orders.Produced == 0 && assert.ObjectsAreEqual(map[string]uint64{"retryable|timeout": 1, "validation": 1}, orders.ProduceErrors)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMetricsWritePrometheus(t *testing.T) {
+	m := NewMetrics()
+	m.ObserveProduced("orders", nil)
+	m.ObserveConsumed("orders")
+	m.ObserveHandled("orders", 30*time.Millisecond, errorx.Tag(errors.New("timeout"), errorx.ClassTimeout|errorx.ClassRetryable))
+	m.ObserveCommitFailure()
+	require.NoError(t, m.ObserveStats(testConsumerStats))
+
+	var b strings.Builder
+	require.NoError(t, m.WritePrometheus(&b))
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE event_messages_produced_total counter",
+		`event_messages_produced_total{topic="orders"} 1`,
+		`event_messages_consumed_total{topic="orders"} 1`,
+		`event_handler_errors_total{topic="orders",kind="retryable|timeout"} 1`,
+		`event_handler_duration_seconds_bucket{topic="orders",le="0.025"} 0`,
+		`event_handler_duration_seconds_bucket{topic="orders",le="0.05"} 1`,
+		`event_handler_duration_seconds_bucket{topic="orders",le="+Inf"} 1`,
+		`event_handler_duration_seconds_count{topic="orders"} 1`,
+		"event_commit_failures_total 1",
+		`event_consumer_lag{topic="orders",partition="0"} 20`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+}
+
+func TestMetricsLagOnlyTopic(t *testing.T) {
+	m := NewMetrics()
+	require.NoError(t, m.ObserveStats(testConsumerStats))
+
+	var b strings.Builder
+	require.NoError(t, m.WritePrometheus(&b))
+	assert.Contains(t, b.String(), `event_consumer_lag{topic="orders",partition="0"} 20`+"\n")
+	assert.NotContains(t, b.String(), "event_handler_duration_seconds_bucket")
+
+	m.ForgetClient("rdkafka#consumer-1")
+	assert.NoError(t, m.CheckLag(0), "the lag of closed consumers is dropped")
+}
@@ -0,0 +1,504 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/hamba/avro/v2"
+)
+
+/*
+Schema registry stand-ins for tests and offline development.
+
+USAGE EXAMPLE:
+
+	// HTTP server speaking the schema registry REST API, compatibility checks included
+	server := event.NewMockSchemaRegistryServer()
+	defer server.Close()
+	sr, err := event.NewSchemaRegistry(event.WithKafkaSchemaRegistryURL(server.URL()))
+
+	// In-memory registry plugged directly into the serdes, without compatibility checks
+	sr := event.NewInMemorySchemaRegistry()
+*/
+
+var inMemoryRegistries atomic.Int64
+
+// NewInMemorySchemaRegistry returns a registry keeping its schemas in memory.
+// It does not check compatibility; use NewMockSchemaRegistryServer for that.
+func NewInMemorySchemaRegistry(opts ...KafkaOption) *SchemaRegistry {
+	producerConfig := DefaultConfig.Producer
+	consumerConfig := DefaultConfig.Consumer
+	schemaConfig := DefaultConfig.Schema
+	for _, opt := range opts {
+		opt(&producerConfig, &consumerConfig, &schemaConfig)
+	}
+	url := fmt.Sprintf("mock://in-memory-%d", inMemoryRegistries.Add(1))
+	client, err := schemaregistry.NewClient(schemaregistry.NewConfig(url))
+	if err != nil {
+		panic(fmt.Sprintf("failed to create in-memory schema registry: %s", err)) // only fails on an invalid URL
+	}
+	return &SchemaRegistry{client: client, strategy: schemaConfig.SubjectNameStrategy}
+}
+
+// Error codes of the schema registry REST API
+const (
+	srSubjectNotFound      = 40401
+	srVersionNotFound      = 40402
+	srSchemaNotFound       = 40403
+	srIncompatibleSchema   = 409
+	srInvalidSchema        = 42201
+	srInvalidVersion       = 42202
+	srInvalidCompatibility = 42203
+)
+
+// MockSchemaRegistryServer is an in-process schema registry serving the
+// subset of the REST API used by the schema registry client: schemas by ID,
+// subjects, versions, compatibility levels and checks. Avro schemas are
+// checked for compatibility; Protobuf and JSON schemas are only checked for
+// a matching type.
+type MockSchemaRegistryServer struct {
+	server *httptest.Server
+
+	mu            sync.Mutex
+	schemas       []schemaregistry.SchemaInfo // Indexed by ID - 1
+	subjects      map[string][]int            // IDs of the versions of each subject, deleted ones are 0
+	compatibility string                      // Global level
+	subjectLevels map[string]string
+}
+
+// NewMockSchemaRegistryServer starts a registry listening on a local port,
+// with the global compatibility level BACKWARD.
+func NewMockSchemaRegistryServer() *MockSchemaRegistryServer {
+	s := &MockSchemaRegistryServer{
+		subjects:      make(map[string][]int),
+		compatibility: "BACKWARD",
+		subjectLevels: make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /schemas/ids/{id}", s.getSchema)
+	mux.HandleFunc("GET /schemas/ids/{id}/versions", s.getSchemaVersions)
+	mux.HandleFunc("GET /subjects", s.listSubjects)
+	mux.HandleFunc("POST /subjects/{subject}", s.lookupSchema)
+	mux.HandleFunc("DELETE /subjects/{subject}", s.deleteSubject)
+	mux.HandleFunc("GET /subjects/{subject}/versions", s.listVersions)
+	mux.HandleFunc("POST /subjects/{subject}/versions", s.register)
+	mux.HandleFunc("GET /subjects/{subject}/versions/{version}", s.getVersion)
+	mux.HandleFunc("DELETE /subjects/{subject}/versions/{version}", s.deleteVersion)
+	mux.HandleFunc("POST /compatibility/subjects/{subject}/versions", s.testCompatibility)
+	mux.HandleFunc("POST /compatibility/subjects/{subject}/versions/{version}", s.testCompatibility)
+	mux.HandleFunc("GET /config", s.getConfig)
+	mux.HandleFunc("PUT /config", s.putConfig)
+	mux.HandleFunc("GET /config/{subject}", s.getConfig)
+	mux.HandleFunc("PUT /config/{subject}", s.putConfig)
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+// URL returns the base URL of the registry
+func (s *MockSchemaRegistryServer) URL() string {
+	return s.server.URL
+}
+
+// Close stops the registry
+func (s *MockSchemaRegistryServer) Close() {
+	s.server.Close()
+}
+
+// registryError is the error body of the REST API.
+type registryError struct {
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+func writeRegistryJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeRegistryError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	status := code
+	for status >= 1000 {
+		status /= 10
+	}
+	writeRegistryJSON(w, status, registryError{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// readSchema decodes the schema of a request body.
+func readSchema(w http.ResponseWriter, r *http.Request) (schemaregistry.SchemaInfo, bool) {
+	var info schemaregistry.SchemaInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil || info.Schema == "" {
+		writeRegistryError(w, srInvalidSchema, "invalid schema")
+		return info, false
+	}
+	if info.SchemaType == "AVRO" {
+		info.SchemaType = ""
+	}
+	return info, true
+}
+
+// metadata returns the description of version (1-based) of subject.
+func (s *MockSchemaRegistryServer) metadata(subject string, version int) schemaregistry.SchemaMetadata {
+	id := s.subjects[subject][version-1]
+	return schemaregistry.SchemaMetadata{SchemaInfo: s.schemas[id-1], ID: id, Subject: subject, Version: version}
+}
+
+// versions returns the live versions of subject.
+func (s *MockSchemaRegistryServer) versions(subject string) []int {
+	var versions []int
+	for i, id := range s.subjects[subject] {
+		if id != 0 {
+			versions = append(versions, i+1)
+		}
+	}
+	return versions
+}
+
+// version resolves the version path parameter of subject. It writes the
+// error response and returns 0 if the version does not exist.
+func (s *MockSchemaRegistryServer) version(w http.ResponseWriter, subject, param string) int {
+	versions := s.versions(subject)
+	if len(versions) == 0 {
+		writeRegistryError(w, srSubjectNotFound, "Subject '%s' not found.", subject)
+		return 0
+	}
+	if param == "latest" || param == "-1" {
+		return versions[len(versions)-1]
+	}
+	v, err := strconv.Atoi(param)
+	if err != nil || v < 1 {
+		writeRegistryError(w, srInvalidVersion, "The specified version '%s' is not a valid version id.", param)
+		return 0
+	}
+	if v > len(s.subjects[subject]) || s.subjects[subject][v-1] == 0 {
+		writeRegistryError(w, srVersionNotFound, "Version %d not found.", v)
+		return 0
+	}
+	return v
+}
+
+// findVersion returns the version of subject with schema info, or 0.
+func (s *MockSchemaRegistryServer) findVersion(subject string, info schemaregistry.SchemaInfo) int {
+	for i, id := range s.subjects[subject] {
+		if id != 0 && sameSchema(s.schemas[id-1], info) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func sameSchema(a, b schemaregistry.SchemaInfo) bool {
+	return a.Schema == b.Schema && a.SchemaType == b.SchemaType &&
+		(len(a.References) == 0 && len(b.References) == 0 || reflect.DeepEqual(a.References, b.References))
+}
+
+func (s *MockSchemaRegistryServer) getSchema(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 || id > len(s.schemas) {
+		writeRegistryError(w, srSchemaNotFound, "Schema %s not found", r.PathValue("id"))
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, s.schemas[id-1])
+}
+
+func (s *MockSchemaRegistryServer) getSchemaVersions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	result := []schemaregistry.SubjectAndVersion{}
+	for subject, ids := range s.subjects {
+		for i, vid := range ids {
+			if vid == id {
+				result = append(result, schemaregistry.SubjectAndVersion{Subject: subject, Version: i + 1})
+			}
+		}
+	}
+	if len(result) == 0 {
+		writeRegistryError(w, srSchemaNotFound, "Schema %s not found", r.PathValue("id"))
+		return
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Subject < result[j].Subject })
+	writeRegistryJSON(w, http.StatusOK, result)
+}
+
+func (s *MockSchemaRegistryServer) listSubjects(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subjects := []string{}
+	for subject := range s.subjects {
+		if len(s.versions(subject)) > 0 {
+			subjects = append(subjects, subject)
+		}
+	}
+	sort.Strings(subjects)
+	writeRegistryJSON(w, http.StatusOK, subjects)
+}
+
+func (s *MockSchemaRegistryServer) lookupSchema(w http.ResponseWriter, r *http.Request) {
+	info, ok := readSchema(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subject := r.PathValue("subject")
+	if len(s.versions(subject)) == 0 {
+		writeRegistryError(w, srSubjectNotFound, "Subject '%s' not found.", subject)
+		return
+	}
+	v := s.findVersion(subject, info)
+	if v == 0 {
+		writeRegistryError(w, srSchemaNotFound, "Schema not found")
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, s.metadata(subject, v))
+}
+
+func (s *MockSchemaRegistryServer) deleteSubject(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subject := r.PathValue("subject")
+	versions := s.versions(subject)
+	if len(versions) == 0 {
+		writeRegistryError(w, srSubjectNotFound, "Subject '%s' not found.", subject)
+		return
+	}
+	for _, v := range versions {
+		s.subjects[subject][v-1] = 0
+	}
+	writeRegistryJSON(w, http.StatusOK, versions)
+}
+
+func (s *MockSchemaRegistryServer) listVersions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subject := r.PathValue("subject")
+	versions := s.versions(subject)
+	if len(versions) == 0 {
+		writeRegistryError(w, srSubjectNotFound, "Subject '%s' not found.", subject)
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, versions)
+}
+
+func (s *MockSchemaRegistryServer) register(w http.ResponseWriter, r *http.Request) {
+	info, ok := readSchema(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subject := r.PathValue("subject")
+	if v := s.findVersion(subject, info); v != 0 {
+		writeRegistryJSON(w, http.StatusOK, s.metadata(subject, v))
+		return
+	}
+	if _, err := s.parse(info); err != nil {
+		writeRegistryError(w, srInvalidSchema, "Invalid schema: %s", err)
+		return
+	}
+	compatible, err := s.compatible(subject, info, s.versions(subject))
+	if err != nil {
+		writeRegistryError(w, srInvalidSchema, "Invalid schema: %s", err)
+		return
+	}
+	if !compatible {
+		writeRegistryError(w, srIncompatibleSchema, "Schema being registered is incompatible with an earlier schema for subject %q", subject)
+		return
+	}
+
+	id := 0
+	for i, existing := range s.schemas {
+		if sameSchema(existing, info) {
+			id = i + 1
+			break
+		}
+	}
+	if id == 0 {
+		s.schemas = append(s.schemas, info)
+		id = len(s.schemas)
+	}
+	s.subjects[subject] = append(s.subjects[subject], id)
+	writeRegistryJSON(w, http.StatusOK, s.metadata(subject, len(s.subjects[subject])))
+}
+
+func (s *MockSchemaRegistryServer) getVersion(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subject := r.PathValue("subject")
+	if v := s.version(w, subject, r.PathValue("version")); v != 0 {
+		writeRegistryJSON(w, http.StatusOK, s.metadata(subject, v))
+	}
+}
+
+func (s *MockSchemaRegistryServer) deleteVersion(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subject := r.PathValue("subject")
+	if v := s.version(w, subject, r.PathValue("version")); v != 0 {
+		s.subjects[subject][v-1] = 0
+		writeRegistryJSON(w, http.StatusOK, v)
+	}
+}
+
+func (s *MockSchemaRegistryServer) testCompatibility(w http.ResponseWriter, r *http.Request) {
+	info, ok := readSchema(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subject := r.PathValue("subject")
+	versions := s.versions(subject)
+	if param := r.PathValue("version"); param != "" {
+		v := s.version(w, subject, param)
+		if v == 0 {
+			return
+		}
+		if !strings.HasSuffix(s.level(subject), "_TRANSITIVE") {
+			versions = []int{v}
+		}
+	}
+	compatible, err := s.compatible(subject, info, versions)
+	if err != nil {
+		writeRegistryError(w, srInvalidSchema, "Invalid schema: %s", err)
+		return
+	}
+	writeRegistryJSON(w, http.StatusOK, map[string]bool{"is_compatible": compatible})
+}
+
+func (s *MockSchemaRegistryServer) getConfig(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	level := s.compatibility
+	if subject := r.PathValue("subject"); subject != "" {
+		var ok bool
+		if level, ok = s.subjectLevels[subject]; !ok && r.URL.Query().Get("defaultToGlobal") != "true" {
+			writeRegistryError(w, 40408, "Subject '%s' does not have subject-level compatibility configured", subject)
+			return
+		}
+		if !ok {
+			level = s.compatibility
+		}
+	}
+	writeRegistryJSON(w, http.StatusOK, map[string]string{"compatibilityLevel": level})
+}
+
+func (s *MockSchemaRegistryServer) putConfig(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Compatibility string `json:"compatibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeRegistryError(w, srInvalidCompatibility, "Invalid compatibility level")
+		return
+	}
+	var level schemaregistry.Compatibility
+	if err := level.ParseString(body.Compatibility); err != nil {
+		writeRegistryError(w, srInvalidCompatibility, "Invalid compatibility level %q", body.Compatibility)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if subject := r.PathValue("subject"); subject != "" {
+		s.subjectLevels[subject] = body.Compatibility
+	} else {
+		s.compatibility = body.Compatibility
+	}
+	writeRegistryJSON(w, http.StatusOK, body)
+}
+
+// level returns the compatibility level of subject.
+func (s *MockSchemaRegistryServer) level(subject string) string {
+	if level, ok := s.subjectLevels[subject]; ok {
+		return level
+	}
+	return s.compatibility
+}
+
+// compatible checks info against versions of subject, or only the latest
+// one unless the level of subject is transitive.
+func (s *MockSchemaRegistryServer) compatible(subject string, info schemaregistry.SchemaInfo, versions []int) (bool, error) {
+	level := s.level(subject)
+	if level == "NONE" || len(versions) == 0 {
+		return true, nil
+	}
+	if !strings.HasSuffix(level, "_TRANSITIVE") {
+		versions = versions[len(versions)-1:]
+	}
+	candidate, err := s.parse(info)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range versions {
+		existing := s.schemas[s.subjects[subject][v-1]-1]
+		if existing.SchemaType != info.SchemaType {
+			return false, nil
+		}
+		if candidate == nil {
+			continue
+		}
+		previous, err := s.parse(existing)
+		if err != nil {
+			return false, err
+		}
+		checker := avro.NewSchemaCompatibility()
+		backward := checker.Compatible(candidate, previous) == nil
+		forward := checker.Compatible(previous, candidate) == nil
+		switch strings.TrimSuffix(level, "_TRANSITIVE") {
+		case "BACKWARD":
+			if !backward {
+				return false, nil
+			}
+		case "FORWARD":
+			if !forward {
+				return false, nil
+			}
+		case "FULL":
+			if !backward || !forward {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// parse parses an Avro schema with its references, or returns nil for other
+// schema types.
+func (s *MockSchemaRegistryServer) parse(info schemaregistry.SchemaInfo) (avro.Schema, error) {
+	if info.SchemaType != "" {
+		return nil, nil
+	}
+	cache := &avro.SchemaCache{}
+	if err := s.parseReferences(info.References, cache); err != nil {
+		return nil, err
+	}
+	return avro.ParseWithCache(info.Schema, "", cache)
+}
+
+func (s *MockSchemaRegistryServer) parseReferences(refs []schemaregistry.Reference, cache *avro.SchemaCache) error {
+	for _, ref := range refs {
+		ids := s.subjects[ref.Subject]
+		if ref.Version < 1 || ref.Version > len(ids) || ids[ref.Version-1] == 0 {
+			return fmt.Errorf("reference %s version %d of %s not found", ref.Name, ref.Version, ref.Subject)
+		}
+		info := s.schemas[ids[ref.Version-1]-1]
+		if err := s.parseReferences(info.References, cache); err != nil {
+			return err
+		}
+		if _, err := avro.ParseWithCache(info.Schema, "", cache); err != nil {
+			return fmt.Errorf("invalid reference %s: %w", ref.Name, err)
+		}
+	}
+	return nil
+}
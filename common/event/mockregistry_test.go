@@ -0,0 +1,131 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mockOrderV1 = `{"type":"record","name":"Order","namespace":"com.example","fields":[{"name":"id","type":"string"}]}`
+	// Adds a field with a default: backward and forward compatible
+	mockOrderV2 = `{"type":"record","name":"Order","namespace":"com.example","fields":[{"name":"id","type":"string"},{"name":"note","type":"string","default":""}]}`
+	// Adds a field without default: not backward compatible
+	mockOrderV3 = `{"type":"record","name":"Order","namespace":"com.example","fields":[{"name":"id","type":"string"},{"name":"amount","type":"int"}]}`
+)
+
+func newMockServerRegistry(t *testing.T) (*MockSchemaRegistryServer, *SchemaRegistry) {
+	t.Helper()
+	server := NewMockSchemaRegistryServer()
+	t.Cleanup(server.Close)
+	sr, err := NewSchemaRegistry(WithKafkaSchemaRegistryURL(server.URL()))
+	require.NoError(t, err)
+	t.Cleanup(sr.Close)
+	return server, sr
+}
+
+func TestMockSchemaRegistryServerCompatibility(t *testing.T) {
+	_, sr := newMockServerRegistry(t)
+
+	ok, err := sr.CheckCompatibility("orders-value", FormatAvro, mockOrderV1)
+	require.NoError(t, err)
+	assert.True(t, ok, "a new subject accepts any schema")
+
+	id1, err := sr.RegisterSchema("orders-value", FormatAvro, mockOrderV1)
+	require.NoError(t, err)
+	again, err := sr.RegisterSchema("orders-value", FormatAvro, mockOrderV1)
+	require.NoError(t, err)
+	assert.Equal(t, id1, again)
+
+	ok, err = sr.CheckCompatibility("orders-value", FormatAvro, mockOrderV2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = sr.CheckCompatibility("orders-value", FormatAvro, mockOrderV3)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = sr.RegisterSchema("orders-value", FormatAvro, mockOrderV3)
+	assert.Error(t, err)
+
+	level, err := sr.Compatibility("orders-value")
+	require.NoError(t, err)
+	assert.Equal(t, schemaregistry.Compatibility(schemaregistry.Backward), level)
+
+	require.NoError(t, sr.SetCompatibility("orders-value", schemaregistry.Compatibility(schemaregistry.None)))
+	id3, err := sr.RegisterSchema("orders-value", FormatAvro, mockOrderV3)
+	require.NoError(t, err)
+	assert.NotEqual(t, id1, id3)
+
+	versions, err := sr.Versions("orders-value")
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, versions)
+	latest, err := sr.GetSchema("orders-value", -1)
+	require.NoError(t, err)
+	assert.Equal(t, id3, latest.ID)
+	assert.Equal(t, 2, latest.Version)
+}
+
+func TestMockSchemaRegistryServerTransitive(t *testing.T) {
+	_, sr := newMockServerRegistry(t)
+	require.NoError(t, sr.SetCompatibility("", schemaregistry.Compatibility(schemaregistry.None)))
+	_, err := sr.RegisterSchema("orders-value", FormatAvro, mockOrderV3)
+	require.NoError(t, err)
+	_, err = sr.RegisterSchema("orders-value", FormatAvro, mockOrderV1)
+	require.NoError(t, err)
+
+	// v2 reads the latest version, v1, but not the first one
+	require.NoError(t, sr.SetCompatibility("orders-value", schemaregistry.Compatibility(schemaregistry.Forward)))
+	ok, err := sr.CheckCompatibility("orders-value", FormatAvro, mockOrderV2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, sr.SetCompatibility("orders-value", schemaregistry.Compatibility(schemaregistry.ForwardTransitive)))
+	ok, err = sr.CheckCompatibility("orders-value", FormatAvro, mockOrderV2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestMockSchemaRegistryServerSerdes(t *testing.T) {
+	_, sr := newMockServerRegistry(t)
+	id, err := sr.RegisterSchema("orders-value", FormatAvro, mockOrderV1)
+	require.NoError(t, err)
+
+	const envelope = `{"type":"record","name":"Envelope","namespace":"com.example","fields":[{"name":"order","type":"com.example.Order"}]}`
+	_, err = sr.RegisterSchema("envelopes-value", FormatAvro, envelope,
+		schemaregistry.Reference{Name: "com.example.Order", Subject: "orders-value", Version: 1})
+	require.NoError(t, err)
+
+	ser, err := NewSerializer(sr, FormatAvro, WithSchemaID(id))
+	require.NoError(t, err)
+	des, err := NewDeserializer(sr, FormatAvro)
+	require.NoError(t, err)
+
+	type order struct {
+		ID string `avro:"id"`
+	}
+	payload, err := ser.Serialize("orders", order{ID: "order-1"})
+	require.NoError(t, err)
+	var got order
+	require.NoError(t, des.DeserializeInto("orders", payload, &got))
+	assert.Equal(t, "order-1", got.ID)
+
+	schema, err := sr.schemaByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, mockOrderV1, schema)
+
+	subjects, err := sr.Subjects()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"envelopes-value", "orders-value"}, subjects)
+}
+
+func TestInMemorySchemaRegistry(t *testing.T) {
+	sr := NewInMemorySchemaRegistry()
+	other := NewInMemorySchemaRegistry()
+	_, err := sr.RegisterSchema("orders-value", FormatAvro, mockOrderV1)
+	require.NoError(t, err)
+
+	subjects, err := other.Subjects()
+	require.NoError(t, err)
+	assert.Empty(t, subjects, "in-memory registries are independent")
+}
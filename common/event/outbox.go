@@ -0,0 +1,368 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+Transactional outbox: events are written to the outbox table in the same
+transaction as the business data, and an OutboxRelay publishes them afterwards.
+An event is published at least once, even if the service crashes right after
+the commit.
+
+USAGE EXAMPLE:
+
+	store := event.NewSQLOutboxStore(db)
+
+	tx, _ := db.BeginTx(ctx, nil)
+	// ... business writes with tx
+	evt, _ := event.NewOutboxEvent("orders", order.ID, OrderCreated{ID: order.ID})
+	store.Add(ctx, tx, evt)
+	tx.Commit()
+
+	relay := event.NewOutboxRelay(store, event.WithOutboxPollInterval(time.Second))
+	relay.RegisterPublisher("orders", publisher)
+	relay.RegisterEvent("OrderCreated", func() interface{} { return &OrderCreated{} })
+	go relay.Run(ctx)
+*/
+
+// OutboxStatus is the delivery state of an outbox event.
+type OutboxStatus string
+
+const (
+	OutboxPending   OutboxStatus = "pending"
+	OutboxPublished OutboxStatus = "published"
+	OutboxFailed    OutboxStatus = "failed" // Gave up after too many attempts
+)
+
+// OutboxEvent is an event waiting in the outbox. Events sharing an
+// AggregateKey are published in the order they were added.
+type OutboxEvent struct {
+	ID            int64
+	Topic         string
+	AggregateKey  string
+	EventName     string
+	Payload       []byte // JSON encoded event
+	Status        OutboxStatus
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	PublishedAt   *time.Time
+}
+
+// NewOutboxEvent encodes value as JSON into an event for topic. The event
+// name is value.EventName() if value implements ConsumerMessage, else the
+// name of its type.
+func NewOutboxEvent(topic, aggregateKey string, value interface{}) (OutboxEvent, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return OutboxEvent{}, errorx.Tag(fmt.Errorf("failed to encode outbox event: %w", err), errorx.ClassValidation)
+	}
+	name := ""
+	if m, ok := value.(ConsumerMessage); ok {
+		name = m.EventName()
+	} else {
+		t := reflect.TypeOf(value)
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		name = t.Name()
+	}
+	return OutboxEvent{
+		Topic:        topic,
+		AggregateKey: aggregateKey,
+		EventName:    name,
+		Payload:      payload,
+		Status:       OutboxPending,
+	}, nil
+}
+
+// DBTX is the part of *sql.DB and *sql.Tx used to write outbox events, so
+// they can be added within the business transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// isNilDBTX reports whether tx is nil, including a nil *sql.Tx or *sql.DB
+// held by the interface.
+func isNilDBTX(tx DBTX) bool {
+	if tx == nil {
+		return true
+	}
+	v := reflect.ValueOf(tx)
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+// OutboxStore persists outbox events.
+type OutboxStore interface {
+	// Add writes events with tx, typically the business transaction.
+	Add(ctx context.Context, tx DBTX, events ...OutboxEvent) error
+	// Pending returns up to limit pending events that are due at now, oldest
+	// first. An event is due once its NextAttemptAt has passed and no earlier
+	// pending event of its aggregate key is backing off, so a key waiting for
+	// a retry does not hold back the other keys. The events are not claimed:
+	// a single relay must poll a store.
+	Pending(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error)
+	MarkPublished(ctx context.Context, ids ...int64) error
+	// MarkRetry records a failed attempt; the event is retried after next.
+	MarkRetry(ctx context.Context, id int64, lastErr string, next time.Time) error
+	// MarkFailed records a failed attempt and gives up on the event.
+	MarkFailed(ctx context.Context, id int64, lastErr string) error
+	// DeletePublished removes the events published before the given time.
+	DeletePublished(ctx context.Context, before time.Time) (int64, error)
+}
+
+// OutboxConfig holds the OutboxRelay settings
+type OutboxConfig struct {
+	PollInterval    time.Duration
+	BatchSize       int
+	MaxAttempts     int
+	RetryBackoff    time.Duration // Doubled after each failed attempt
+	MaxRetryBackoff time.Duration
+	Retention       time.Duration // Published events older than this are deleted; 0 keeps them
+	CleanupInterval time.Duration
+}
+
+// DefaultOutboxConfig holds the default OutboxRelay settings
+var DefaultOutboxConfig = OutboxConfig{
+	PollInterval:    time.Second,
+	BatchSize:       100,
+	MaxAttempts:     10,
+	RetryBackoff:    time.Second,
+	MaxRetryBackoff: 5 * time.Minute,
+	Retention:       7 * 24 * time.Hour,
+	CleanupInterval: time.Hour,
+}
+
+// OutboxOption is a functional option for configuring an OutboxRelay
+type OutboxOption func(*OutboxConfig)
+
+// WithOutboxPollInterval sets how often the outbox is polled
+func WithOutboxPollInterval(d time.Duration) OutboxOption {
+	return func(c *OutboxConfig) {
+		c.PollInterval = d
+	}
+}
+
+// WithOutboxBatchSize sets the number of events fetched per poll
+func WithOutboxBatchSize(n int) OutboxOption {
+	return func(c *OutboxConfig) {
+		c.BatchSize = n
+	}
+}
+
+// WithOutboxMaxAttempts sets the number of attempts before an event is marked failed
+func WithOutboxMaxAttempts(n int) OutboxOption {
+	return func(c *OutboxConfig) {
+		c.MaxAttempts = n
+	}
+}
+
+// WithOutboxRetryBackoff sets the initial and maximum delay between attempts
+func WithOutboxRetryBackoff(initial, max time.Duration) OutboxOption {
+	return func(c *OutboxConfig) {
+		c.RetryBackoff = initial
+		c.MaxRetryBackoff = max
+	}
+}
+
+// WithOutboxRetention sets how long published events are kept, 0 keeps them forever
+func WithOutboxRetention(d time.Duration) OutboxOption {
+	return func(c *OutboxConfig) {
+		c.Retention = d
+	}
+}
+
+// OutboxRelay publishes the pending events of an OutboxStore. Events are
+// decoded into the value registered for their name and sent through the
+// publisher registered for their topic, keyed by their aggregate key if it
+// is an IKeyedPublisher. Only one relay should run per store.
+type OutboxRelay struct {
+	store  OutboxStore
+	config OutboxConfig
+	now    func() time.Time
+
+	mu           sync.RWMutex
+	publishers   map[string]IPublisher
+	constructors map[string]func() interface{}
+}
+
+func NewOutboxRelay(store OutboxStore, opts ...OutboxOption) *OutboxRelay {
+	config := DefaultOutboxConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return &OutboxRelay{
+		store:        store,
+		config:       config,
+		now:          time.Now,
+		publishers:   make(map[string]IPublisher),
+		constructors: make(map[string]func() interface{}),
+	}
+}
+
+// RegisterPublisher sets the publisher of the events of topic.
+func (r *OutboxRelay) RegisterPublisher(topic string, publisher IPublisher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.publishers[topic] = publisher
+}
+
+// RegisterEvent sets the constructor of the value the payloads of the events
+// named name are decoded into before being published.
+func (r *OutboxRelay) RegisterEvent(name string, constructor func() interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.constructors[name] = constructor
+}
+
+// Run polls the outbox until ctx is done.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		if _, err := r.ProcessOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to process outbox: %s", err)
+		}
+		if r.config.Retention > 0 && r.now().Sub(lastCleanup) >= r.config.CleanupInterval {
+			lastCleanup = r.now()
+			if _, err := r.Cleanup(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to clean up outbox: %s", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessOnce publishes one batch of pending events and returns the number
+// of events published. Events of the same aggregate key are published in
+// order: a failed event holds back the following ones until it succeeds or
+// is given up.
+func (r *OutboxRelay) ProcessOnce(ctx context.Context) (int, error) {
+	events, err := r.store.Pending(ctx, r.now(), r.config.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch outbox events: %w", err)
+	}
+
+	var groups [][]OutboxEvent
+	byKey := make(map[string]int)
+	for _, e := range events {
+		if e.AggregateKey == "" {
+			groups = append(groups, []OutboxEvent{e})
+			continue
+		}
+		i, ok := byKey[e.AggregateKey]
+		if !ok {
+			i = len(groups)
+			byKey[e.AggregateKey] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], e)
+	}
+
+	published := 0
+	for _, group := range groups {
+		for _, e := range group {
+			if ctx.Err() != nil {
+				return published, ctx.Err()
+			}
+			if e.NextAttemptAt.After(r.now()) {
+				break
+			}
+			if err := r.publish(ctx, e); err != nil {
+				gaveUp, err := r.markFailure(ctx, e, err)
+				if err != nil {
+					return published, err
+				}
+				if !gaveUp {
+					break
+				}
+				continue
+			}
+			if err := r.store.MarkPublished(ctx, e.ID); err != nil {
+				return published, fmt.Errorf("failed to mark outbox event %d published: %w", e.ID, err)
+			}
+			published++
+		}
+	}
+	return published, nil
+}
+
+// Cleanup deletes the published events older than the retention.
+func (r *OutboxRelay) Cleanup(ctx context.Context) (int64, error) {
+	if r.config.Retention <= 0 {
+		return 0, nil
+	}
+	return r.store.DeletePublished(ctx, r.now().Add(-r.config.Retention))
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, e OutboxEvent) error {
+	r.mu.RLock()
+	publisher, ok := r.publishers[e.Topic]
+	constructor, hasConstructor := r.constructors[e.EventName]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no publisher registered for topic %s", e.Topic)
+	}
+	if !hasConstructor {
+		return fmt.Errorf("no constructor registered for event %s", e.EventName)
+	}
+
+	value := constructor()
+	if err := json.Unmarshal(e.Payload, value); err != nil {
+		return errorx.Tag(fmt.Errorf("failed to decode outbox event %d: %w", e.ID, err), errorx.ClassValidation)
+	}
+	keyed, ok := publisher.(IKeyedPublisher)
+	if !ok {
+		return publisher.SendMessage(ctx, value)
+	}
+	msg := ProducerMessage{Value: value}
+	if e.AggregateKey != "" {
+		msg.Key = []byte(e.AggregateKey)
+	}
+	return keyed.Publish(ctx, msg)
+}
+
+// markFailure records a failed attempt and reports whether the event was
+// given up. Validation errors, such as undecodable payloads, are given up
+// immediately since retrying cannot fix them.
+func (r *OutboxRelay) markFailure(ctx context.Context, e OutboxEvent, cause error) (bool, error) {
+	attempts := e.Attempts + 1
+	if attempts >= r.config.MaxAttempts || errorx.IsValidation(cause) {
+		log.Printf("Giving up outbox event %d after %d attempts: %s", e.ID, attempts, cause)
+		if err := r.store.MarkFailed(ctx, e.ID, cause.Error()); err != nil {
+			return false, fmt.Errorf("failed to mark outbox event %d failed: %w", e.ID, err)
+		}
+		return true, nil
+	}
+
+	backoff := r.config.RetryBackoff << (attempts - 1)
+	if backoff <= 0 || backoff > r.config.MaxRetryBackoff {
+		backoff = r.config.MaxRetryBackoff
+	}
+	if err := r.store.MarkRetry(ctx, e.ID, cause.Error(), r.now().Add(backoff)); err != nil {
+		return false, fmt.Errorf("failed to mark outbox event %d for retry: %w", e.ID, err)
+	}
+	return false, nil
+}
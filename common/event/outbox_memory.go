@@ -0,0 +1,125 @@
+package event
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryOutboxStore is an OutboxStore keeping events in memory, for tests.
+// The tx passed to Add is ignored.
+type MemoryOutboxStore struct {
+	mu     sync.Mutex
+	events []OutboxEvent
+	nextID int64
+}
+
+var _ OutboxStore = (*MemoryOutboxStore)(nil)
+
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{nextID: 1}
+}
+
+func (s *MemoryOutboxStore) Add(_ context.Context, _ DBTX, events ...OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, e := range events {
+		e.ID = s.nextID
+		s.nextID++
+		e.Status = OutboxPending
+		if e.CreatedAt.IsZero() {
+			e.CreatedAt = now
+		}
+		s.events = append(s.events, e)
+	}
+	return nil
+}
+
+func (s *MemoryOutboxStore) Pending(_ context.Context, now time.Time, limit int) ([]OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []OutboxEvent
+	backingOff := make(map[string]bool)
+	for _, e := range s.events {
+		if e.Status != OutboxPending || backingOff[e.AggregateKey] {
+			continue
+		}
+		if e.NextAttemptAt.After(now) {
+			if e.AggregateKey != "" {
+				backingOff[e.AggregateKey] = true
+			}
+			continue
+		}
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (s *MemoryOutboxStore) MarkPublished(_ context.Context, ids ...int64) error {
+	now := time.Now()
+	s.update(func(e *OutboxEvent) {
+		e.Status = OutboxPublished
+		e.PublishedAt = &now
+	}, ids...)
+	return nil
+}
+
+func (s *MemoryOutboxStore) MarkRetry(_ context.Context, id int64, lastErr string, next time.Time) error {
+	s.update(func(e *OutboxEvent) {
+		e.Attempts++
+		e.LastError = lastErr
+		e.NextAttemptAt = next
+	}, id)
+	return nil
+}
+
+func (s *MemoryOutboxStore) MarkFailed(_ context.Context, id int64, lastErr string) error {
+	s.update(func(e *OutboxEvent) {
+		e.Attempts++
+		e.LastError = lastErr
+		e.Status = OutboxFailed
+	}, id)
+	return nil
+}
+
+func (s *MemoryOutboxStore) DeletePublished(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.events[:0]
+	var deleted int64
+	for _, e := range s.events {
+		if e.Status == OutboxPublished && e.PublishedAt.Before(before) {
+			deleted++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	s.events = kept
+	return deleted, nil
+}
+
+// Events returns a copy of all the events of the store, ordered by ID.
+func (s *MemoryOutboxStore) Events() []OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := append([]OutboxEvent(nil), s.events...)
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (s *MemoryOutboxStore) update(fn func(*OutboxEvent), ids ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.events {
+		for _, id := range ids {
+			if s.events[i].ID == id {
+				fn(&s.events[i])
+			}
+		}
+	}
+}
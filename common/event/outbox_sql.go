@@ -0,0 +1,177 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLOutboxStore is an OutboxStore backed by a database/sql table. The table
+// must exist; on PostgreSQL it is created with:
+//
+//	CREATE TABLE event_outbox (
+//		id              BIGSERIAL PRIMARY KEY,
+//		topic           TEXT NOT NULL,
+//		aggregate_key   TEXT NOT NULL DEFAULT '',
+//		event_name      TEXT NOT NULL,
+//		payload         BYTEA NOT NULL,
+//		status          TEXT NOT NULL DEFAULT 'pending',
+//		attempts        INT NOT NULL DEFAULT 0,
+//		last_error      TEXT NOT NULL DEFAULT '',
+//		next_attempt_at TIMESTAMPTZ NOT NULL,
+//		created_at      TIMESTAMPTZ NOT NULL,
+//		published_at    TIMESTAMPTZ
+//	);
+//	CREATE INDEX event_outbox_pending ON event_outbox (status, id);
+//	CREATE INDEX event_outbox_key ON event_outbox (aggregate_key, status, id);
+//
+// Pending does not lock the rows it returns, so relays polling the same table
+// would publish the events twice and out of order. Run a single OutboxRelay
+// per table, e.g. in one replica or behind a leader election.
+type SQLOutboxStore struct {
+	db          *sql.DB
+	table       string
+	placeholder func(n int) string
+}
+
+var _ OutboxStore = (*SQLOutboxStore)(nil)
+
+// SQLOutboxOption is a functional option for configuring a SQLOutboxStore
+type SQLOutboxOption func(*SQLOutboxStore)
+
+// WithOutboxTable sets the name of the outbox table, "event_outbox" by default
+func WithOutboxTable(table string) SQLOutboxOption {
+	return func(s *SQLOutboxStore) {
+		s.table = table
+	}
+}
+
+// WithOutboxQuestionPlaceholders uses "?" placeholders (MySQL, SQLite)
+// instead of the PostgreSQL "$1" style
+func WithOutboxQuestionPlaceholders() SQLOutboxOption {
+	return func(s *SQLOutboxStore) {
+		s.placeholder = func(int) string { return "?" }
+	}
+}
+
+func NewSQLOutboxStore(db *sql.DB, opts ...SQLOutboxOption) *SQLOutboxStore {
+	s := &SQLOutboxStore{
+		db:          db,
+		table:       "event_outbox",
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Add inserts events with tx. A nil tx, or a nil *sql.Tx, uses the store's
+// database outside of any transaction.
+func (s *SQLOutboxStore) Add(ctx context.Context, tx DBTX, events ...OutboxEvent) error {
+	if isNilDBTX(tx) {
+		tx = s.db
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (topic, aggregate_key, event_name, payload, status, attempts, last_error, next_attempt_at, created_at) VALUES (%s)",
+		s.table, s.placeholders(1, 9))
+	now := time.Now().UTC()
+	for _, e := range events {
+		createdAt := e.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = now
+		}
+		_, err := tx.ExecContext(ctx, query,
+			e.Topic, e.AggregateKey, e.EventName, e.Payload, string(OutboxPending), 0, "", createdAt, createdAt)
+		if err != nil {
+			return fmt.Errorf("failed to add outbox event: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLOutboxStore) Pending(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error) {
+	// An event is skipped while an earlier event of its key is backing off
+	query := fmt.Sprintf(
+		"SELECT id, topic, aggregate_key, event_name, payload, status, attempts, last_error, next_attempt_at, created_at, published_at FROM %[1]s e "+
+			"WHERE e.status = %[2]s AND e.next_attempt_at <= %[3]s AND NOT EXISTS ("+
+			"SELECT 1 FROM %[1]s b WHERE b.aggregate_key = e.aggregate_key AND b.aggregate_key <> '' "+
+			"AND b.status = %[4]s AND b.id < e.id AND b.next_attempt_at > %[5]s) ORDER BY e.id LIMIT %[6]d",
+		s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3), s.placeholder(4), limit)
+	now = now.UTC()
+	rows, err := s.db.QueryContext(ctx, query, string(OutboxPending), now, string(OutboxPending), now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []OutboxEvent
+	for rows.Next() {
+		var (
+			e           OutboxEvent
+			status      string
+			publishedAt sql.NullTime
+		)
+		err := rows.Scan(&e.ID, &e.Topic, &e.AggregateKey, &e.EventName, &e.Payload, &status,
+			&e.Attempts, &e.LastError, &e.NextAttemptAt, &e.CreatedAt, &publishedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Status = OutboxStatus(status)
+		if publishedAt.Valid {
+			e.PublishedAt = &publishedAt.Time
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLOutboxStore) MarkPublished(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{string(OutboxPublished), time.Now().UTC()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := fmt.Sprintf("UPDATE %s SET status = %s, published_at = %s WHERE id IN (%s)",
+		s.table, s.placeholder(1), s.placeholder(2), s.placeholders(3, len(ids)))
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *SQLOutboxStore) MarkRetry(ctx context.Context, id int64, lastErr string, next time.Time) error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = %s, next_attempt_at = %s WHERE id = %s",
+		s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3))
+	_, err := s.db.ExecContext(ctx, query, lastErr, next.UTC(), id)
+	return err
+}
+
+func (s *SQLOutboxStore) MarkFailed(ctx context.Context, id int64, lastErr string) error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = %s, status = %s WHERE id = %s",
+		s.table, s.placeholder(1), s.placeholder(2), s.placeholder(3))
+	_, err := s.db.ExecContext(ctx, query, lastErr, string(OutboxFailed), id)
+	return err
+}
+
+func (s *SQLOutboxStore) DeletePublished(ctx context.Context, before time.Time) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE status = %s AND published_at < %s",
+		s.table, s.placeholder(1), s.placeholder(2))
+	res, err := s.db.ExecContext(ctx, query, string(OutboxPublished), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// placeholders returns n comma separated placeholders starting at from.
+func (s *SQLOutboxStore) placeholders(from, n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = s.placeholder(from + i)
+	}
+	return strings.Join(p, ", ")
+}
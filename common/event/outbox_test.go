@@ -0,0 +1,265 @@
+package event
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+)
+
+type orderCreated struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+func (orderCreated) EventName() string { return "OrderCreated" }
+
+type recordingPublisher struct {
+	mu    sync.Mutex
+	sent  []interface{}
+	keys  []string
+	fails map[string]int // order ID -> remaining failures
+	err   error
+}
+
+func (p *recordingPublisher) SendMessage(ctx context.Context, value interface{}) error {
+	return p.Publish(ctx, ProducerMessage{Value: value})
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, msg ProducerMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := msg.Value.(*orderCreated).ID
+	if p.fails[id] > 0 {
+		p.fails[id]--
+		return p.err
+	}
+	p.sent = append(p.sent, msg.Value)
+	p.keys = append(p.keys, string(msg.Key))
+	return nil
+}
+
+func (p *recordingPublisher) ids() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ids []string
+	for _, v := range p.sent {
+		ids = append(ids, v.(*orderCreated).ID)
+	}
+	return ids
+}
+
+func newTestRelay(t *testing.T, store OutboxStore, publisher IPublisher, opts ...OutboxOption) *OutboxRelay {
+	t.Helper()
+	relay := NewOutboxRelay(store, opts...)
+	relay.RegisterPublisher("orders", publisher)
+	relay.RegisterEvent("OrderCreated", func() interface{} { return &orderCreated{} })
+	return relay
+}
+
+func addOrders(t *testing.T, store OutboxStore, keyed map[string]string, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		evt, err := NewOutboxEvent("orders", keyed[id], orderCreated{ID: id, Total: 10})
+		assert.NoError(t, err)
+		assert.NoError(t, store.Add(context.Background(), nil, evt))
+	}
+}
+
+func TestNewOutboxEvent(t *testing.T) {
+	evt, err := NewOutboxEvent("orders", "order-1", orderCreated{ID: "order-1", Total: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, "OrderCreated", evt.EventName)
+	assert.JSONEq(t, `{"id": "order-1", "total": 10}`, string(evt.Payload))
+
+	type plain struct{ A int }
+	evt, err = NewOutboxEvent("misc", "", &plain{A: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", evt.EventName)
+
+	_, err = NewOutboxEvent("misc", "", make(chan int))
+	assert.True(t, errorx.IsValidation(err))
+}
+
+func TestOutboxRelayPublishesInOrderPerKey(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	publisher := &recordingPublisher{fails: map[string]int{"a1": 1}, err: errors.New("broker down")}
+	relay := newTestRelay(t, store, publisher, WithOutboxRetryBackoff(time.Minute, time.Hour))
+	keys := map[string]string{"a1": "a", "a2": "a", "b1": "b"}
+	addOrders(t, store, keys, "a1", "b1", "a2")
+
+	n, err := relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"b1"}, publisher.ids(), "a2 waits for a1")
+
+	events := store.Events()
+	assert.Equal(t, 1, events[0].Attempts)
+	assert.Equal(t, "broker down", events[0].LastError)
+	assert.Equal(t, OutboxPending, events[0].Status)
+
+	// a1 is backing off, so nothing is published yet
+	n, err = relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	relay.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	n, err = relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"b1", "a1", "a2"}, publisher.ids())
+	assert.Equal(t, []string{"b", "a", "a"}, publisher.keys)
+}
+
+func TestOutboxRelayBackingOffKeyDoesNotBlockOthers(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	publisher := &recordingPublisher{fails: map[string]int{"a1": 1}, err: errors.New("broker down")}
+	relay := newTestRelay(t, store, publisher, WithOutboxBatchSize(2), WithOutboxRetryBackoff(time.Minute, time.Hour))
+	keys := map[string]string{"a1": "a", "a2": "a", "a3": "a", "b1": "b"}
+	addOrders(t, store, keys, "a1", "a2", "a3", "b1")
+
+	n, err := relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n, "a1 failed and holds back a2")
+
+	// The batch holds more events of a than BatchSize, yet b1 is fetched
+	n, err = relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"b1"}, publisher.ids())
+
+	relay.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	n, err = relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"b1", "a1", "a2", "a3"}, publisher.ids())
+}
+
+// sendOnlyPublisher is an IPublisher without Publish.
+type sendOnlyPublisher struct {
+	p *recordingPublisher
+}
+
+func (s sendOnlyPublisher) SendMessage(ctx context.Context, value interface{}) error {
+	return s.p.SendMessage(ctx, value)
+}
+
+func TestOutboxRelayUnkeyedPublisher(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	publisher := &recordingPublisher{}
+	relay := newTestRelay(t, store, sendOnlyPublisher{publisher})
+	addOrders(t, store, map[string]string{"a1": "a"}, "a1")
+
+	n, err := relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"a1"}, publisher.ids())
+	assert.Equal(t, []string{""}, publisher.keys)
+}
+
+func TestOutboxRelayGivesUp(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	publisher := &recordingPublisher{fails: map[string]int{"a1": 5}, err: errors.New("broker down")}
+	relay := newTestRelay(t, store, publisher, WithOutboxMaxAttempts(2), WithOutboxRetryBackoff(0, 0))
+	addOrders(t, store, map[string]string{"a1": "a", "a2": "a"}, "a1", "a2")
+
+	_, err := relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	_, err = relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+
+	events := store.Events()
+	assert.Equal(t, OutboxFailed, events[0].Status)
+	assert.Equal(t, 2, events[0].Attempts)
+	assert.Equal(t, OutboxPublished, events[1].Status)
+	assert.Equal(t, []string{"a2"}, publisher.ids())
+
+	// Undecodable payloads are given up at once
+	store.Add(context.Background(), nil, OutboxEvent{Topic: "orders", EventName: "OrderCreated", Payload: []byte("{")})
+	_, err = relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	events = store.Events()
+	assert.Equal(t, OutboxFailed, events[2].Status)
+	assert.Equal(t, 1, events[2].Attempts)
+}
+
+func TestOutboxRelayCleanup(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	relay := newTestRelay(t, store, &recordingPublisher{}, WithOutboxRetention(time.Hour))
+	addOrders(t, store, nil, "a1", "a2")
+
+	n, err := relay.ProcessOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	deleted, err := relay.Cleanup(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	relay.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	deleted, err = relay.Cleanup(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.Empty(t, store.Events())
+}
+
+func TestOutboxRelayRun(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	publisher := &recordingPublisher{}
+	relay := newTestRelay(t, store, publisher, WithOutboxPollInterval(10*time.Millisecond))
+	addOrders(t, store, nil, "a1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- relay.Run(ctx) }()
+
+	assert.Eventually(t, func() bool { return len(publisher.ids()) == 1 }, time.Second, 10*time.Millisecond)
+	addOrders(t, store, nil, "a2")
+	assert.Eventually(t, func() bool { return len(publisher.ids()) == 2 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+type recordingExecutor struct {
+	queries []string
+	args    [][]interface{}
+}
+
+func (e *recordingExecutor) ExecContext(_ context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.queries = append(e.queries, query)
+	e.args = append(e.args, args)
+	return driver.RowsAffected(1), nil
+}
+
+func TestSQLOutboxStoreAdd(t *testing.T) {
+	tx := &recordingExecutor{}
+	evt, err := NewOutboxEvent("orders", "order-1", orderCreated{ID: "order-1"})
+	assert.NoError(t, err)
+
+	store := NewSQLOutboxStore(nil)
+	assert.NoError(t, store.Add(context.Background(), tx, evt))
+	assert.Equal(t, "INSERT INTO event_outbox (topic, aggregate_key, event_name, payload, status, attempts, last_error, next_attempt_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)", tx.queries[0])
+	assert.Equal(t, []interface{}{"orders", "order-1", "OrderCreated"}, tx.args[0][:3])
+
+	store = NewSQLOutboxStore(nil, WithOutboxTable("outbox"), WithOutboxQuestionPlaceholders())
+	assert.NoError(t, store.Add(context.Background(), tx, evt))
+	assert.Contains(t, tx.queries[1], "INSERT INTO outbox (")
+	assert.Contains(t, tx.queries[1], "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+}
+
+func TestIsNilDBTX(t *testing.T) {
+	var tx *sql.Tx
+	assert.True(t, isNilDBTX(nil))
+	assert.True(t, isNilDBTX(tx))
+	assert.False(t, isNilDBTX(&recordingExecutor{}))
+}
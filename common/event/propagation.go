@@ -0,0 +1,52 @@
+package event
+
+import (
+	"context"
+
+	"github.com/solum-sp/aps-be-common/common/utils"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Header names carrying the correlation IDs of a message. The trace context
+// uses the W3C "traceparent" and "tracestate" headers.
+const (
+	HeaderRequestID = "x-request-id"
+	HeaderTenantID  = "x-tenant-id"
+)
+
+var tracePropagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// InjectHeaders returns a copy of headers with the trace context, request ID
+// and tenant ID of ctx added. Headers already set are kept.
+func InjectHeaders(ctx context.Context, headers map[string]string) map[string]string {
+	out := make(map[string]string, len(headers)+4)
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	for k, v := range carrier {
+		out[k] = v
+	}
+	if id := utils.RequestIDFromContext(ctx); id != "" {
+		out[HeaderRequestID] = id
+	}
+	if id := utils.TenantIDFromContext(ctx); id != "" {
+		out[HeaderTenantID] = id
+	}
+	for k, v := range headers {
+		out[k] = v
+	}
+	return out
+}
+
+// ContextFromHeaders returns a copy of ctx carrying the trace context,
+// request ID and tenant ID found in headers. The trace context becomes the
+// remote parent of the spans started from the returned context.
+func ContextFromHeaders(ctx context.Context, headers map[string]string) context.Context {
+	ctx = tracePropagator.Extract(ctx, propagation.MapCarrier(headers))
+	if id := headers[HeaderRequestID]; id != "" {
+		ctx = utils.WithRequestID(ctx, id)
+	}
+	if id := headers[HeaderTenantID]; id != "" {
+		ctx = utils.WithTenantID(ctx, id)
+	}
+	return ctx
+}
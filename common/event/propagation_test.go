@@ -0,0 +1,51 @@
+package event
+
+import (
+	"context"
+	"testing"
+
+	"github.com/solum-sp/aps-be-common/common/utils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPropagationRoundTrip(t *testing.T) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01, 0x02},
+		SpanID:     trace.SpanID{0x03},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	ctx = utils.WithRequestID(ctx, "req-1")
+	ctx = utils.WithTenantID(ctx, "tenant-1")
+
+	headers := InjectHeaders(ctx, map[string]string{"event": "OrderCreated"})
+	assert.Equal(t, "00-01020000000000000000000000000000-0300000000000000-01", headers["traceparent"])
+	assert.Equal(t, "req-1", headers[HeaderRequestID])
+	assert.Equal(t, "tenant-1", headers[HeaderTenantID])
+	assert.Equal(t, "OrderCreated", headers["event"])
+
+	got := ContextFromHeaders(context.Background(), headers)
+	remote := trace.SpanContextFromContext(got)
+	assert.True(t, remote.IsRemote())
+	assert.Equal(t, sc.TraceID(), remote.TraceID())
+	assert.Equal(t, sc.SpanID(), remote.SpanID())
+	assert.True(t, remote.IsSampled())
+	assert.Equal(t, "req-1", utils.RequestIDFromContext(got))
+	assert.Equal(t, "tenant-1", utils.TenantIDFromContext(got))
+}
+
+func TestInjectHeadersKeepsExplicitHeaders(t *testing.T) {
+	ctx := utils.WithRequestID(context.Background(), "from-context")
+
+	headers := InjectHeaders(ctx, map[string]string{HeaderRequestID: "explicit"})
+	assert.Equal(t, "explicit", headers[HeaderRequestID])
+	assert.NotContains(t, headers, "traceparent")
+	assert.NotContains(t, headers, HeaderTenantID)
+}
+
+func TestContextFromHeadersWithoutTrace(t *testing.T) {
+	ctx := ContextFromHeaders(context.Background(), nil)
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+	assert.Empty(t, utils.RequestIDFromContext(ctx))
+}
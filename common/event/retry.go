@@ -0,0 +1,312 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+Retry topics and dead-letter queue: a message whose handler keeps failing is
+forwarded to the next retry topic, consumed again once its delay elapsed, and
+finally to the DLQ topic. With the default policy, a message of "orders" goes
+through "orders.retry.1m", "orders.retry.10m" and ends in "orders.dlq". The
+topics must exist.
+
+USAGE EXAMPLE:
+
+	err := subscriber.Consume(ctx, handler,
+		event.WithRetryPolicy(event.DefaultRetryPolicy, producer))
+
+	// Later, once the cause is fixed
+	replayer := event.NewDLQReplayer(dlqConsumer, producer)
+	result, err := replayer.Replay(ctx, "orders.dlq", nil)
+*/
+
+// Headers added to forwarded messages. The original headers are kept.
+const (
+	HeaderRetryAttempt      = "x-retry-attempt"    // Number of times the message was forwarded
+	HeaderRetryNotBefore    = "x-retry-not-before" // Unix milliseconds before which it is not handled
+	HeaderError             = "x-error"            // Error of the last failed attempt
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+)
+
+// RetryTopic is a delayed retry stage. Its topic is the source topic
+// followed by "." and Suffix.
+type RetryTopic struct {
+	Suffix string
+	Delay  time.Duration
+}
+
+// RetryPolicy decides what happens to a message whose handler fails: it is
+// first retried in process, then forwarded to each retry topic in turn, then
+// to the DLQ. Validation errors (errorx.IsValidation) go to the DLQ directly.
+type RetryPolicy struct {
+	Attempts    int           // In-process attempts per delivery
+	Backoff     time.Duration // Delay between in-process attempts, doubled each time
+	MaxBackoff  time.Duration
+	RetryTopics []RetryTopic
+	DLQSuffix   string // DLQ topic suffix; empty drops messages that ran out of retries
+}
+
+// DefaultRetryPolicy holds the default retry policy
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	Backoff:    100 * time.Millisecond,
+	MaxBackoff: time.Second,
+	RetryTopics: []RetryTopic{
+		{Suffix: "retry.1m", Delay: time.Minute},
+		{Suffix: "retry.10m", Delay: 10 * time.Minute},
+	},
+	DLQSuffix: "dlq",
+}
+
+// Topics returns the retry topics of source.
+func (p RetryPolicy) Topics(source string) []string {
+	topics := make([]string, len(p.RetryTopics))
+	for i, rt := range p.RetryTopics {
+		topics[i] = source + "." + rt.Suffix
+	}
+	return topics
+}
+
+// DLQTopic returns the DLQ topic of source, or "" if the policy has none.
+func (p RetryPolicy) DLQTopic(source string) string {
+	if p.DLQSuffix == "" {
+		return ""
+	}
+	return source + "." + p.DLQSuffix
+}
+
+// MessageProducer sends raw messages; *kafka.Producer implements it.
+type MessageProducer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+}
+
+// WithRetryPolicy makes Consume handle failures with policy, forwarding
+// messages through producer. Consume then also subscribes to the retry
+// topics and holds their messages until their delay elapsed.
+func WithRetryPolicy(policy RetryPolicy, producer MessageProducer) ConsumeOption {
+	return func(c *ConsumeConfig) {
+		c.Retry = &policy
+		c.RetryProducer = producer
+	}
+}
+
+// retryHandler wraps next with the in-process retries and the forwarding of
+// the policy. It only fails if the message could not be forwarded.
+func (p RetryPolicy) retryHandler(next Handler, producer MessageProducer) Handler {
+	return func(ctx context.Context, msg Message) error {
+		err := p.attempt(ctx, next, msg)
+		if err == nil {
+			return nil
+		}
+
+		source := msg.Topic
+		if original := msg.Headers[HeaderOriginalTopic]; original != "" {
+			source = original
+		}
+		attempt, _ := strconv.Atoi(msg.Headers[HeaderRetryAttempt])
+
+		headers := make(map[string]string, len(msg.Headers)+6)
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		if headers[HeaderOriginalTopic] == "" {
+			headers[HeaderOriginalTopic] = msg.Topic
+			headers[HeaderOriginalPartition] = strconv.Itoa(int(msg.Partition))
+			headers[HeaderOriginalOffset] = strconv.FormatInt(msg.Offset, 10)
+		}
+		headers[HeaderRetryAttempt] = strconv.Itoa(attempt + 1)
+		headers[HeaderError] = err.Error()
+		delete(headers, HeaderRetryNotBefore)
+
+		var dest string
+		if attempt < len(p.RetryTopics) && !errorx.IsValidation(err) {
+			stage := p.RetryTopics[attempt]
+			dest = source + "." + stage.Suffix
+			headers[HeaderRetryNotBefore] = strconv.FormatInt(time.Now().Add(stage.Delay).UnixMilli(), 10)
+		} else if dest = p.DLQTopic(source); dest == "" {
+			log.Printf("Dropping message %s[%d]@%d after %d attempts: %s", msg.Topic, msg.Partition, msg.Offset, attempt+1, err)
+			return nil
+		}
+
+		fwd := &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &dest, Partition: kafka.PartitionAny},
+			Key:            msg.Key,
+			Value:          msg.Value,
+			Headers:        toKafkaHeaders(headers),
+			Timestamp:      msg.Timestamp,
+		}
+		if ferr := produceSync(ctx, producer, fwd); ferr != nil {
+			return fmt.Errorf("failed to forward message to %s: %w (handler error: %s)", dest, ferr, err)
+		}
+		return nil
+	}
+}
+
+// attempt runs next up to p.Attempts times with backoff.
+func (p RetryPolicy) attempt(ctx context.Context, next Handler, msg Message) error {
+	backoff := p.Backoff
+	var err error
+	for i := 0; i < max(p.Attempts, 1); i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		}
+		if err = next(ctx, msg); err == nil || errorx.IsValidation(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// notBefore returns the time before which msg must not be handled, or the
+// zero time.
+func notBefore(msg Message) time.Time {
+	ms, err := strconv.ParseInt(msg.Headers[HeaderRetryNotBefore], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// produceSync sends msg and waits for its delivery report.
+func produceSync(ctx context.Context, producer MessageProducer, msg *kafka.Message) error {
+	deliveries := make(chan kafka.Event, 1)
+	if err := producer.Produce(msg, deliveries); err != nil {
+		return classify(fmt.Errorf("produce failed: %w", err))
+	}
+	select {
+	case e := <-deliveries:
+		if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+			return classify(fmt.Errorf("delivery failed: %w", m.TopicPartition.Error))
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// messageReader is the part of *kafka.Consumer used by DLQReplayer.
+type messageReader interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error)
+}
+
+// ReplayResult counts the messages handled by DLQReplayer.Replay.
+type ReplayResult struct {
+	Replayed int
+	Skipped  int // Rejected by the filter; committed without being replayed
+	Failed   int // Without an x-original-topic header; committed without being replayed
+}
+
+// DLQReplayer sends the messages of a DLQ back onto their source topic.
+type DLQReplayer struct {
+	consumer messageReader
+	producer MessageProducer
+	idle     time.Duration
+}
+
+// NewDLQReplayer returns a replayer reading with consumer, which should use
+// a dedicated consumer group, and sending with producer.
+func NewDLQReplayer(consumer *kafka.Consumer, producer MessageProducer) *DLQReplayer {
+	return newDLQReplayer(consumer, producer)
+}
+
+func newDLQReplayer(consumer messageReader, producer MessageProducer) *DLQReplayer {
+	return &DLQReplayer{consumer: consumer, producer: producer, idle: 5 * time.Second}
+}
+
+// Replay sends the messages of dlqTopic accepted by filter, or all of them if
+// filter is nil, to the topic they originally came from, with their retry
+// headers removed so they get a full retry budget. It stops once no message
+// arrived for a few seconds, or when ctx is done. Each message is committed
+// once replayed or skipped. Messages without an original topic cannot be
+// replayed; they are counted as failed and committed, so they do not stall
+// the DLQ.
+func (r *DLQReplayer) Replay(ctx context.Context, dlqTopic string, filter func(Message) bool) (ReplayResult, error) {
+	var result ReplayResult
+	if err := r.consumer.SubscribeTopics([]string{dlqTopic}, nil); err != nil {
+		return result, classify(fmt.Errorf("failed to subscribe to %s: %w", dlqTopic, err))
+	}
+
+	for ctx.Err() == nil {
+		km, err := r.consumer.ReadMessage(r.idle)
+		if err != nil {
+			var kerr kafka.Error
+			if errors.As(err, &kerr) && kerr.Code() == kafka.ErrTimedOut {
+				return result, nil
+			}
+			return result, classify(fmt.Errorf("failed to read %s: %w", dlqTopic, err))
+		}
+		msg := Message{
+			Partition: km.TopicPartition.Partition,
+			Offset:    int64(km.TopicPartition.Offset),
+			Key:       km.Key,
+			Value:     km.Value,
+			Headers:   fromKafkaHeaders(km.Headers),
+			Timestamp: km.Timestamp,
+		}
+		if km.TopicPartition.Topic != nil {
+			msg.Topic = *km.TopicPartition.Topic
+		}
+
+		switch {
+		case filter != nil && !filter(msg):
+			result.Skipped++
+		case msg.Headers[HeaderOriginalTopic] == "":
+			log.Printf("Cannot replay message %s[%d]@%d: no %s header", msg.Topic, msg.Partition, msg.Offset, HeaderOriginalTopic)
+			result.Failed++
+		default:
+			if err := r.replay(ctx, msg); err != nil {
+				return result, err
+			}
+			result.Replayed++
+		}
+		if _, err := r.consumer.CommitMessage(km); err != nil {
+			return result, classify(fmt.Errorf("offset commit error: %w", err))
+		}
+	}
+	return result, ctx.Err()
+}
+
+func (r *DLQReplayer) replay(ctx context.Context, msg Message) error {
+	source := msg.Headers[HeaderOriginalTopic]
+	headers := make(map[string]string, len(msg.Headers))
+	for k, v := range msg.Headers {
+		switch k {
+		case HeaderRetryAttempt, HeaderRetryNotBefore, HeaderError,
+			HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset:
+			continue
+		}
+		headers[k] = v
+	}
+	err := produceSync(ctx, r.producer, &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &source, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        toKafkaHeaders(headers),
+		Timestamp:      msg.Timestamp,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replay message %s[%d]@%d to %s: %w", msg.Topic, msg.Partition, msg.Offset, source, err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProducer records produced messages and reports them delivered.
+type fakeProducer struct {
+	mu       sync.Mutex
+	messages []*kafka.Message
+	err      error
+}
+
+func (p *fakeProducer) Produce(msg *kafka.Message, deliveries chan kafka.Event) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.mu.Lock()
+	p.messages = append(p.messages, msg)
+	p.mu.Unlock()
+	deliveries <- msg
+	return nil
+}
+
+func (p *fakeProducer) produced() []*kafka.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*kafka.Message(nil), p.messages...)
+}
+
+var testRetryPolicy = RetryPolicy{
+	Attempts: 2,
+	Backoff:  time.Millisecond,
+	RetryTopics: []RetryTopic{
+		{Suffix: "retry.1m", Delay: time.Minute},
+		{Suffix: "retry.10m", Delay: 10 * time.Minute},
+	},
+	DLQSuffix: "dlq",
+}
+
+func TestRetryHandlerForwardsThroughStages(t *testing.T) {
+	failure := errors.New("downstream unavailable")
+	tests := []struct {
+		name         string
+		topic        string
+		headers      map[string]string
+		err          error
+		wantTopic    string
+		wantAttempt  string
+		wantDelay    bool
+		wantAttempts int
+	}{
+		{name: "source to first retry topic", topic: "orders", err: failure,
+			wantTopic: "orders.retry.1m", wantAttempt: "1", wantDelay: true, wantAttempts: 2},
+		{name: "first to second retry topic", topic: "orders.retry.1m",
+			headers: map[string]string{HeaderOriginalTopic: "orders", HeaderRetryAttempt: "1"}, err: failure,
+			wantTopic: "orders.retry.10m", wantAttempt: "2", wantDelay: true, wantAttempts: 2},
+		{name: "last retry topic to DLQ", topic: "orders.retry.10m",
+			headers: map[string]string{HeaderOriginalTopic: "orders", HeaderRetryAttempt: "2"}, err: failure,
+			wantTopic: "orders.dlq", wantAttempt: "3", wantAttempts: 2},
+		{name: "validation error straight to DLQ", topic: "orders",
+			err:       errorx.Tag(errors.New("bad payload"), errorx.ClassValidation),
+			wantTopic: "orders.dlq", wantAttempt: "1", wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &fakeProducer{}
+			attempts := 0
+			handler := testRetryPolicy.retryHandler(func(ctx context.Context, msg Message) error {
+				attempts++
+				return tt.err
+			}, producer)
+
+			headers := map[string]string{"traceparent": "00-01-02-01"}
+			for k, v := range tt.headers {
+				headers[k] = v
+			}
+			err := handler(context.Background(), Message{Topic: tt.topic, Partition: 3, Offset: 42, Key: []byte("k"), Value: []byte("v"), Headers: headers})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAttempts, attempts)
+
+			produced := producer.produced()
+			require.Len(t, produced, 1)
+			fwd := produced[0]
+			assert.Equal(t, tt.wantTopic, *fwd.TopicPartition.Topic)
+			assert.Equal(t, []byte("k"), fwd.Key)
+			assert.Equal(t, []byte("v"), fwd.Value)
+
+			got := fromKafkaHeaders(fwd.Headers)
+			assert.Equal(t, "00-01-02-01", got["traceparent"])
+			assert.Equal(t, "orders", got[HeaderOriginalTopic])
+			assert.Equal(t, tt.wantAttempt, got[HeaderRetryAttempt])
+			assert.Equal(t, tt.err.Error(), got[HeaderError])
+			if tt.headers == nil {
+				assert.Equal(t, "3", got[HeaderOriginalPartition])
+				assert.Equal(t, "42", got[HeaderOriginalOffset])
+			}
+			_, hasDelay := got[HeaderRetryNotBefore]
+			assert.Equal(t, tt.wantDelay, hasDelay)
+		})
+	}
+}
+
+func TestRetryHandlerSucceedsInProcess(t *testing.T) {
+	producer := &fakeProducer{}
+	attempts := 0
+	handler := testRetryPolicy.retryHandler(func(ctx context.Context, msg Message) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	}, producer)
+
+	require.NoError(t, handler(context.Background(), Message{Topic: "orders"}))
+	assert.Equal(t, 2, attempts)
+	assert.Empty(t, producer.produced())
+}
+
+func TestRetryHandlerFailsWhenForwardingFails(t *testing.T) {
+	producer := &fakeProducer{err: kafka.NewError(kafka.ErrQueueFull, "queue full", false)}
+	handler := testRetryPolicy.retryHandler(func(ctx context.Context, msg Message) error {
+		return errors.New("failure")
+	}, producer)
+
+	err := handler(context.Background(), Message{Topic: "orders"})
+	require.Error(t, err)
+	assert.True(t, errorx.IsRetryable(err))
+}
+
+func TestConsumeWithRetryPolicy(t *testing.T) {
+	c := newFakeConsumer()
+	producer := &fakeProducer{}
+	handled := make(chan int64, 2)
+	stop := runConsume(t, c, func(ctx context.Context, msg Message) error {
+		handled <- msg.Offset
+		return nil
+	}, WithRetryPolicy(testRetryPolicy, producer))
+
+	delayed := testMessage(0, 0)
+	retryTopic := "orders.retry.1m"
+	delayed.TopicPartition.Topic = &retryTopic
+	notBefore := time.Now().Add(100 * time.Millisecond)
+	delayed.Headers = []kafka.Header{{Key: HeaderRetryNotBefore, Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))}}
+	c.events <- delayed
+
+	select {
+	case <-handled:
+		assert.False(t, time.Now().Before(notBefore.Truncate(time.Millisecond)))
+	case <-time.After(time.Second):
+		t.Fatal("delayed message not handled")
+	}
+	assert.Equal(t, []string{"orders", "orders.retry.1m", "orders.retry.10m"}, c.topics)
+	require.NoError(t, stop())
+}
+
+// fakeReader serves queued messages from ReadMessage and times out once
+// they are exhausted.
+type fakeReader struct {
+	messages  []*kafka.Message
+	committed []kafka.Offset
+}
+
+func (r *fakeReader) SubscribeTopics([]string, kafka.RebalanceCb) error { return nil }
+
+func (r *fakeReader) ReadMessage(time.Duration) (*kafka.Message, error) {
+	if len(r.messages) == 0 {
+		return nil, kafka.NewError(kafka.ErrTimedOut, "timed out", false)
+	}
+	msg := r.messages[0]
+	r.messages = r.messages[1:]
+	return msg, nil
+}
+
+func (r *fakeReader) CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+	r.committed = append(r.committed, msg.TopicPartition.Offset)
+	return nil, nil
+}
+
+func TestDLQReplayer(t *testing.T) {
+	dlq := "orders.dlq"
+	dead := func(offset int64, key string) *kafka.Message {
+		return &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &dlq, Offset: kafka.Offset(offset)},
+			Key:            []byte(key),
+			Value:          []byte("v"),
+			Headers: toKafkaHeaders(map[string]string{
+				HeaderOriginalTopic: "orders",
+				HeaderRetryAttempt:  "3",
+				HeaderError:         "failure",
+				HeaderRequestID:     "req-1",
+			}),
+		}
+	}
+	orphan := dead(3, "orphan")
+	orphan.Headers = toKafkaHeaders(map[string]string{HeaderError: "failure"})
+	reader := &fakeReader{messages: []*kafka.Message{dead(0, "a"), dead(1, "skip"), dead(2, "b"), orphan}}
+	producer := &fakeProducer{}
+	replayer := newDLQReplayer(reader, producer)
+
+	result, err := replayer.Replay(context.Background(), dlq, func(msg Message) bool {
+		return string(msg.Key) != "skip"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ReplayResult{Replayed: 2, Skipped: 1, Failed: 1}, result)
+	assert.Equal(t, []kafka.Offset{0, 1, 2, 3}, reader.committed)
+
+	produced := producer.produced()
+	require.Len(t, produced, 2)
+	assert.Equal(t, "orders", *produced[0].TopicPartition.Topic)
+	assert.Equal(t, []byte("b"), produced[1].Key)
+	assert.Equal(t, map[string]string{HeaderRequestID: "req-1"}, fromKafkaHeaders(produced[0].Headers))
+}
@@ -0,0 +1,196 @@
+package event
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+Topics carrying several event types: each type has its own schema, registered
+under the subject "<topic>-<record name>" (TopicRecordNameStrategy), and the
+router picks the handler, and so the Go type, from the schema ID of each
+message.
+
+USAGE EXAMPLE:
+
+	router := event.NewRouter(sr)
+	event.Route(router, "com.example.OrderCreated", func(ctx context.Context, msg event.Message, evt *OrderCreated) error {
+		return handleCreated(ctx, evt)
+	})
+	event.Route(router, "com.example.OrderCancelled", handleCancelled)
+	router.Fallback(func(ctx context.Context, msg event.Message) error {
+		log.Printf("ignoring unknown event %q", router.EventName(msg))
+		return nil
+	})
+
+	err := subscriber.Consume(ctx, router.Dispatch)
+*/
+
+// HeaderEventName is the header carrying the event name of a message. It is
+// set by the publishers for values implementing ConsumerMessage and takes
+// precedence over the schema ID.
+const HeaderEventName = "x-event-name"
+
+// Router is a Handler dispatching messages to the handler registered for
+// their event name.
+type Router struct {
+	sr *SchemaRegistry
+
+	mu       sync.RWMutex
+	routes   map[string]Handler
+	fallback Handler
+	subjects map[int][]string // Subjects of the schema IDs already seen
+}
+
+// NewRouter returns a router resolving schema IDs with sr. sr may be nil if
+// the messages carry the HeaderEventName header.
+func NewRouter(sr *SchemaRegistry) *Router {
+	return &Router{
+		sr:       sr,
+		routes:   make(map[string]Handler),
+		subjects: make(map[int][]string),
+	}
+}
+
+// Handle registers handler for the messages whose event name is name, the
+// record full name of their schema. It replaces any previous handler of
+// name.
+func (r *Router) Handle(name string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[name] = handler
+}
+
+// Route registers handler for the messages of event name, decoded into a
+// new T.
+func Route[T any](r *Router, name string, handler func(ctx context.Context, msg Message, value *T) error) {
+	r.Handle(name, func(ctx context.Context, msg Message) error {
+		value := new(T)
+		if err := msg.Decode(value); err != nil {
+			return err
+		}
+		return handler(ctx, msg, value)
+	})
+}
+
+// Fallback sets the handler of the messages of unknown event names. Without
+// one, they fail with a validation error.
+func (r *Router) Fallback(handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = handler
+}
+
+// Dispatch calls the handler registered for the event name of msg, or the
+// fallback handler if the name has no route. A failed schema lookup is
+// returned as a retryable error instead of reaching the fallback. It is a
+// Handler.
+func (r *Router) Dispatch(ctx context.Context, msg Message) error {
+	name, err := r.eventName(msg)
+	if err != nil {
+		return errorx.Tag(fmt.Errorf("failed to resolve event of %s[%d]@%d: %w", msg.Topic, msg.Partition, msg.Offset, err), errorx.ClassRetryable)
+	}
+	r.mu.RLock()
+	handler, ok := r.routes[name]
+	if !ok {
+		handler = r.fallback
+	}
+	r.mu.RUnlock()
+	if handler == nil {
+		return errorx.Tag(fmt.Errorf("no handler for event %q of %s[%d]@%d", name, msg.Topic, msg.Partition, msg.Offset), errorx.ClassValidation)
+	}
+	return handler(ctx, msg)
+}
+
+// EventName returns the event name of msg: its HeaderEventName header, or
+// else the record name of the subject its schema ID is registered under
+// with the topic, or "" if unknown or the schema lookup failed.
+func (r *Router) EventName(msg Message) string {
+	name, _ := r.eventName(msg)
+	return name
+}
+
+func (r *Router) eventName(msg Message) (string, error) {
+	if name := msg.Headers[HeaderEventName]; name != "" {
+		return name, nil
+	}
+	id, ok := schemaID(msg.Value)
+	if !ok || r.sr == nil {
+		return "", nil
+	}
+	subjects, err := r.schemaSubjects(id)
+	if err != nil {
+		return "", err
+	}
+
+	topic := msg.Topic
+	if original := msg.Headers[HeaderOriginalTopic]; original != "" {
+		topic = original
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, subject := range subjects {
+		// TopicRecordNameStrategy, then RecordNameStrategy
+		if name, ok := strings.CutPrefix(subject, topic+"-"); ok {
+			if _, registered := r.routes[name]; registered {
+				return name, nil
+			}
+		}
+		if _, registered := r.routes[subject]; registered {
+			return subject, nil
+		}
+	}
+	return "", nil
+}
+
+// schemaSubjects returns the subjects schema id is registered under.
+func (r *Router) schemaSubjects(id int) ([]string, error) {
+	r.mu.RLock()
+	subjects, ok := r.subjects[id]
+	r.mu.RUnlock()
+	if ok {
+		return subjects, nil
+	}
+
+	versions, err := r.sr.client.GetSubjectsAndVersionsByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subjects of schema %d: %w", id, err)
+	}
+	subjects = make([]string, len(versions))
+	for i, v := range versions {
+		subjects[i] = v.Subject
+	}
+	r.mu.Lock()
+	r.subjects[id] = subjects
+	r.mu.Unlock()
+	return subjects, nil
+}
+
+// schemaID returns the schema ID of a payload in the schema registry wire
+// format: a zero magic byte followed by the big-endian ID.
+func schemaID(payload []byte) (int, bool) {
+	if len(payload) < 5 || payload[0] != 0 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint32(payload[1:5])), true
+}
+
+// eventHeaders returns headers with HeaderEventName set to the event name of
+// value if it implements ConsumerMessage.
+func eventHeaders(value interface{}, headers map[string]string) map[string]string {
+	m, ok := value.(ConsumerMessage)
+	if !ok || headers[HeaderEventName] != "" {
+		return headers
+	}
+	out := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+	out[HeaderEventName] = m.EventName()
+	return out
+}
@@ -0,0 +1,115 @@
+package event
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type routerCreated struct {
+	ID string `json:"id"`
+}
+
+type routerCancelled struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// wireMessage returns a message of topic whose value is v in JSON behind
+// the schema registry wire header of schema id.
+func wireMessage(t *testing.T, topic string, id int, v interface{}) Message {
+	t.Helper()
+	body, err := json.Marshal(v)
+	require.NoError(t, err)
+	value := binary.BigEndian.AppendUint32([]byte{0}, uint32(id))
+	return Message{
+		Topic: topic,
+		Value: append(value, body...),
+		decode: func(value []byte, v interface{}) error {
+			return json.Unmarshal(value[5:], v)
+		},
+	}
+}
+
+func TestRouterDispatchesBySchemaSubject(t *testing.T) {
+	sr := NewInMemorySchemaRegistry()
+	createdID, err := sr.RegisterSchema("orders-com.example.OrderCreated", FormatAvro,
+		`{"type":"record","name":"OrderCreated","namespace":"com.example","fields":[{"name":"id","type":"string"}]}`)
+	require.NoError(t, err)
+	cancelledID, err := sr.RegisterSchema("orders-com.example.OrderCancelled", FormatAvro,
+		`{"type":"record","name":"OrderCancelled","namespace":"com.example","fields":[{"name":"id","type":"string"},{"name":"reason","type":"string"}]}`)
+	require.NoError(t, err)
+
+	router := NewRouter(sr)
+	var got []interface{}
+	Route(router, "com.example.OrderCreated", func(ctx context.Context, msg Message, evt *routerCreated) error {
+		got = append(got, *evt)
+		return nil
+	})
+	Route(router, "com.example.OrderCancelled", func(ctx context.Context, msg Message, evt *routerCancelled) error {
+		got = append(got, *evt)
+		return nil
+	})
+
+	ctx := context.Background()
+	require.NoError(t, router.Dispatch(ctx, wireMessage(t, "orders", createdID, routerCreated{ID: "1"})))
+	require.NoError(t, router.Dispatch(ctx, wireMessage(t, "orders", cancelledID, routerCancelled{ID: "1", Reason: "late"})))
+
+	// A retried message keeps the subject of its original topic
+	retried := wireMessage(t, "orders.retry.1m", createdID, routerCreated{ID: "2"})
+	retried.Headers = map[string]string{HeaderOriginalTopic: "orders"}
+	require.NoError(t, router.Dispatch(ctx, retried))
+
+	assert.Equal(t, []interface{}{
+		routerCreated{ID: "1"},
+		routerCancelled{ID: "1", Reason: "late"},
+		routerCreated{ID: "2"},
+	}, got)
+}
+
+func TestRouterFallback(t *testing.T) {
+	router := NewRouter(nil)
+	var handled []string
+	router.Handle("OrderCreated", func(ctx context.Context, msg Message) error {
+		handled = append(handled, "created")
+		return nil
+	})
+
+	ctx := context.Background()
+	unknown := Message{Topic: "orders", Headers: map[string]string{HeaderEventName: "OrderShipped"}}
+	err := router.Dispatch(ctx, unknown)
+	assert.True(t, errorx.IsValidation(err))
+
+	router.Fallback(func(ctx context.Context, msg Message) error {
+		handled = append(handled, "fallback:"+router.EventName(msg))
+		return nil
+	})
+	require.NoError(t, router.Dispatch(ctx, unknown))
+	require.NoError(t, router.Dispatch(ctx, Message{Topic: "orders", Headers: map[string]string{HeaderEventName: "OrderCreated"}}))
+	assert.Equal(t, []string{"fallback:OrderShipped", "created"}, handled)
+}
+
+func TestRouterSchemaLookupFailure(t *testing.T) {
+	router := NewRouter(NewInMemorySchemaRegistry())
+	var fallback bool
+	router.Fallback(func(ctx context.Context, msg Message) error {
+		fallback = true
+		return nil
+	})
+
+	err := router.Dispatch(context.Background(), wireMessage(t, "orders", 42, routerCreated{ID: "1"}))
+	require.Error(t, err)
+	assert.True(t, errorx.IsRetryable(err))
+	assert.False(t, fallback, "the fallback only gets events without a route")
+}
+
+func TestEventHeaders(t *testing.T) {
+	assert.Equal(t, map[string]string{HeaderEventName: "OrderCreated"}, eventHeaders(orderCreated{}, nil))
+	assert.Equal(t, map[string]string{HeaderEventName: "Custom"}, eventHeaders(orderCreated{}, map[string]string{HeaderEventName: "Custom"}))
+	assert.Nil(t, eventHeaders("plain", nil))
+}
@@ -0,0 +1,230 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/avrov2"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/jsonschema"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde/protobuf"
+)
+
+/*
+USAGE EXAMPLE:
+
+	// Protobuf values, e.g. the messages generated for gRPC
+	publisher, err := event.NewKafkaPublisher(producer, sr, schemaID, "orders",
+		event.WithPublisherFormat(event.FormatProtobuf))
+	publisher.SendMessage(ctx, &orderpb.OrderCreated{Id: "order-1"})
+
+	subscriber, err := event.NewKafkaSubscriber(consumer, sr, "orders",
+		event.WithSubscriberFormat(event.FormatProtobuf))
+	var evt orderpb.OrderCreated
+	err = msg.Decode(&evt)
+*/
+
+// Format is the encoding of message values.
+type Format string
+
+const (
+	FormatAvro       Format = "avro"       // Avro with the schema registry, the default
+	FormatProtobuf   Format = "protobuf"   // Protobuf with the schema registry; values are proto.Message
+	FormatJSONSchema Format = "jsonschema" // JSON validated by a JSON Schema of the registry
+	FormatJSON       Format = "json"       // Plain JSON, without schema registry
+)
+
+// schemaType returns the schema registry type of f.
+func (f Format) schemaType() string {
+	switch f {
+	case FormatProtobuf:
+		return "PROTOBUF"
+	case FormatJSONSchema:
+		return "JSON"
+	}
+	return "AVRO"
+}
+
+// Serializer encodes message values for a topic.
+type Serializer interface {
+	Serialize(topic string, value interface{}) ([]byte, error)
+}
+
+// Deserializer decodes message values of a topic into v, a pointer.
+type Deserializer interface {
+	DeserializeInto(topic string, payload []byte, v interface{}) error
+}
+
+type serdeConfig struct {
+	schemaID     int
+	autoRegister bool
+	validate     bool
+}
+
+// SerdeOption is a functional option for configuring serializers and
+// deserializers
+type SerdeOption func(*serdeConfig)
+
+// WithSchemaID makes the serializer use the schema with this ID instead of
+// the latest version of the subject
+func WithSchemaID(id int) SerdeOption {
+	return func(c *serdeConfig) {
+		c.schemaID = id
+	}
+}
+
+// WithAutoRegisterSchemas makes the serializer register the schema derived
+// from the values, instead of using a registered one
+func WithAutoRegisterSchemas() SerdeOption {
+	return func(c *serdeConfig) {
+		c.autoRegister = true
+	}
+}
+
+// WithSchemaValidation validates JSON Schema values against their schema
+func WithSchemaValidation() SerdeOption {
+	return func(c *serdeConfig) {
+		c.validate = true
+	}
+}
+
+// NewSerializer returns a serializer of format. sr may be nil for
+// FormatJSON.
+func NewSerializer(sr *SchemaRegistry, format Format, opts ...SerdeOption) (Serializer, error) {
+	config := serdeConfig{schemaID: -1}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if format == FormatJSON {
+		return jsonSerde{}, nil
+	}
+	if sr == nil {
+		return nil, fmt.Errorf("format %s requires a schema registry", format)
+	}
+
+	base := serde.SerializerConfig{
+		AutoRegisterSchemas: config.autoRegister,
+		UseSchemaID:         config.schemaID,
+		UseLatestVersion:    !config.autoRegister,
+		NormalizeSchemas:    true,
+	}
+	if base.UseSchemaID <= 0 {
+		base.UseSchemaID = -1
+	}
+	var known string
+	if sr.strategy != nil && base.UseSchemaID > 0 {
+		var err error
+		if known, err = sr.schemaByID(base.UseSchemaID); err != nil {
+			return nil, err
+		}
+	}
+	strategy := serdeStrategy(sr.strategy, format, known)
+	switch format {
+	case FormatAvro, "":
+		s, err := avrov2.NewSerializer(sr.client, serde.ValueSerde, &avrov2.SerializerConfig{SerializerConfig: base})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create avro serializer: %w", err)
+		}
+		s.SubjectNameStrategy = strategy
+		return avroSerializer{s}, nil
+	case FormatProtobuf:
+		s, err := protobuf.NewSerializer(sr.client, serde.ValueSerde, &protobuf.SerializerConfig{SerializerConfig: base})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create protobuf serializer: %w", err)
+		}
+		s.SubjectNameStrategy = strategy
+		return s, nil
+	case FormatJSONSchema:
+		s, err := jsonschema.NewSerializer(sr.client, serde.ValueSerde, &jsonschema.SerializerConfig{SerializerConfig: base, EnableValidation: config.validate})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create json schema serializer: %w", err)
+		}
+		s.SubjectNameStrategy = strategy
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// NewDeserializer returns a deserializer of format. sr may be nil for
+// FormatJSON.
+func NewDeserializer(sr *SchemaRegistry, format Format, opts ...SerdeOption) (Deserializer, error) {
+	var config serdeConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if format == FormatJSON {
+		return jsonSerde{}, nil
+	}
+	if sr == nil {
+		return nil, fmt.Errorf("format %s requires a schema registry", format)
+	}
+
+	switch format {
+	case FormatAvro, "":
+		d, err := avrov2.NewDeserializer(sr.client, serde.ValueSerde, &avrov2.DeserializerConfig{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create avro deserializer: %w", err)
+		}
+		return d, nil
+	case FormatProtobuf:
+		d, err := protobuf.NewDeserializer(sr.client, serde.ValueSerde, &protobuf.DeserializerConfig{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create protobuf deserializer: %w", err)
+		}
+		return unwrapDeserializer{d}, nil
+	case FormatJSONSchema:
+		d, err := jsonschema.NewDeserializer(sr.client, serde.ValueSerde, &jsonschema.DeserializerConfig{EnableValidation: config.validate})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create json schema deserializer: %w", err)
+		}
+		return unwrapDeserializer{d}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// avroSerializer passes values by pointer, as the Avro serializer requires.
+type avroSerializer struct {
+	s *avrov2.Serializer
+}
+
+func (a avroSerializer) Serialize(topic string, value interface{}) ([]byte, error) {
+	return a.s.Serialize(topic, &value)
+}
+
+// unwrapDeserializer decodes into the value held by a pointer to an
+// interface, as ConsumeMessages passes, since the Protobuf and JSON Schema
+// deserializers need the concrete pointer.
+type unwrapDeserializer struct {
+	d Deserializer
+}
+
+func (u unwrapDeserializer) DeserializeInto(topic string, payload []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Interface && !rv.Elem().IsNil() {
+		v = rv.Elem().Interface()
+	}
+	return u.d.DeserializeInto(topic, payload, v)
+}
+
+// jsonSerde encodes values as plain JSON.
+type jsonSerde struct{}
+
+func (jsonSerde) Serialize(_ string, value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonSerde) DeserializeInto(_ string, payload []byte, v interface{}) error {
+	return json.Unmarshal(payload, v)
+}
+
+// registrySchema returns the schema registry description of a schema of
+// format.
+func registrySchema(format Format, schema string) schemaregistry.SchemaInfo {
+	info := schemaregistry.SchemaInfo{Schema: schema}
+	if t := format.schemaType(); t != "AVRO" {
+		info.SchemaType = t
+	}
+	return info
+}
@@ -0,0 +1,71 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type serdeOrder struct {
+	ID     string `json:"id" avro:"id"`
+	Amount int    `json:"amount" avro:"amount"`
+}
+
+func TestSerdeRoundTrip(t *testing.T) {
+	const avroSchema = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"amount","type":"int"}]}`
+	const jsonSchema = `{"type":"object","properties":{"id":{"type":"string"},"amount":{"type":"integer"}},"required":["id"]}`
+
+	for _, tc := range []struct {
+		format Format
+		schema string
+	}{
+		{FormatAvro, avroSchema},
+		{FormatJSONSchema, jsonSchema},
+		{FormatJSON, ""},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			sr := NewInMemorySchemaRegistry()
+			if tc.schema != "" {
+				_, err := sr.RegisterSchema("orders-value", tc.format, tc.schema)
+				require.NoError(t, err)
+			}
+			ser, err := NewSerializer(sr, tc.format, WithSchemaValidation())
+			require.NoError(t, err)
+			des, err := NewDeserializer(sr, tc.format, WithSchemaValidation())
+			require.NoError(t, err)
+
+			payload, err := ser.Serialize("orders", serdeOrder{ID: "order-1", Amount: 42})
+			require.NoError(t, err)
+			var got serdeOrder
+			require.NoError(t, des.DeserializeInto("orders", payload, &got))
+			assert.Equal(t, serdeOrder{ID: "order-1", Amount: 42}, got)
+		})
+	}
+}
+
+func TestSerdeProtobuf(t *testing.T) {
+	sr := NewInMemorySchemaRegistry()
+	ser, err := NewSerializer(sr, FormatProtobuf, WithAutoRegisterSchemas())
+	require.NoError(t, err)
+	des, err := NewDeserializer(sr, FormatProtobuf)
+	require.NoError(t, err)
+
+	payload, err := ser.Serialize("orders", wrapperspb.String("order-1"))
+	require.NoError(t, err)
+
+	// ConsumeMessages decodes into a pointer to an interface
+	var v interface{} = &wrapperspb.StringValue{}
+	require.NoError(t, des.DeserializeInto("orders", payload, &v))
+	assert.Equal(t, "order-1", v.(*wrapperspb.StringValue).GetValue())
+}
+
+func TestSerdeRequiresRegistry(t *testing.T) {
+	_, err := NewSerializer(nil, FormatAvro)
+	assert.Error(t, err)
+	_, err = NewDeserializer(nil, FormatJSON)
+	assert.NoError(t, err)
+	_, err = NewSerializer(nil, Format("xml"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,125 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry/serde"
+)
+
+/*
+USAGE EXAMPLE:
+
+	// Several event types on one topic, each under "<topic>-<record name>"
+	sr, err := event.NewSchemaRegistry(
+		event.WithKafkaSchemaRegistryURL("http://localhost:8081"),
+		event.WithSubjectNameStrategy(event.TopicRecordNameStrategy),
+	)
+	subject, err := sr.Subject("orders", false, event.FormatAvro, schema) // "orders-com.example.OrderCreated"
+*/
+
+// SubjectNameStrategy returns the subject of the key or value schema of a
+// topic. recordName is the full name of the schema's record, see RecordName.
+type SubjectNameStrategy func(topic string, isKey bool, recordName string) string
+
+// TopicNameStrategy uses "<topic>-key" and "<topic>-value", the default: a
+// topic carries a single key and value type.
+func TopicNameStrategy(topic string, isKey bool, _ string) string {
+	if isKey {
+		return topic + "-key"
+	}
+	return topic + "-value"
+}
+
+// RecordNameStrategy uses the record name, so a type has one schema across
+// all the topics carrying it.
+func RecordNameStrategy(_ string, _ bool, recordName string) string {
+	return recordName
+}
+
+// TopicRecordNameStrategy uses "<topic>-<record name>", so a topic carries
+// several types, each evolving on its own.
+func TopicRecordNameStrategy(topic string, _ bool, recordName string) string {
+	return topic + "-" + recordName
+}
+
+var (
+	protoPackage = regexp.MustCompile(`(?m)^\s*package\s+([\w.]+)\s*;`)
+	protoMessage = regexp.MustCompile(`(?m)^\s*message\s+(\w+)`)
+)
+
+// RecordName returns the full name of the record defined by schema: the
+// namespace and name of an Avro record, the package and first message of a
+// Protobuf file, or the title of a JSON Schema.
+func RecordName(format Format, schema string) (string, error) {
+	switch format {
+	case FormatProtobuf:
+		m := protoMessage.FindStringSubmatch(schema)
+		if m == nil {
+			return "", fmt.Errorf("protobuf schema defines no message")
+		}
+		if p := protoPackage.FindStringSubmatch(schema); p != nil {
+			return p[1] + "." + m[1], nil
+		}
+		return m[1], nil
+	case FormatJSONSchema:
+		var s struct {
+			Title string `json:"title"`
+		}
+		if err := json.Unmarshal([]byte(schema), &s); err != nil {
+			return "", fmt.Errorf("invalid json schema: %w", err)
+		}
+		if s.Title == "" {
+			return "", fmt.Errorf("json schema has no title")
+		}
+		return s.Title, nil
+	case FormatAvro, "":
+		var s struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		}
+		if err := json.Unmarshal([]byte(schema), &s); err != nil {
+			return "", fmt.Errorf("avro schema is not a named type: %w", err)
+		}
+		if s.Name == "" {
+			return "", fmt.Errorf("avro schema has no name")
+		}
+		if s.Namespace == "" || strings.Contains(s.Name, ".") {
+			return s.Name, nil
+		}
+		return s.Namespace + "." + s.Name, nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+// subjectName returns the subject of a schema of format with strategy. A
+// schema without record name, e.g. a primitive Avro key, is only an error
+// for strategies that depend on it.
+func subjectName(strategy SubjectNameStrategy, topic string, isKey bool, format Format, schema string) (string, error) {
+	if strategy == nil {
+		strategy = TopicNameStrategy
+	}
+	recordName, err := RecordName(format, schema)
+	if err != nil {
+		subject := strategy(topic, isKey, "")
+		if subject != strategy(topic, isKey, "_") {
+			return "", fmt.Errorf("failed to get the record name: %w", err)
+		}
+		return subject, nil
+	}
+	return strategy(topic, isKey, recordName), nil
+}
+
+// serdeStrategy adapts strategy to the serializers of format. Serializers
+// using a fixed schema ID do not pass the schema; known is used instead.
+func serdeStrategy(strategy SubjectNameStrategy, format Format, known string) serde.SubjectNameStrategyFunc {
+	return func(topic string, serdeType serde.Type, schema schemaregistry.SchemaInfo) (string, error) {
+		if schema.Schema == "" {
+			schema.Schema = known
+		}
+		return subjectName(strategy, topic, serdeType == serde.KeySerde, format, schema.Schema)
+	}
+}
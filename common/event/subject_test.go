@@ -0,0 +1,106 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/schemaregistry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const subjectOrderSchema = `{"type":"record","name":"Order","namespace":"com.example","fields":[{"name":"id","type":"string"}]}`
+
+func TestRecordName(t *testing.T) {
+	for _, tc := range []struct {
+		format Format
+		schema string
+		want   string
+	}{
+		{FormatAvro, subjectOrderSchema, "com.example.Order"},
+		{FormatAvro, `{"type":"record","name":"com.other.Order","namespace":"com.example","fields":[]}`, "com.other.Order"},
+		{FormatProtobuf, "syntax = \"proto3\";\npackage com.example;\n\nmessage Order {\n  string id = 1;\n}\n", "com.example.Order"},
+		{FormatJSONSchema, `{"title":"Order","type":"object"}`, "Order"},
+	} {
+		got, err := RecordName(tc.format, tc.schema)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+	_, err := RecordName(FormatAvro, `"string"`)
+	assert.Error(t, err)
+}
+
+func TestSubjectName(t *testing.T) {
+	subject, err := subjectName(nil, "orders", false, FormatAvro, subjectOrderSchema)
+	require.NoError(t, err)
+	assert.Equal(t, "orders-value", subject)
+
+	subject, err = subjectName(TopicRecordNameStrategy, "orders", false, FormatAvro, subjectOrderSchema)
+	require.NoError(t, err)
+	assert.Equal(t, "orders-com.example.Order", subject)
+
+	subject, err = subjectName(RecordNameStrategy, "orders", true, FormatAvro, subjectOrderSchema)
+	require.NoError(t, err)
+	assert.Equal(t, "com.example.Order", subject)
+
+	// A primitive key only needs a record name with record strategies
+	subject, err = subjectName(TopicNameStrategy, "orders", true, FormatAvro, `"string"`)
+	require.NoError(t, err)
+	assert.Equal(t, "orders-key", subject)
+	_, err = subjectName(RecordNameStrategy, "orders", true, FormatAvro, `"string"`)
+	assert.Error(t, err)
+}
+
+func TestSchemaRegistryManagement(t *testing.T) {
+	sr := NewInMemorySchemaRegistry()
+	sr.strategy = TopicRecordNameStrategy
+
+	subject, err := sr.Subject("orders", false, FormatAvro, subjectOrderSchema)
+	require.NoError(t, err)
+	orderID, err := sr.RegisterSchema(subject, FormatAvro, subjectOrderSchema)
+	require.NoError(t, err)
+
+	keyID, err := sr.RegisterSchema("orders-key", FormatAvro, `"string"`)
+	require.NoError(t, err)
+	assert.NotEqual(t, orderID, keyID)
+
+	// A schema referring to the Order record
+	const envelope = `{"type":"record","name":"Envelope","namespace":"com.example","fields":[{"name":"order","type":"com.example.Order"}]}`
+	envelopeID, err := sr.RegisterSchema("orders-com.example.Envelope", FormatAvro, envelope,
+		schemaregistry.Reference{Name: "com.example.Order", Subject: subject, Version: 1})
+	require.NoError(t, err)
+
+	subjects, err := sr.Subjects()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"orders-com.example.Order", "orders-key", "orders-com.example.Envelope"}, subjects)
+
+	versions, err := sr.Versions(subject)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, versions)
+
+	meta, err := sr.GetSchema("orders-com.example.Envelope", -1)
+	require.NoError(t, err)
+	assert.Equal(t, envelopeID, meta.ID)
+	assert.Len(t, meta.References, 1)
+
+	require.NoError(t, sr.SetCompatibility(subject, schemaregistry.Compatibility(schemaregistry.FullTransitive)))
+	level, err := sr.Compatibility(subject)
+	require.NoError(t, err)
+	assert.Equal(t, schemaregistry.Compatibility(schemaregistry.FullTransitive), level)
+}
+
+func TestSerializerUsesSubjectNameStrategy(t *testing.T) {
+	sr := NewInMemorySchemaRegistry()
+	sr.strategy = TopicRecordNameStrategy
+	id, err := sr.RegisterSchema("orders-com.example.Order", FormatAvro, subjectOrderSchema)
+	require.NoError(t, err)
+
+	ser, err := NewSerializer(sr, FormatAvro, WithSchemaID(id))
+	require.NoError(t, err)
+	payload, err := ser.Serialize("orders", struct {
+		ID string `avro:"id"`
+	}{ID: "order-1"})
+	require.NoError(t, err)
+	got, ok := schemaID(payload)
+	assert.True(t, ok)
+	assert.Equal(t, id, got)
+}
@@ -0,0 +1,278 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+/*
+Declarative topic provisioning: missing topics are created, existing ones are
+compared with their specification and the differences reported as drift.
+
+USAGE EXAMPLE:
+
+	admin, _ := event.NewAdminClientFromProducer(producer)
+	manager := event.NewTopicManager(admin, event.WithTopicIncreasePartitions())
+	report, err := manager.Ensure(ctx,
+		event.TopicSpec{Name: "orders", Partitions: 6, ReplicationFactor: 3, Retention: 7 * 24 * time.Hour},
+		event.TopicSpec{Name: "order-snapshots", Partitions: 6, ReplicationFactor: 3, CleanupPolicy: event.CleanupCompact},
+	)
+	if err != nil {
+		return err
+	}
+	for _, d := range report.Drift {
+		log.Printf("topic %s: %s is %s, want %s", d.Topic, d.Setting, d.Got, d.Want)
+	}
+*/
+
+// Cleanup policies of TopicSpec
+const (
+	CleanupDelete        = "delete"
+	CleanupCompact       = "compact"
+	CleanupCompactDelete = "compact,delete"
+)
+
+// TopicSpec is the desired state of a topic. Zero values keep the broker
+// defaults.
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration // retention.ms; negative retains messages forever
+	CleanupPolicy     string        // cleanup.policy, see CleanupDelete
+	MinCompactionLag  time.Duration // min.compaction.lag.ms of compacted topics
+	Config            map[string]string
+}
+
+// configs returns the topic configuration entries of the spec.
+func (s TopicSpec) configs() map[string]string {
+	configs := make(map[string]string, len(s.Config)+3)
+	for k, v := range s.Config {
+		configs[k] = v
+	}
+	switch {
+	case s.Retention < 0:
+		configs["retention.ms"] = "-1"
+	case s.Retention > 0:
+		configs["retention.ms"] = strconv.FormatInt(s.Retention.Milliseconds(), 10)
+	}
+	if s.CleanupPolicy != "" {
+		configs["cleanup.policy"] = s.CleanupPolicy
+	}
+	if s.MinCompactionLag > 0 {
+		configs["min.compaction.lag.ms"] = strconv.FormatInt(s.MinCompactionLag.Milliseconds(), 10)
+	}
+	return configs
+}
+
+// TopicDrift is a difference between an existing topic and its spec.
+type TopicDrift struct {
+	Topic   string
+	Setting string // "partitions", "replication.factor" or a config name
+	Want    string
+	Got     string
+}
+
+// TopicReport lists what Ensure did and found.
+type TopicReport struct {
+	Created   []string
+	Increased []string // Topics whose partitions were increased
+	Drift     []TopicDrift
+}
+
+// topicAdmin is the part of *kafka.AdminClient used by TopicManager.
+type topicAdmin interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+	CreateTopics(ctx context.Context, topics []kafka.TopicSpecification, options ...kafka.CreateTopicsAdminOption) ([]kafka.TopicResult, error)
+	CreatePartitions(ctx context.Context, partitions []kafka.PartitionsSpecification, options ...kafka.CreatePartitionsAdminOption) ([]kafka.TopicResult, error)
+	DescribeConfigs(ctx context.Context, resources []kafka.ConfigResource, options ...kafka.DescribeConfigsAdminOption) ([]kafka.ConfigResourceResult, error)
+}
+
+// TopicManager provisions topics from their specs.
+type TopicManager struct {
+	admin              topicAdmin
+	timeout            time.Duration
+	increasePartitions bool
+}
+
+// TopicManagerOption is a functional option for configuring a TopicManager
+type TopicManagerOption func(*TopicManager)
+
+// WithTopicIncreasePartitions makes Ensure add partitions to existing topics
+// that have fewer than their spec. This changes the partition of keyed
+// messages.
+func WithTopicIncreasePartitions() TopicManagerOption {
+	return func(m *TopicManager) {
+		m.increasePartitions = true
+	}
+}
+
+// WithTopicTimeout sets the timeout of the admin requests, 60s by default
+func WithTopicTimeout(d time.Duration) TopicManagerOption {
+	return func(m *TopicManager) {
+		m.timeout = d
+	}
+}
+
+// NewTopicManager returns a manager using admin
+func NewTopicManager(admin *kafka.AdminClient, opts ...TopicManagerOption) *TopicManager {
+	return newTopicManager(admin, opts...)
+}
+
+func newTopicManager(admin topicAdmin, opts ...TopicManagerOption) *TopicManager {
+	m := &TopicManager{admin: admin, timeout: 60 * time.Second}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Ensure creates the topics of specs that do not exist and compares the
+// others with their spec. Drift is reported, not fixed, except missing
+// partitions with WithTopicIncreasePartitions. The errors of all the topics
+// are returned joined; the report covers the topics that succeeded.
+func (m *TopicManager) Ensure(ctx context.Context, specs ...TopicSpec) (TopicReport, error) {
+	var report TopicReport
+	metadata, err := m.admin.GetMetadata(nil, true, int(m.timeout.Milliseconds()))
+	if err != nil {
+		return report, classify(fmt.Errorf("failed to get cluster metadata: %w", err))
+	}
+
+	var (
+		missing  []TopicSpec
+		existing []TopicSpec
+	)
+	for _, spec := range specs {
+		if _, ok := metadata.Topics[spec.Name]; ok {
+			existing = append(existing, spec)
+		} else {
+			missing = append(missing, spec)
+		}
+	}
+
+	var errs []error
+	created, err := m.create(ctx, missing)
+	report.Created = created
+	errs = append(errs, err)
+	if len(existing) > 0 {
+		errs = append(errs, m.compare(ctx, metadata, existing, &report))
+	}
+	return report, errors.Join(errs...)
+}
+
+// create creates specs and returns the names of the created topics. Topics
+// created concurrently by someone else are not errors.
+func (m *TopicManager) create(ctx context.Context, specs []TopicSpec) ([]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	topics := make([]kafka.TopicSpecification, len(specs))
+	for i, spec := range specs {
+		topics[i] = kafka.TopicSpecification{
+			Topic:             spec.Name,
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+			Config:            spec.configs(),
+		}
+		if topics[i].NumPartitions == 0 {
+			topics[i].NumPartitions = -1 // Broker default
+		}
+		if topics[i].ReplicationFactor == 0 {
+			topics[i].ReplicationFactor = -1
+		}
+	}
+	results, err := m.admin.CreateTopics(ctx, topics, kafka.SetAdminOperationTimeout(m.timeout))
+	if err != nil {
+		return nil, classify(fmt.Errorf("failed to create topics: %w", err))
+	}
+
+	var (
+		created []string
+		errs    []error
+	)
+	for _, r := range results {
+		switch r.Error.Code() {
+		case kafka.ErrNoError:
+			created = append(created, r.Topic)
+		case kafka.ErrTopicAlreadyExists:
+		default:
+			errs = append(errs, classify(fmt.Errorf("failed to create topic %s: %w", r.Topic, r.Error)))
+		}
+	}
+	return created, errors.Join(errs...)
+}
+
+// compare adds the drift of the existing topics of specs to report, adding
+// partitions if enabled.
+func (m *TopicManager) compare(ctx context.Context, metadata *kafka.Metadata, specs []TopicSpec, report *TopicReport) error {
+	var (
+		increase []kafka.PartitionsSpecification
+		errs     []error
+	)
+	for _, spec := range specs {
+		topic := metadata.Topics[spec.Name]
+		partitions := len(topic.Partitions)
+		switch {
+		case spec.Partitions > partitions && m.increasePartitions:
+			increase = append(increase, kafka.PartitionsSpecification{Topic: spec.Name, IncreaseTo: spec.Partitions})
+		case spec.Partitions != 0 && spec.Partitions != partitions:
+			report.Drift = append(report.Drift, TopicDrift{Topic: spec.Name, Setting: "partitions", Want: strconv.Itoa(spec.Partitions), Got: strconv.Itoa(partitions)})
+		}
+		if spec.ReplicationFactor != 0 && partitions > 0 && len(topic.Partitions[0].Replicas) != spec.ReplicationFactor {
+			report.Drift = append(report.Drift, TopicDrift{Topic: spec.Name, Setting: "replication.factor", Want: strconv.Itoa(spec.ReplicationFactor), Got: strconv.Itoa(len(topic.Partitions[0].Replicas))})
+		}
+	}
+
+	if len(increase) > 0 {
+		results, err := m.admin.CreatePartitions(ctx, increase, kafka.SetAdminOperationTimeout(m.timeout))
+		if err != nil {
+			errs = append(errs, classify(fmt.Errorf("failed to increase partitions: %w", err)))
+		}
+		for _, r := range results {
+			if r.Error.Code() != kafka.ErrNoError {
+				errs = append(errs, classify(fmt.Errorf("failed to increase the partitions of %s: %w", r.Topic, r.Error)))
+				continue
+			}
+			report.Increased = append(report.Increased, r.Topic)
+		}
+	}
+
+	resources := make([]kafka.ConfigResource, len(specs))
+	for i, spec := range specs {
+		resources[i] = kafka.ConfigResource{Type: kafka.ResourceTopic, Name: spec.Name}
+	}
+	results, err := m.admin.DescribeConfigs(ctx, resources, kafka.SetAdminRequestTimeout(m.timeout))
+	if err != nil {
+		errs = append(errs, classify(fmt.Errorf("failed to describe topic configs: %w", err)))
+		return errors.Join(errs...)
+	}
+	actual := make(map[string]kafka.ConfigResourceResult, len(results))
+	for _, r := range results {
+		actual[r.Name] = r
+	}
+	for _, spec := range specs {
+		r := actual[spec.Name]
+		if r.Error.Code() != kafka.ErrNoError {
+			errs = append(errs, classify(fmt.Errorf("failed to describe the configs of %s: %w", spec.Name, r.Error)))
+			continue
+		}
+		configs := spec.configs()
+		names := make([]string, 0, len(configs))
+		for name := range configs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if got := r.Config[name].Value; got != configs[name] {
+				report.Drift = append(report.Drift, TopicDrift{Topic: spec.Name, Setting: name, Want: configs[name], Got: got})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
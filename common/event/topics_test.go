@@ -0,0 +1,136 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTopicAdmin holds topics as partition counts, replication factors and
+// configs.
+type fakeTopicAdmin struct {
+	partitions  map[string]int
+	replication map[string]int
+	configs     map[string]map[string]string
+	createErr   map[string]kafka.ErrorCode
+	increased   []kafka.PartitionsSpecification
+}
+
+func newFakeTopicAdmin() *fakeTopicAdmin {
+	return &fakeTopicAdmin{
+		partitions:  make(map[string]int),
+		replication: make(map[string]int),
+		configs:     make(map[string]map[string]string),
+		createErr:   make(map[string]kafka.ErrorCode),
+	}
+}
+
+func (a *fakeTopicAdmin) GetMetadata(*string, bool, int) (*kafka.Metadata, error) {
+	md := &kafka.Metadata{Topics: make(map[string]kafka.TopicMetadata)}
+	for topic, n := range a.partitions {
+		tm := kafka.TopicMetadata{Topic: topic}
+		for i := 0; i < n; i++ {
+			tm.Partitions = append(tm.Partitions, kafka.PartitionMetadata{ID: int32(i), Replicas: make([]int32, a.replication[topic])})
+		}
+		md.Topics[topic] = tm
+	}
+	return md, nil
+}
+
+func (a *fakeTopicAdmin) CreateTopics(_ context.Context, topics []kafka.TopicSpecification, _ ...kafka.CreateTopicsAdminOption) ([]kafka.TopicResult, error) {
+	var results []kafka.TopicResult
+	for _, t := range topics {
+		if code, ok := a.createErr[t.Topic]; ok {
+			results = append(results, kafka.TopicResult{Topic: t.Topic, Error: kafka.NewError(code, code.String(), false)})
+			continue
+		}
+		a.partitions[t.Topic] = t.NumPartitions
+		a.replication[t.Topic] = t.ReplicationFactor
+		a.configs[t.Topic] = t.Config
+		results = append(results, kafka.TopicResult{Topic: t.Topic})
+	}
+	return results, nil
+}
+
+func (a *fakeTopicAdmin) CreatePartitions(_ context.Context, partitions []kafka.PartitionsSpecification, _ ...kafka.CreatePartitionsAdminOption) ([]kafka.TopicResult, error) {
+	var results []kafka.TopicResult
+	for _, p := range partitions {
+		a.increased = append(a.increased, p)
+		a.partitions[p.Topic] = p.IncreaseTo
+		results = append(results, kafka.TopicResult{Topic: p.Topic})
+	}
+	return results, nil
+}
+
+func (a *fakeTopicAdmin) DescribeConfigs(_ context.Context, resources []kafka.ConfigResource, _ ...kafka.DescribeConfigsAdminOption) ([]kafka.ConfigResourceResult, error) {
+	var results []kafka.ConfigResourceResult
+	for _, r := range resources {
+		result := kafka.ConfigResourceResult{Type: r.Type, Name: r.Name, Config: make(map[string]kafka.ConfigEntryResult)}
+		for name, value := range a.configs[r.Name] {
+			result.Config[name] = kafka.ConfigEntryResult{Name: name, Value: value}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func TestTopicManagerCreatesMissingTopics(t *testing.T) {
+	admin := newFakeTopicAdmin()
+	admin.partitions["orders"] = 3
+	admin.replication["orders"] = 3
+
+	report, err := newTopicManager(admin).Ensure(context.Background(),
+		TopicSpec{Name: "orders", Partitions: 3, ReplicationFactor: 3},
+		TopicSpec{Name: "snapshots", Partitions: 6, ReplicationFactor: 3, CleanupPolicy: CleanupCompact, MinCompactionLag: time.Hour},
+		TopicSpec{Name: "audit", Retention: -1},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"snapshots", "audit"}, report.Created)
+	assert.Empty(t, report.Drift)
+	assert.Equal(t, map[string]string{"cleanup.policy": "compact", "min.compaction.lag.ms": "3600000"}, admin.configs["snapshots"])
+	assert.Equal(t, map[string]string{"retention.ms": "-1"}, admin.configs["audit"])
+	assert.Equal(t, -1, admin.partitions["audit"], "broker default")
+}
+
+func TestTopicManagerReportsDrift(t *testing.T) {
+	admin := newFakeTopicAdmin()
+	admin.partitions["orders"] = 3
+	admin.replication["orders"] = 1
+	admin.configs["orders"] = map[string]string{"retention.ms": "86400000", "cleanup.policy": "delete"}
+
+	spec := TopicSpec{Name: "orders", Partitions: 6, ReplicationFactor: 3, Retention: 7 * 24 * time.Hour, CleanupPolicy: CleanupDelete}
+	report, err := newTopicManager(admin).Ensure(context.Background(), spec)
+	require.NoError(t, err)
+	assert.Empty(t, report.Created)
+	assert.Equal(t, []TopicDrift{
+		{Topic: "orders", Setting: "partitions", Want: "6", Got: "3"},
+		{Topic: "orders", Setting: "replication.factor", Want: "3", Got: "1"},
+		{Topic: "orders", Setting: "retention.ms", Want: "604800000", Got: "86400000"},
+	}, report.Drift)
+	assert.Empty(t, admin.increased)
+
+	report, err = newTopicManager(admin, WithTopicIncreasePartitions()).Ensure(context.Background(), spec)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders"}, report.Increased)
+	assert.Equal(t, 6, admin.partitions["orders"])
+	assert.Len(t, report.Drift, 2)
+}
+
+func TestTopicManagerReturnsTopicErrors(t *testing.T) {
+	admin := newFakeTopicAdmin()
+	admin.createErr["raced"] = kafka.ErrTopicAlreadyExists
+	admin.createErr["invalid"] = kafka.ErrInvalidReplicationFactor
+
+	report, err := newTopicManager(admin).Ensure(context.Background(),
+		TopicSpec{Name: "raced"}, TopicSpec{Name: "invalid", ReplicationFactor: 9}, TopicSpec{Name: "ok"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid")
+	assert.NotContains(t, err.Error(), "raced")
+	assert.False(t, errorx.IsRetryable(err))
+	assert.Equal(t, []string{"ok"}, report.Created)
+}
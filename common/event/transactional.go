@@ -0,0 +1,517 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+/*
+Exactly-once read-process-write: each batch of consumed messages is handled
+in a Kafka transaction carrying the produced messages and the offsets of the
+batch, so the outputs are visible and the inputs committed together, or not
+at all.
+
+USAGE EXAMPLE:
+
+	producer, _ := event.NewKafkaProducer(event.WithKafkaTransactionalID("billing-" + podName))
+	consumer, _ := event.NewKafkaConsumer(event.WithKafkaConsumerGroupID("billing"))
+	processor, _ := event.NewTransactionalProcessor(producer, consumer, sr, []string{"orders"},
+		event.WithTransactionalBatchSize(100))
+
+	err := processor.Run(ctx, func(ctx context.Context, tx *event.Transaction, msgs []event.Message) error {
+		for _, msg := range msgs {
+			var order OrderCreated
+			if err := msg.Decode(&order); err != nil {
+				return err
+			}
+			if err := tx.Publish(ctx, "invoices", event.ProducerMessage{Key: msg.Key, Value: NewInvoice(order)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+*/
+
+// TransactionalHandler processes a batch of consumed messages, publishing
+// its outputs through tx. Returning an error aborts the transaction; the
+// batch is skipped if the error is a validation error, and handled again
+// otherwise.
+type TransactionalHandler func(ctx context.Context, tx *Transaction, msgs []Message) error
+
+// txProducer is the part of *kafka.Producer used by TransactionalProcessor.
+type txProducer interface {
+	InitTransactions(ctx context.Context) error
+	BeginTransaction() error
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+	SendOffsetsToTransaction(ctx context.Context, offsets []kafka.TopicPartition, consumerMetadata *kafka.ConsumerGroupMetadata) error
+	CommitTransaction(ctx context.Context) error
+	AbortTransaction(ctx context.Context) error
+}
+
+// txConsumer is the part of *kafka.Consumer used by TransactionalProcessor.
+type txConsumer interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	Poll(timeoutMs int) kafka.Event
+	Seek(partition kafka.TopicPartition, ignoredTimeoutMs int) error
+	GetConsumerGroupMetadata() (*kafka.ConsumerGroupMetadata, error)
+}
+
+// TransactionalProcessor consumes topics and handles their messages in
+// batches, each in its own transaction.
+type TransactionalProcessor struct {
+	producer     txProducer
+	consumer     txConsumer
+	topics       []string
+	format       Format
+	serializer   Serializer
+	deserializer Deserializer
+	batchSize    int
+	batchTimeout time.Duration
+	retryBackoff time.Duration
+	timeout      time.Duration
+	onError      func(err error)
+
+	deliveries chan kafka.Event
+	batch      []*kafka.Message
+}
+
+// TransactionalOption is a functional option for configuring a
+// TransactionalProcessor
+type TransactionalOption func(*TransactionalProcessor)
+
+// WithTransactionalBatchSize sets the maximum number of messages of a
+// transaction, 100 by default
+func WithTransactionalBatchSize(n int) TransactionalOption {
+	return func(p *TransactionalProcessor) {
+		p.batchSize = n
+	}
+}
+
+// WithTransactionalBatchTimeout sets how long a batch is filled once its
+// first message arrived, 100ms by default
+func WithTransactionalBatchTimeout(d time.Duration) TransactionalOption {
+	return func(p *TransactionalProcessor) {
+		p.batchTimeout = d
+	}
+}
+
+// WithTransactionalRetryBackoff sets the delay before an aborted batch is
+// handled again, and between the attempts of a retriable transaction
+// request, 1s by default
+func WithTransactionalRetryBackoff(d time.Duration) TransactionalOption {
+	return func(p *TransactionalProcessor) {
+		p.retryBackoff = d
+	}
+}
+
+// WithTransactionalTimeout sets the timeout of the transaction requests to
+// the coordinator, 30s by default
+func WithTransactionalTimeout(d time.Duration) TransactionalOption {
+	return func(p *TransactionalProcessor) {
+		p.timeout = d
+	}
+}
+
+// WithTransactionalFormat sets the encoding of the consumed and produced
+// values, FormatAvro by default
+func WithTransactionalFormat(format Format) TransactionalOption {
+	return func(p *TransactionalProcessor) {
+		p.format = format
+	}
+}
+
+// WithTransactionalSerializer sets the serializer of the produced values,
+// overriding the format
+func WithTransactionalSerializer(serializer Serializer) TransactionalOption {
+	return func(p *TransactionalProcessor) {
+		p.serializer = serializer
+	}
+}
+
+// WithTransactionalDeserializer sets the deserializer of the consumed
+// values, overriding the format
+func WithTransactionalDeserializer(deserializer Deserializer) TransactionalOption {
+	return func(p *TransactionalProcessor) {
+		p.deserializer = deserializer
+	}
+}
+
+// WithTransactionalErrorHandler sets the function receiving the errors of
+// aborted transactions and failed deliveries. They are logged by default.
+// The function may be called concurrently.
+func WithTransactionalErrorHandler(f func(err error)) TransactionalOption {
+	return func(p *TransactionalProcessor) {
+		p.onError = f
+	}
+}
+
+// NewTransactionalProcessor returns a processor consuming topics with
+// consumer and producing with producer, which must be configured with
+// WithKafkaTransactionalID. Values are encoded with the latest schemas of
+// the registry, in Avro unless another format is set.
+func NewTransactionalProcessor(producer *kafka.Producer, consumer *kafka.Consumer, sr *SchemaRegistry, topics []string, opts ...TransactionalOption) (*TransactionalProcessor, error) {
+	p := newTransactionalProcessor(producer, consumer, topics, opts...)
+	if p.serializer == nil {
+		serializer, err := NewSerializer(sr, p.format)
+		if err != nil {
+			return nil, err
+		}
+		p.serializer = serializer
+	}
+	if p.deserializer == nil {
+		deserializer, err := NewDeserializer(sr, p.format)
+		if err != nil {
+			return nil, err
+		}
+		p.deserializer = deserializer
+	}
+	return p, nil
+}
+
+func newTransactionalProcessor(producer txProducer, consumer txConsumer, topics []string, opts ...TransactionalOption) *TransactionalProcessor {
+	p := &TransactionalProcessor{
+		producer:     producer,
+		consumer:     consumer,
+		topics:       topics,
+		format:       FormatAvro,
+		batchSize:    100,
+		batchTimeout: 100 * time.Millisecond,
+		retryBackoff: time.Second,
+		timeout:      30 * time.Second,
+		deliveries:   make(chan kafka.Event, 1024),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.batchSize < 1 {
+		p.batchSize = 1
+	}
+	if p.onError == nil {
+		p.onError = func(err error) {
+			log.Printf("Transactional processor error: %s", err)
+		}
+	}
+	return p
+}
+
+// newMessage wraps km, decoding its value with the deserializer.
+func (p *TransactionalProcessor) newMessage(km *kafka.Message) Message {
+	subscriber := &kafkaSubscriber{serde: p.deserializer}
+	return subscriber.newMessage(km)
+}
+
+// Run subscribes to the topics and handles their messages until ctx is
+// done. Each batch is handled in a transaction which also commits the
+// offsets of the batch. If the handler or the commit fails, the transaction
+// is aborted, the consumer rewound to the start of the batch and the batch
+// handled again after the retry backoff; consumers reading with the
+// read_committed isolation level, the default, never see the outputs of
+// aborted transactions. A batch failing with a validation error, e.g. an
+// undecodable message, is skipped instead: its offsets are committed in a
+// transaction without outputs. Any other error retries the batch until it
+// succeeds, so the handler must turn poison messages into validation errors
+// or route them elsewhere itself.
+//
+// Run returns nil once ctx is done, or the error that made the producer
+// unusable, e.g. when another instance with the same transactional ID
+// fenced it.
+func (p *TransactionalProcessor) Run(ctx context.Context, handler TransactionalHandler) error {
+	done := make(chan struct{})
+	defer close(done)
+	go p.drainDeliveries(done)
+
+	if err := p.retry(ctx, func(ctx context.Context) error { return p.producer.InitTransactions(ctx) }); err != nil {
+		return classify(fmt.Errorf("failed to init transactions: %w", err))
+	}
+	if err := p.consumer.SubscribeTopics(p.topics, p.rebalance); err != nil {
+		return classify(fmt.Errorf("failed to subscribe to topics: %w", err))
+	}
+
+	for {
+		p.batch = p.batch[:0]
+		if err := p.collect(ctx); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if len(p.batch) == 0 {
+			continue
+		}
+
+		// A collected batch is completed even if ctx is done meanwhile
+		txCtx := context.WithoutCancel(ctx)
+		err := p.process(txCtx, handler)
+		if err == nil {
+			continue
+		}
+		if isFatalTxnError(err) {
+			return err
+		}
+		p.onError(err)
+		if err := p.abort(txCtx); err != nil {
+			return err
+		}
+		if errorx.IsValidation(err) {
+			err := p.skip(txCtx)
+			if err == nil {
+				continue
+			}
+			if isFatalTxnError(err) {
+				return err
+			}
+			p.onError(err)
+			if err := p.abort(txCtx); err != nil {
+				return err
+			}
+		}
+		p.rewind()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(p.retryBackoff):
+		}
+	}
+}
+
+// collect polls messages into the batch until it is full or the batch
+// timeout elapsed since its first message.
+func (p *TransactionalProcessor) collect(ctx context.Context) error {
+	var deadline time.Time
+	for len(p.batch) < p.batchSize && ctx.Err() == nil {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil
+		}
+		switch e := p.consumer.Poll(100).(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				p.onError(classify(fmt.Errorf("consumer read error: %w", e.TopicPartition.Error)))
+				continue
+			}
+			if len(p.batch) == 0 {
+				deadline = time.Now().Add(p.batchTimeout)
+			}
+			p.batch = append(p.batch, e)
+		case kafka.Error:
+			err := classify(fmt.Errorf("consumer error: %w", e))
+			if e.IsFatal() {
+				return err
+			}
+			p.onError(err)
+		}
+	}
+	return nil
+}
+
+// process handles the batch in a transaction and commits it.
+func (p *TransactionalProcessor) process(ctx context.Context, handler TransactionalHandler) error {
+	if err := p.producer.BeginTransaction(); err != nil {
+		return classify(fmt.Errorf("failed to begin transaction: %w", err))
+	}
+
+	msgs := make([]Message, len(p.batch))
+	for i, km := range p.batch {
+		msgs[i] = p.newMessage(km)
+	}
+	tx := &Transaction{processor: p}
+	if err := p.handle(ctx, handler, tx, msgs); err != nil {
+		return fmt.Errorf("failed to handle batch of %d messages: %w", len(msgs), err)
+	}
+	if tx.err != nil {
+		return tx.err
+	}
+	return p.commit(ctx)
+}
+
+// commit adds the offsets of the batch to the transaction and commits it.
+func (p *TransactionalProcessor) commit(ctx context.Context) error {
+	metadata, err := p.consumer.GetConsumerGroupMetadata()
+	if err != nil {
+		return classify(fmt.Errorf("failed to get consumer group metadata: %w", err))
+	}
+	err = p.retry(ctx, func(ctx context.Context) error {
+		return p.producer.SendOffsetsToTransaction(ctx, nextOffsets(p.batch), metadata)
+	})
+	if err != nil {
+		return classify(fmt.Errorf("failed to send offsets to transaction: %w", err))
+	}
+	if err := p.retry(ctx, p.producer.CommitTransaction); err != nil {
+		return classify(fmt.Errorf("failed to commit transaction: %w", err))
+	}
+	return nil
+}
+
+// handle runs the handler, turning a panic into an error.
+func (p *TransactionalProcessor) handle(ctx context.Context, handler TransactionalHandler, tx *Transaction, msgs []Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errorx.Tag(fmt.Errorf("handler panic: %v", r), errorx.ClassInternal)
+		}
+	}()
+	return handler(ctx, tx, msgs)
+}
+
+// skip commits the offsets of the batch in a transaction without outputs.
+func (p *TransactionalProcessor) skip(ctx context.Context) error {
+	if err := p.producer.BeginTransaction(); err != nil {
+		return classify(fmt.Errorf("failed to begin transaction: %w", err))
+	}
+	return p.commit(ctx)
+}
+
+// abort aborts the transaction.
+func (p *TransactionalProcessor) abort(ctx context.Context) error {
+	if err := p.retry(ctx, p.producer.AbortTransaction); err != nil {
+		return classify(fmt.Errorf("failed to abort transaction: %w", err))
+	}
+	return nil
+}
+
+// rewind rewinds the consumer to the first message of the batch of each
+// partition.
+func (p *TransactionalProcessor) rewind() {
+	seen := make(map[partitionKey]bool)
+	for _, km := range p.batch {
+		key := partitionKey{topic: *km.TopicPartition.Topic, partition: km.TopicPartition.Partition}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if err := p.consumer.Seek(km.TopicPartition, 0); err != nil {
+			p.onError(classify(fmt.Errorf("failed to rewind %s[%d] to %d: %w", key.topic, key.partition, km.TopicPartition.Offset, err)))
+		}
+	}
+}
+
+// retry calls f until it succeeds, returns an error that is not retriable
+// or the transaction timeout elapsed, waiting the retry backoff between
+// attempts.
+func (p *TransactionalProcessor) retry(ctx context.Context, f func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	for {
+		err := f(ctx)
+		var kerr interface{ IsRetriable() bool }
+		if err == nil || !errors.As(err, &kerr) || !kerr.IsRetriable() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.retryBackoff):
+		}
+	}
+}
+
+// rebalance drops the messages of revoked partitions from the batch being
+// collected; they were not handled, so their new owner consumes them from
+// the committed offset.
+func (p *TransactionalProcessor) rebalance(_ *kafka.Consumer, e kafka.Event) error {
+	revoked, ok := e.(kafka.RevokedPartitions)
+	if !ok {
+		return nil
+	}
+	gone := make(map[partitionKey]bool, len(revoked.Partitions))
+	for _, tp := range revoked.Partitions {
+		gone[partitionKey{topic: *tp.Topic, partition: tp.Partition}] = true
+	}
+	kept := p.batch[:0]
+	for _, km := range p.batch {
+		if !gone[partitionKey{topic: *km.TopicPartition.Topic, partition: km.TopicPartition.Partition}] {
+			kept = append(kept, km)
+		}
+	}
+	p.batch = kept
+	return nil
+}
+
+// drainDeliveries serves the delivery reports, which librdkafka requires
+// while committing or aborting. librdkafka itself fails the commit of a
+// transaction whose message was not delivered; the failed deliveries are
+// reported to the error handler so that the cause of the abort is visible.
+func (p *TransactionalProcessor) drainDeliveries(done <-chan struct{}) {
+	for {
+		select {
+		case e := <-p.deliveries:
+			m, ok := e.(*kafka.Message)
+			if !ok || m.TopicPartition.Error == nil {
+				continue
+			}
+			var topic string
+			if m.TopicPartition.Topic != nil {
+				topic = *m.TopicPartition.Topic
+			}
+			p.onError(classify(fmt.Errorf("delivery to %s[%d] failed: %w", topic, m.TopicPartition.Partition, m.TopicPartition.Error)))
+		case <-done:
+			return
+		}
+	}
+}
+
+// Transaction publishes the outputs of a batch.
+type Transaction struct {
+	processor *TransactionalProcessor
+	err       error // First produce error, aborting the transaction
+}
+
+// Publish adds msg to the transaction. It is delivered to consumers only
+// if the transaction commits. The trace context, request ID and tenant ID
+// of ctx are added to its headers.
+func (tx *Transaction) Publish(ctx context.Context, topic string, msg ProducerMessage) error {
+	p := tx.processor
+	payload, err := p.serializer.Serialize(topic, msg.Value)
+	if err != nil {
+		return errorx.Tag(fmt.Errorf("failed to serialize: %w", err), errorx.ClassValidation)
+	}
+	partition := kafka.PartitionAny
+	if msg.Partition != nil {
+		partition = *msg.Partition
+	}
+	err = p.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
+		Key:            msg.Key,
+		Value:          payload,
+		Headers:        toKafkaHeaders(InjectHeaders(ctx, eventHeaders(msg.Value, msg.Headers))),
+		Timestamp:      msg.Timestamp,
+	}, p.deliveries)
+	if err != nil {
+		err = classify(fmt.Errorf("produce failed: %w", err))
+		if tx.err == nil {
+			tx.err = err
+		}
+	}
+	return err
+}
+
+// nextOffsets returns the offsets following the last message of each
+// partition of batch.
+func nextOffsets(batch []*kafka.Message) []kafka.TopicPartition {
+	index := make(map[partitionKey]int)
+	var offsets []kafka.TopicPartition
+	for _, km := range batch {
+		key := partitionKey{topic: *km.TopicPartition.Topic, partition: km.TopicPartition.Partition}
+		next := km.TopicPartition
+		next.Offset++
+		if i, ok := index[key]; ok {
+			if next.Offset > offsets[i].Offset {
+				offsets[i] = next
+			}
+			continue
+		}
+		index[key] = len(offsets)
+		offsets = append(offsets, next)
+	}
+	return offsets
+}
+
+// isFatalTxnError reports whether err left the producer unusable.
+func isFatalTxnError(err error) bool {
+	var kerr interface{ IsFatal() bool }
+	return errors.As(err, &kerr) && kerr.IsFatal()
+}
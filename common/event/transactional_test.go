@@ -0,0 +1,294 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTxProducer keeps the messages of the open transaction apart from the
+// committed ones.
+type fakeTxProducer struct {
+	mu        sync.Mutex
+	pending   []*kafka.Message
+	committed []*kafka.Message
+	offsets   map[int32]kafka.Offset
+	aborts    int
+	commitErr error
+	retriable int         // Commits failing with a retriable error first
+	commits   []time.Time // Commit attempts
+}
+
+func (p *fakeTxProducer) InitTransactions(ctx context.Context) error { return nil }
+func (p *fakeTxProducer) BeginTransaction() error                    { return nil }
+
+func (p *fakeTxProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, msg)
+	return nil
+}
+
+func (p *fakeTxProducer) SendOffsetsToTransaction(ctx context.Context, offsets []kafka.TopicPartition, _ *kafka.ConsumerGroupMetadata) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tp := range offsets {
+		p.offsets[tp.Partition] = tp.Offset
+	}
+	return nil
+}
+
+func (p *fakeTxProducer) CommitTransaction(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.commits = append(p.commits, time.Now())
+	if p.retriable > 0 {
+		p.retriable--
+		return retriableTxnError{}
+	}
+	if p.commitErr != nil {
+		return p.commitErr
+	}
+	p.committed = append(p.committed, p.pending...)
+	p.pending = nil
+	return nil
+}
+
+func (p *fakeTxProducer) AbortTransaction(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.aborts++
+	p.pending = nil
+	return nil
+}
+
+func (p *fakeTxProducer) state() (committed int, offsets map[int32]kafka.Offset, aborts int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	offsets = make(map[int32]kafka.Offset, len(p.offsets))
+	for k, v := range p.offsets {
+		offsets[k] = v
+	}
+	return len(p.committed), offsets, p.aborts
+}
+
+// fakeTxConsumer serves its log from Poll; Seek serves it again from the
+// given offset.
+type fakeTxConsumer struct {
+	events chan kafka.Event
+	log    []*kafka.Message
+
+	mu    sync.Mutex
+	seeks []kafka.TopicPartition
+}
+
+func newFakeTxConsumer(msgs ...*kafka.Message) *fakeTxConsumer {
+	c := &fakeTxConsumer{events: make(chan kafka.Event, 100), log: msgs}
+	for _, msg := range msgs {
+		c.events <- msg
+	}
+	return c
+}
+
+func (c *fakeTxConsumer) SubscribeTopics(topics []string, cb kafka.RebalanceCb) error { return nil }
+
+func (c *fakeTxConsumer) Poll(timeoutMs int) kafka.Event {
+	select {
+	case e := <-c.events:
+		return e
+	case <-time.After(time.Duration(timeoutMs) * time.Millisecond):
+		return nil
+	}
+}
+
+func (c *fakeTxConsumer) Seek(tp kafka.TopicPartition, _ int) error {
+	c.mu.Lock()
+	c.seeks = append(c.seeks, tp)
+	c.mu.Unlock()
+	for _, msg := range c.log {
+		if msg.TopicPartition.Partition == tp.Partition && msg.TopicPartition.Offset >= tp.Offset {
+			c.events <- msg
+		}
+	}
+	return nil
+}
+
+func (c *fakeTxConsumer) GetConsumerGroupMetadata() (*kafka.ConsumerGroupMetadata, error) {
+	return kafka.NewTestConsumerGroupMetadata("billing")
+}
+
+// fatalTxnError behaves like a fatal kafka.Error, e.g. a fenced producer.
+type fatalTxnError struct{}
+
+func (fatalTxnError) Error() string { return "producer fenced" }
+func (fatalTxnError) IsFatal() bool { return true }
+
+// retriableTxnError behaves like a retriable kafka.Error.
+type retriableTxnError struct{}
+
+func (retriableTxnError) Error() string     { return "coordinator loading" }
+func (retriableTxnError) IsRetriable() bool { return true }
+
+func newTestTransactionalProcessor(producer *fakeTxProducer, consumer *fakeTxConsumer) *TransactionalProcessor {
+	producer.offsets = make(map[int32]kafka.Offset)
+	return newTransactionalProcessor(producer, consumer, []string{"orders"},
+		WithTransactionalSerializer(jsonSerde{}),
+		WithTransactionalDeserializer(jsonSerde{}),
+		WithTransactionalBatchSize(3),
+		WithTransactionalBatchTimeout(20*time.Millisecond),
+		WithTransactionalRetryBackoff(10*time.Millisecond),
+		WithTransactionalErrorHandler(func(error) {}),
+	)
+}
+
+// runTransactional runs the processor in the background and returns a
+// function stopping it and returning its error.
+func runTransactional(t *testing.T, producer *fakeTxProducer, consumer *fakeTxConsumer, handler TransactionalHandler) func() error {
+	p := newTestTransactionalProcessor(producer, consumer)
+	return runInBackground(t, func(ctx context.Context) error {
+		return p.Run(ctx, handler)
+	})
+}
+
+func forwardToInvoices(ctx context.Context, tx *Transaction, msgs []Message) error {
+	for _, msg := range msgs {
+		if err := tx.Publish(ctx, "invoices", ProducerMessage{Key: msg.Key, Value: map[string]int64{"order": msg.Offset}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestTransactionalProcessorCommitsOutputsWithOffsets(t *testing.T) {
+	producer := &fakeTxProducer{}
+	consumer := newFakeTxConsumer(testMessage(0, 0), testMessage(0, 1), testMessage(1, 10))
+	stop := runTransactional(t, producer, consumer, forwardToInvoices)
+
+	require.Eventually(t, func() bool {
+		committed, _, _ := producer.state()
+		return committed == 3
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+
+	_, offsets, aborts := producer.state()
+	assert.Equal(t, map[int32]kafka.Offset{0: 2, 1: 11}, offsets)
+	assert.Zero(t, aborts)
+}
+
+func TestTransactionalProcessorAbortsAndRewinds(t *testing.T) {
+	producer := &fakeTxProducer{}
+	consumer := newFakeTxConsumer(testMessage(0, 5), testMessage(0, 6), testMessage(1, 10))
+	var attempts int
+	stop := runTransactional(t, producer, consumer, func(ctx context.Context, tx *Transaction, msgs []Message) error {
+		if err := forwardToInvoices(ctx, tx, msgs); err != nil {
+			return err
+		}
+		attempts++
+		if attempts == 1 {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	})
+
+	require.Eventually(t, func() bool {
+		_, offsets, _ := producer.state()
+		return offsets[0] == 7 && offsets[1] == 11
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+
+	committed, _, aborts := producer.state()
+	assert.Equal(t, 1, aborts)
+	assert.Equal(t, 3, committed, "outputs of the aborted transaction are discarded")
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	require.Len(t, consumer.seeks, 2)
+	assert.Equal(t, kafka.Offset(5), consumer.seeks[0].Offset)
+	assert.Equal(t, kafka.Offset(10), consumer.seeks[1].Offset)
+}
+
+func TestTransactionalProcessorReportsFailedDeliveries(t *testing.T) {
+	p := newTestTransactionalProcessor(&fakeTxProducer{}, newFakeTxConsumer())
+	errs := make(chan error, 2)
+	p.onError = func(err error) { errs <- err }
+	done := make(chan struct{})
+	defer close(done)
+	go p.drainDeliveries(done)
+
+	topic := "invoices"
+	delivered := testMessage(0, 1)
+	failed := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 2, Error: kafka.NewError(kafka.ErrMsgTimedOut, "message timed out", false)}}
+	p.deliveries <- delivered
+	p.deliveries <- failed
+
+	select {
+	case err := <-errs:
+		assert.ErrorContains(t, err, "delivery to invoices[2] failed")
+		assert.True(t, errorx.IsTimeout(err))
+	case <-time.After(time.Second):
+		t.Fatal("the failed delivery was not reported")
+	}
+	assert.Empty(t, errs)
+}
+
+func TestTransactionalProcessorStopsOnFatalError(t *testing.T) {
+	producer := &fakeTxProducer{commitErr: fatalTxnError{}}
+	consumer := newFakeTxConsumer(testMessage(0, 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := newTestTransactionalProcessor(producer, consumer).Run(ctx, forwardToInvoices)
+	require.Error(t, err)
+	assert.True(t, isFatalTxnError(err))
+	_, _, aborts := producer.state()
+	assert.Zero(t, aborts)
+}
+
+func TestTransactionalProcessorSkipsValidationErrors(t *testing.T) {
+	producer := &fakeTxProducer{}
+	consumer := newFakeTxConsumer(testMessage(0, 0), testMessage(0, 1), testMessage(1, 10))
+	stop := runTransactional(t, producer, consumer, func(ctx context.Context, tx *Transaction, msgs []Message) error {
+		if err := forwardToInvoices(ctx, tx, msgs); err != nil {
+			return err
+		}
+		return errorx.Tag(errors.New("undecodable order"), errorx.ClassValidation)
+	})
+
+	require.Eventually(t, func() bool {
+		_, offsets, _ := producer.state()
+		return offsets[0] == 2 && offsets[1] == 11
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+
+	committed, _, aborts := producer.state()
+	assert.Zero(t, committed, "the outputs of a skipped batch are discarded")
+	assert.Equal(t, 1, aborts)
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	assert.Empty(t, consumer.seeks)
+}
+
+func TestTransactionalProcessorRetryBacksOff(t *testing.T) {
+	producer := &fakeTxProducer{retriable: 2}
+	consumer := newFakeTxConsumer(testMessage(0, 0))
+	stop := runTransactional(t, producer, consumer, forwardToInvoices)
+
+	require.Eventually(t, func() bool {
+		committed, _, _ := producer.state()
+		return committed == 1
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, stop())
+
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	require.Len(t, producer.commits, 3)
+	for i := 1; i < len(producer.commits); i++ {
+		assert.GreaterOrEqual(t, producer.commits[i].Sub(producer.commits[i-1]), 10*time.Millisecond)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/solum-sp/aps-be-common/common/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.opentelemetry.io/otel/trace"
@@ -227,3 +228,27 @@ func TestOpenTelemetryDecorator(t *testing.T) {
 		mockLogger.AssertExpectations(t)
 	})
 }
+
+func TestWithContext(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	base := &zapLogger{logger: zap.New(core), service: "test-service"}
+
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x01},
+		SpanID:  trace.SpanID{0x02},
+	}))
+	ctx = utils.WithRequestID(ctx, "req-1")
+	ctx = utils.WithTenantID(ctx, "tenant-1")
+
+	WithContext(base, ctx).Info("handled")
+	WithContext(base, context.Background()).Info("no context")
+
+	entries := recorded.All()
+	assert.Len(t, entries, 2)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "01000000000000000000000000000000", fields["trace_id"])
+	assert.Equal(t, "0200000000000000", fields["span_id"])
+	assert.Equal(t, "req-1", fields["request_id"])
+	assert.Equal(t, "tenant-1", fields["tenant_id"])
+	assert.NotContains(t, entries[1].ContextMap(), "trace_id")
+}
@@ -3,9 +3,30 @@ package logger
 import (
 	"context"
 
+	"github.com/solum-sp/aps-be-common/common/utils"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// WithContext returns l with the trace ID, span ID, request ID and tenant ID
+// of ctx added as fields, for instance the context of a consumed Kafka
+// message. Values absent from ctx are left out.
+func WithContext(l ILogger, ctx context.Context) ILogger {
+	var fields []interface{}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+	if requestID := utils.RequestIDFromContext(ctx); requestID != "" {
+		fields = append(fields, "request_id", requestID)
+	}
+	if tenantID := utils.TenantIDFromContext(ctx); tenantID != "" {
+		fields = append(fields, "tenant_id", tenantID)
+	}
+	if len(fields) == 0 {
+		return l
+	}
+	return l.With(fields...)
+}
+
 type OpenTelemetryDecorator struct{}
 
 func NewOpenTelemetryDecorator() *OpenTelemetryDecorator {
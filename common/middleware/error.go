@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/google/uuid"
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/solum-sp/aps-be-common/common/logger"
+	"github.com/solum-sp/aps-be-common/common/utils"
+)
+
+// HandlerFunc is an HTTP handler that returns its error instead of writing
+// the response itself.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// ErrorMiddleware adapts a HandlerFunc to an http.Handler. Returned and
+// panicked errors are written with errorx.WriteError and logged.
+func ErrorMiddleware(log logger.ILogger) func(HandlerFunc) http.Handler {
+	return func(next HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &responseWriter{ResponseWriter: w}
+			defer recoverPanic(log, rw, r)
+
+			if err := next(rw, r); err != nil {
+				handleError(log, rw, r, err, nil)
+			}
+		})
+	}
+}
+
+// RecoveryMiddleware turns panics of the next handler into an error response
+// instead of dropping the connection.
+func RecoveryMiddleware(log logger.ILogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &responseWriter{ResponseWriter: w}
+			defer recoverPanic(log, rw, r)
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// RequestIDMiddleware makes sure every request has an ID: the X-Request-ID
+// header is reused or generated, stored in the request context and echoed in
+// the response.
+func RequestIDMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(utils.RequestIDHeader)
+			if requestID == "" {
+				requestID = uuid.NewString()
+			}
+			w.Header().Set(utils.RequestIDHeader, requestID)
+			next.ServeHTTP(w, r.WithContext(utils.WithRequestID(r.Context(), requestID)))
+		})
+	}
+}
+
+func recoverPanic(log logger.ILogger, w *responseWriter, r *http.Request) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+	if rec == http.ErrAbortHandler {
+		panic(rec)
+	}
+
+	err, ok := rec.(error)
+	if !ok {
+		err = fmt.Errorf("panic: %v", rec)
+	}
+	handleError(log, w, r, err, debug.Stack())
+}
+
+func handleError(log logger.ILogger, w *responseWriter, r *http.Request, err error, stack []byte) {
+	status := errorx.HTTPStatus(err)
+	fields := []interface{}{
+		"error", err.Error(),
+		"code", errorx.FromError(err).Code,
+		"status", status,
+		"method", r.Method,
+		"path", r.URL.Path,
+	}
+	if requestID := utils.RequestIDFromContext(r.Context()); requestID != "" {
+		fields = append(fields, "request_id", requestID)
+	}
+	if stack != nil {
+		fields = append(fields, "panic_stack", string(stack))
+	}
+
+	if status >= http.StatusInternalServerError || stack != nil {
+		log.Error("request failed", fields...)
+	} else {
+		log.Warn("request failed", fields...)
+	}
+
+	// The handler already started the response; all we can do is log.
+	if w.wroteHeader {
+		return
+	}
+	errorx.WriteError(w, r, err)
+}
+
+// responseWriter records whether the response has been started.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/solum-sp/aps-be-common/common/logger"
+	"github.com/solum-sp/aps-be-common/common/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordedLog struct {
+	level  string
+	msg    string
+	fields []interface{}
+}
+
+type recordingLogger struct {
+	logs []recordedLog
+}
+
+func (l *recordingLogger) record(level, msg string, fields []interface{}) {
+	l.logs = append(l.logs, recordedLog{level: level, msg: msg, fields: fields})
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...interface{})   { l.record("debug", msg, fields) }
+func (l *recordingLogger) Info(msg string, fields ...interface{})    { l.record("info", msg, fields) }
+func (l *recordingLogger) Warn(msg string, fields ...interface{})    { l.record("warn", msg, fields) }
+func (l *recordingLogger) Error(msg string, fields ...interface{})   { l.record("error", msg, fields) }
+func (l *recordingLogger) Fatal(msg string, fields ...interface{})   { l.record("fatal", msg, fields) }
+func (l *recordingLogger) With(fields ...interface{}) logger.ILogger { return l }
+
+func TestErrorMiddleware(t *testing.T) {
+	t.Run("returned error", func(t *testing.T) {
+		log := &recordingLogger{}
+		handler := ErrorMiddleware(log)(func(w http.ResponseWriter, r *http.Request) error {
+			return errorx.New("104041", "User does not exist")
+		})
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/users/1", nil))
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		var resp errorx.Response
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "104041", resp.Code)
+		assert.Len(t, log.logs, 1)
+		assert.Equal(t, "warn", log.logs[0].level)
+	})
+
+	t.Run("panicked error", func(t *testing.T) {
+		log := &recordingLogger{}
+		handler := ErrorMiddleware(log)(func(w http.ResponseWriter, r *http.Request) error {
+			panic(errors.New("nil map"))
+		})
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.Contains(t, rr.Body.String(), errorx.CodeUnknown)
+		assert.Len(t, log.logs, 1)
+		assert.Equal(t, "error", log.logs[0].level)
+		assert.Contains(t, log.logs[0].fields, "panic_stack")
+	})
+
+	t.Run("error after response started", func(t *testing.T) {
+		log := &recordingLogger{}
+		handler := ErrorMiddleware(log)(func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusAccepted)
+			return errors.New("stream broken")
+		})
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		assert.Empty(t, rr.Body.String())
+		assert.Len(t, log.logs, 1)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		log := &recordingLogger{}
+		handler := ErrorMiddleware(log)(func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusOK)
+			return nil
+		})
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, log.logs)
+	})
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	log := &recordingLogger{}
+	handler := RecoveryMiddleware(log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, errorx.ContentTypeJSON, rr.Header().Get("Content-Type"))
+	assert.Len(t, log.logs, 1)
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := RequestIDMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = utils.RequestIDFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(utils.RequestIDHeader, "req-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "req-1", seen)
+	assert.Equal(t, "req-1", rr.Header().Get(utils.RequestIDHeader))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.NotEmpty(t, seen)
+	assert.Equal(t, seen, rr.Header().Get(utils.RequestIDHeader))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"aidanwoods.dev/go-paseto"
+	"github.com/solum-sp/aps-be-common/common/errorx"
 )
 
 type PasetoTokenManager struct {
@@ -44,12 +45,12 @@ func (m *PasetoTokenManager) ValidateToken(t string) (*TokenClaims, error) {
 	// Parse and verify the token
 	token, err := parser.ParseV4Public(m.publicKey, t, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
+		return nil, errorx.Tag(fmt.Errorf("failed to parse token: %w", err), errorx.ClassValidation)
 	}
 
 	claims, err := parseToClaims(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse claims: %w", err)
+		return nil, errorx.Tag(fmt.Errorf("failed to parse claims: %w", err), errorx.ClassValidation)
 	}
 
 	return claims, nil
@@ -81,12 +82,12 @@ func NewPasetoTokenParser(publicKey string) (*PasetoTokenParser, error) {
 func (p *PasetoTokenParser) ParseToken(t string) (*TokenClaims, error) {
 	token, err := p.parser.ParseV4Public(p.publicKey, t, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
+		return nil, errorx.Tag(fmt.Errorf("failed to parse token: %w", err), errorx.ClassValidation)
 	}
 
 	claims, err := parseToClaims(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse claims: %w", err)
+		return nil, errorx.Tag(fmt.Errorf("failed to parse claims: %w", err), errorx.ClassValidation)
 	}
 
 	return claims, nil
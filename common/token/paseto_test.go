@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"aidanwoods.dev/go-paseto"
+	"github.com/solum-sp/aps-be-common/common/errorx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -182,6 +183,7 @@ func TestPasetoTokenSvc_ValidateToken(t *testing.T) {
 			claims, err := service.ValidateToken(tt.token)
 			if tt.wantErr {
 				assert.Error(t, err)
+				assert.True(t, errorx.IsValidation(err))
 				assert.Nil(t, claims)
 			} else {
 				assert.NoError(t, err)
@@ -310,6 +312,7 @@ func TestPasetoTokenParser_ParseToken(t *testing.T) {
 			claims, err := parser.ParseToken(tt.token)
 			if tt.wantErr {
 				assert.Error(t, err)
+				assert.True(t, errorx.IsValidation(err))
 				assert.Nil(t, claims)
 			} else {
 				assert.NoError(t, err)
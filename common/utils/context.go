@@ -0,0 +1,35 @@
+package utils
+
+import "context"
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDCtxKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID stored by WithRequestID, or ""
+// if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return requestID
+}
+
+const TenantIDHeader = "X-Tenant-ID"
+
+type tenantIDCtxKey struct{}
+
+// WithTenantID returns a copy of ctx carrying the tenant ID.
+func WithTenantID(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantIDCtxKey{}, tenantID)
+}
+
+// TenantIDFromContext returns the tenant ID stored by WithTenantID, or "" if
+// there is none.
+func TenantIDFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantIDCtxKey{}).(string)
+	return tenantID
+}
@@ -21,3 +21,25 @@ func Retry[T any](attempts int, sleep time.Duration, f func() (T, error)) (resul
 	}
 	return result, fmt.Errorf("after %d attempts, last error: %s", attempts, err)
 }
+
+// RetryIf is like Retry but gives up as soon as shouldRetry returns false for
+// an error, e.g. RetryIf(3, time.Second, errorx.IsRetryable, f). The last
+// error is wrapped so callers can still inspect it with errors.Is/As.
+func RetryIf[T any](attempts int, sleep time.Duration, shouldRetry func(error) bool, f func() (T, error)) (result T, err error) {
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			log.Printf("Failed to execute function, attempt %d/%d: %s\n", i, attempts, err)
+			log.Printf("Sleeping for %s\n", sleep)
+			time.Sleep(sleep)
+			sleep *= 2
+		}
+		result, err = f()
+		if err == nil {
+			return result, nil
+		}
+		if !shouldRetry(err) {
+			return result, err
+		}
+	}
+	return result, fmt.Errorf("after %d attempts, last error: %w", attempts, err)
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTemporary = errors.New("temporary")
+
+func TestRetryIf(t *testing.T) {
+	isTemporary := func(err error) bool { return errors.Is(err, errTemporary) }
+
+	calls := 0
+	v, err := RetryIf(3, 0, isTemporary, func() (int, error) {
+		calls++
+		if calls < 2 {
+			return 0, errTemporary
+		}
+		return 42, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	permanent := errors.New("permanent")
+	_, err = RetryIf(3, 0, isTemporary, func() (int, error) {
+		calls++
+		return 0, permanent
+	})
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	_, err = RetryIf(3, 0, isTemporary, func() (int, error) {
+		calls++
+		return 0, errTemporary
+	})
+	assert.ErrorIs(t, err, errTemporary)
+	assert.Equal(t, 3, calls)
+}
@@ -0,0 +1,311 @@
+// Package validate checks request structs against rules declared in their
+// `validate` struct tags and reports every failure at once as an
+// *errorx.ValidationError:
+//
+//	type CreateUserRequest struct {
+//		Name  string   `json:"name" validate:"required,min=3,max=50"`
+//		Email string   `json:"email" validate:"required,email"`
+//		Role  string   `json:"role" validate:"oneof=admin member"`
+//		Tags  []string `json:"tags" validate:"max=5"`
+//	}
+//
+// Fields are named after their json tag. Nested structs, pointers and
+// slices of structs are validated recursively ("items[0].name"). A required
+// pointer only needs to be non-nil, so that *int or *bool fields can carry
+// explicit zero values. The code of
+// a field error is the rule name; registering that code in the errorx
+// catalog localizes its message.
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+)
+
+const CodeInvalidJSON = "invalid_json"
+
+// RuleFunc reports whether value satisfies a rule. param is the text after
+// "=" in the tag, e.g. "3" for "min=3".
+type RuleFunc func(value reflect.Value, param string) (bool, error)
+
+type rule struct {
+	message string
+	check   RuleFunc
+}
+
+// Validator holds a set of rules. The zero value is not usable; create one
+// with New. A Validator is safe for concurrent use once its rules are
+// registered.
+type Validator struct {
+	mu    sync.RWMutex
+	rules map[string]rule
+}
+
+var defaultValidator = New()
+
+// New returns a Validator with the built-in rules: required, min, max, len,
+// email and oneof.
+func New() *Validator {
+	v := &Validator{rules: make(map[string]rule)}
+	v.Register("required", "This field is required", checkRequired)
+	v.Register("min", "Must be at least {{.min}}", checkMin)
+	v.Register("max", "Must be at most {{.max}}", checkMax)
+	v.Register("len", "Must have a length of {{.len}}", checkLen)
+	v.Register("email", "Must be a valid email address", checkEmail)
+	v.Register("oneof", "Must be one of {{.oneof}}", checkOneOf)
+	return v
+}
+
+// Register adds or replaces the rule name. message is the default message of
+// its field errors; it can refer to the tag parameter as {{.<name>}}.
+func (v *Validator) Register(name, message string, check RuleFunc) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rules[name] = rule{message: message, check: check}
+}
+
+// Register adds a rule to the default validator.
+func Register(name, message string, check RuleFunc) {
+	defaultValidator.Register(name, message, check)
+}
+
+// Struct validates s with the default validator.
+func Struct(s interface{}) error {
+	return defaultValidator.Struct(s)
+}
+
+// DecodeJSON decodes the JSON body of r into dst and validates it with the
+// default validator. A malformed body is reported as a field error with code
+// CodeInvalidJSON.
+func DecodeJSON(r *http.Request, dst interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		field := ""
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			field = typeErr.Field
+		}
+		return errorx.NewValidationError().Add(field, CodeInvalidJSON, "Malformed JSON body", nil).Err()
+	}
+	return Struct(dst)
+}
+
+// Struct validates the fields of s, a struct or a pointer to a struct. It
+// returns nil, an *errorx.ValidationError listing every invalid field, or an
+// error if a tag refers to an unknown rule or has an invalid parameter.
+func (v *Validator) Struct(s interface{}) error {
+	val := reflect.ValueOf(s)
+	for val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return fmt.Errorf("validate: nil %s", val.Type())
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return fmt.Errorf("validate: %s is not a struct", val.Type())
+	}
+
+	verr := errorx.NewValidationError()
+	if err := v.validateStruct(val, "", verr); err != nil {
+		return err
+	}
+	return verr.Err()
+}
+
+func (v *Validator) validateStruct(val reflect.Value, path string, verr *errorx.ValidationError) error {
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := fieldName(f)
+		if name == "-" {
+			continue
+		}
+		fieldPath := joinPath(path, name)
+		fv := val.Field(i)
+
+		if tag := f.Tag.Get("validate"); tag != "" && tag != "-" {
+			if err := v.validateField(fv, tag, fieldPath, verr); err != nil {
+				return fmt.Errorf("validate: field %s: %w", fieldPath, err)
+			}
+		}
+		if err := v.validateNested(fv, fieldPath, verr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Validator) validateNested(val reflect.Value, path string, verr *errorx.ValidationError) error {
+	for val.Kind() == reflect.Pointer || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.Struct:
+		if val.Type().PkgPath() == "time" {
+			return nil
+		}
+		return v.validateStruct(val, path, verr)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if err := v.validateNested(val.Index(i), fmt.Sprintf("%s[%d]", path, i), verr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *Validator) validateField(val reflect.Value, tag, path string, verr *errorx.ValidationError) error {
+	rules := strings.Split(tag, ",")
+	required := false
+	for _, r := range rules {
+		if r == "required" {
+			required = true
+		}
+	}
+	// Optional fields are only checked when set.
+	if !required && isZero(val) {
+		return nil
+	}
+	pointer := val.Kind() == reflect.Pointer
+	for val.Kind() == reflect.Pointer && !val.IsNil() {
+		val = val.Elem()
+	}
+
+	for _, r := range rules {
+		name, param, _ := strings.Cut(strings.TrimSpace(r), "=")
+		if name == "" {
+			continue
+		}
+		v.mu.RLock()
+		rl, ok := v.rules[name]
+		v.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown rule %q", name)
+		}
+		var (
+			valid bool
+			err   error
+		)
+		if name == "required" && pointer {
+			// The pointer is set if it was dereferenced down to a value
+			valid = val.Kind() != reflect.Pointer
+		} else if valid, err = rl.check(val, param); err != nil {
+			return fmt.Errorf("rule %s: %w", name, err)
+		}
+		if !valid {
+			verr.Add(path, name, rl.message, map[string]interface{}{name: param})
+			if name == "required" {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func fieldName(f reflect.StructField) string {
+	if tag := f.Tag.Get("json"); tag != "" {
+		if name, _, _ := strings.Cut(tag, ","); name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func isZero(val reflect.Value) bool {
+	return !val.IsValid() || val.IsZero()
+}
+
+func checkRequired(val reflect.Value, _ string) (bool, error) {
+	if isZero(val) {
+		return false, nil
+	}
+	switch val.Kind() {
+	case reflect.Slice, reflect.Map:
+		return val.Len() > 0, nil
+	}
+	return true, nil
+}
+
+// size returns the length of strings (in characters), slices and maps, and
+// the value of numbers.
+func size(val reflect.Value) (float64, error) {
+	switch val.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(val.String())), nil
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(val.Len()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), nil
+	}
+	return 0, fmt.Errorf("unsupported kind %s", val.Kind())
+}
+
+func compare(val reflect.Value, param string, ok func(size, limit float64) bool) (bool, error) {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid parameter %q", param)
+	}
+	n, err := size(val)
+	if err != nil {
+		return false, err
+	}
+	return ok(n, limit), nil
+}
+
+func checkMin(val reflect.Value, param string) (bool, error) {
+	return compare(val, param, func(n, limit float64) bool { return n >= limit })
+}
+
+func checkMax(val reflect.Value, param string) (bool, error) {
+	return compare(val, param, func(n, limit float64) bool { return n <= limit })
+}
+
+func checkLen(val reflect.Value, param string) (bool, error) {
+	return compare(val, param, func(n, limit float64) bool { return n == limit })
+}
+
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+func checkEmail(val reflect.Value, _ string) (bool, error) {
+	if val.Kind() != reflect.String {
+		return false, fmt.Errorf("unsupported kind %s", val.Kind())
+	}
+	return emailRegexp.MatchString(val.String()), nil
+}
+
+func checkOneOf(val reflect.Value, param string) (bool, error) {
+	s := fmt.Sprint(val.Interface())
+	for _, option := range strings.Fields(param) {
+		if s == option {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,135 @@
+package validate
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/solum-sp/aps-be-common/common/errorx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type address struct {
+	City string `json:"city" validate:"required"`
+}
+
+type item struct {
+	SKU      string `json:"sku" validate:"required,len=6"`
+	Quantity int    `json:"quantity" validate:"min=1,max=99"`
+}
+
+type createOrder struct {
+	Name     string   `json:"name" validate:"required,min=3,max=10"`
+	Email    string   `json:"email" validate:"required,email"`
+	Status   string   `json:"status" validate:"oneof=draft paid"`
+	Note     string   `json:"note" validate:"max=5"`
+	Address  *address `json:"address"`
+	Items    []item   `json:"items" validate:"required"`
+	internal string   `validate:"required"`
+}
+
+func TestStructValid(t *testing.T) {
+	req := createOrder{
+		Name:    "john",
+		Email:   "john@example.com",
+		Address: &address{City: "Hanoi"},
+		Items:   []item{{SKU: "ABC123", Quantity: 2}},
+	}
+	assert.NoError(t, Struct(req))
+	assert.NoError(t, Struct(&req))
+}
+
+func TestStructCollectsAllErrors(t *testing.T) {
+	req := createOrder{
+		Name:    "jo",
+		Email:   "not-an-email",
+		Status:  "shipped",
+		Address: &address{},
+		Items:   []item{{SKU: "ABC123", Quantity: 1}, {SKU: "A", Quantity: 100}},
+	}
+	err := Struct(req)
+	var verr *errorx.ValidationError
+	assert.True(t, errors.As(err, &verr))
+
+	got := make(map[string]string)
+	for _, f := range verr.Fields {
+		got[f.Field] = f.Code
+	}
+	assert.Equal(t, map[string]string{
+		"name":              "min",
+		"email":             "email",
+		"status":            "oneof",
+		"address.city":      "required",
+		"items[1].sku":      "len",
+		"items[1].quantity": "max",
+	}, got)
+	assert.Equal(t, "Must be at least 3", verr.Fields[0].Message)
+	assert.Equal(t, http.StatusBadRequest, errorx.HTTPStatus(err))
+
+	err = Struct(createOrder{})
+	assert.True(t, errors.As(err, &verr))
+	assert.Len(t, verr.Fields, 3, "required stops at the first failure and optional fields are skipped")
+}
+
+func TestStructRequiredPointer(t *testing.T) {
+	type settings struct {
+		Limit   *int  `json:"limit" validate:"required,max=10"`
+		Enabled *bool `json:"enabled" validate:"required"`
+	}
+	zero, off := 0, false
+	assert.NoError(t, Struct(settings{Limit: &zero, Enabled: &off}))
+
+	err := Struct(settings{})
+	var verr *errorx.ValidationError
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, []string{"limit", "enabled"}, []string{verr.Fields[0].Field, verr.Fields[1].Field})
+	assert.Equal(t, "required", verr.Fields[0].Code)
+
+	eleven := 11
+	err = Struct(settings{Limit: &eleven, Enabled: &off})
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, "max", verr.Fields[0].Code)
+}
+
+func TestStructErrors(t *testing.T) {
+	assert.Error(t, Struct("not a struct"))
+	assert.Error(t, Struct((*createOrder)(nil)))
+
+	type unknownRule struct {
+		Name string `validate:"uppercase"`
+	}
+	err := Struct(unknownRule{Name: "x"})
+	assert.Error(t, err)
+	assert.False(t, errorx.IsValidation(err))
+}
+
+func TestCustomRule(t *testing.T) {
+	v := New()
+	v.Register("uppercase", "Must be upper case", func(val reflect.Value, _ string) (bool, error) {
+		return strings.ToUpper(val.String()) == val.String(), nil
+	})
+	type code struct {
+		Value string `json:"value" validate:"uppercase"`
+	}
+	assert.NoError(t, v.Struct(code{Value: "ABC"}))
+	assert.Error(t, v.Struct(code{Value: "abc"}))
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var req createOrder
+	r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"name": 1}`))
+	err := DecodeJSON(r, &req)
+	var verr *errorx.ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Equal(t, CodeInvalidJSON, verr.Fields[0].Code)
+	assert.Equal(t, "name", verr.Fields[0].Field)
+
+	r = httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"name": "john"}`))
+	err = DecodeJSON(r, &req)
+	assert.True(t, errors.As(err, &verr))
+	assert.Equal(t, "email", verr.Fields[0].Field)
+}